@@ -0,0 +1,282 @@
+package authorize
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+// APIKey is the stored form of an API key, the key itself is only known to its owner and stored as sha256 hash.
+type APIKey struct {
+	ID         string           `json:"id"`
+	UserID     string           `json:"userId"`
+	TenantID   string           `json:"tenantId,omitempty"`
+	Name       string           `json:"name"`
+	Hash       string           `json:"hash"`
+	Scopes     []string         `json:"scopes,omitempty"`
+	Claims     tokenutil.Claims `json:"claims,omitempty"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	ExpiresAt  time.Time        `json:"expiresAt,omitempty"` // zero for keys without expiry
+	LastUsedAt time.Time        `json:"lastUsedAt,omitempty"`
+}
+
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, key *APIKey) error
+	// GetAPIKey returns ErrAPIKeyNotFound if there is no key with the id.
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error)
+	DeleteAPIKey(ctx context.Context, id string) error
+	TouchAPIKey(ctx context.Context, id string, lastUsedAt time.Time) error
+}
+
+type APIKeyConfig struct {
+	Prefix        string        // prefix of generated keys, default "ck"
+	TouchInterval time.Duration // how often the last used time is written, default 1m
+}
+
+func (c *APIKeyConfig) init() {
+	if c.Prefix == "" {
+		c.Prefix = "ck"
+	}
+	if c.TouchInterval <= 0 {
+		c.TouchInterval = time.Minute
+	}
+}
+
+// APIKeys creates and validates API keys for machine clients. Keys have the form "<prefix>_<id>_<secret>",
+// the id locates the stored key without revealing the secret.
+type APIKeys struct {
+	rep    APIKeyRepository
+	config APIKeyConfig
+	now    func() time.Time
+}
+
+func NewAPIKeys(rep APIKeyRepository, config APIKeyConfig) *APIKeys {
+	config.init()
+	return &APIKeys{rep: rep, config: config, now: time.Now}
+}
+
+// Create generates a key for the user of the tenant of ctx, the key is returned once and can not be recovered.
+// The claims are attached to requests authenticated by the key, a ttl of 0 creates a key without expiry.
+func (k *APIKeys) Create(ctx context.Context, userID, name string, scopes []string, claims tokenutil.Claims, ttl time.Duration) (string, *APIKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	plain := k.config.Prefix + "_" + hex.EncodeToString(id) + "_" + hex.EncodeToString(secret)
+	key := &APIKey{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		TenantID:  tokenutil.TenantFromContext(ctx),
+		Name:      name,
+		Hash:      hashAPIKey(plain),
+		Scopes:    scopes,
+		Claims:    claims,
+		CreatedAt: k.now(),
+	}
+	if ttl > 0 {
+		key.ExpiresAt = key.CreatedAt.Add(ttl)
+	}
+	if err := k.rep.CreateAPIKey(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return plain, key, nil
+}
+
+func (k *APIKeys) List(ctx context.Context, userID string) ([]*APIKey, error) {
+	return k.rep.ListAPIKeys(ctx, userID)
+}
+
+// Revoke deletes the key of the user, keys of other users are reported as ErrAPIKeyNotFound.
+func (k *APIKeys) Revoke(ctx context.Context, userID, id string) error {
+	key, err := k.rep.GetAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	return k.rep.DeleteAPIKey(ctx, id)
+}
+
+// Validate returns the stored key of plain, ErrInvalidAPIKey is returned for unknown, revoked or expired keys.
+func (k *APIKeys) Validate(ctx context.Context, plain string) (*APIKey, error) {
+	id, ok := k.parse(plain)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	key, err := k.rep.GetAPIKey(ctx, id)
+	if err == ErrAPIKeyNotFound {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashAPIKey(plain))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := k.now()
+	if key.Expired(now) {
+		return nil, ErrInvalidAPIKey
+	}
+	if now.Sub(key.LastUsedAt) >= k.config.TouchInterval {
+		key.LastUsedAt = now
+		if err = k.rep.TouchAPIKey(ctx, id, now); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// owns reports whether the text has the format of a key generated by k.
+func (k *APIKeys) owns(text string) bool {
+	_, ok := k.parse(text)
+	return ok
+}
+
+func (k *APIKeys) parse(plain string) (string, bool) {
+	parts := strings.Split(plain, "_")
+	if len(parts) != 3 || parts[0] != k.config.Prefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func hashAPIKey(plain string) string {
+	sum := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithAPIKeys enable API key authentication, the HTTP middleware accepts keys in the X-API-Key header or
+// as bearer token. The UserRepository must implement UserIDRepository, the user of a key is loaded on every
+// request, so keys of deleted or disabled users are rejected.
+func WithAPIKeys(keys *APIKeys) Option {
+	return func(a *Authorization) {
+		a.apiKeys = keys
+	}
+}
+
+// CreateAPIKey creates a key carrying the claims of the user and its scopes as "scope" claim. Keys hold no
+// roles or permissions of their own, RBAC resolves them from a RoleRepository by the user of the key and grants
+// only the permissions matched by one of the scopes, so revoking a permission of the user affects its keys.
+func (a *Authorization) CreateAPIKey(ctx context.Context, user User, name string, scopes []string, ttl time.Duration) (string, *APIKey, error) {
+	if a.apiKeys == nil {
+		return "", nil, ErrAPIKeysNotConfigured
+	}
+	claims := tokenutil.Claims{}
+	for k, v := range user.GetClaims() {
+		claims[k] = v
+	}
+	return a.apiKeys.Create(ctx, user.GetID(), name, scopes, withScope(claims, strings.Join(scopes, " ")), ttl)
+}
+
+// APIKeys returns the API key subsystem to list and revoke keys, it is nil unless WithAPIKeys is used.
+func (a *Authorization) APIKeys() *APIKeys {
+	return a.apiKeys
+}
+
+// validateAPIKey validates the key and returns a session with client id "apikey:<id>" and the key claims.
+func (a *Authorization) validateAPIKey(ctx context.Context, plain string) (*tokenutil.Session, tokenutil.Claims, error) {
+	key, err := a.apiKeys.Validate(ctx, plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	users, ok := a.rep.(UserIDRepository)
+	if !ok {
+		return nil, nil, fmt.Errorf("api keys require a UserIDRepository: %T", a.rep)
+	}
+	// like at login, any error of the repository rejects the user, e.g. it is disabled
+	if _, err = users.GetByUserID(tokenutil.WithTenant(ctx, key.TenantID), key.UserID); err != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	session := &tokenutil.Session{
+		UserId:   key.UserID,
+		ClientId: "apikey:" + key.ID,
+		TenantId: key.TenantID,
+		IssuedAt: key.CreatedAt,
+		LastSeen: key.LastUsedAt,
+	}
+	claims := tokenutil.Claims{}
+	for k, v := range key.Claims {
+		claims[k] = v
+	}
+	return session, claims, nil
+}
+
+type SimpleAPIKeyRepository struct {
+	mu   sync.RWMutex
+	keys map[string]*APIKey
+}
+
+var _ APIKeyRepository = (*SimpleAPIKeyRepository)(nil)
+
+func NewSimpleAPIKeyRepository() *SimpleAPIKeyRepository {
+	return &SimpleAPIKeyRepository{keys: make(map[string]*APIKey)}
+}
+
+func (rep *SimpleAPIKeyRepository) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	v := *key
+	rep.keys[key.ID] = &v
+	return nil
+}
+
+func (rep *SimpleAPIKeyRepository) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	key, ok := rep.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	v := *key
+	return &v, nil
+}
+
+func (rep *SimpleAPIKeyRepository) ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error) {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	var keys []*APIKey
+	for _, key := range rep.keys {
+		if key.UserID == userID {
+			v := *key
+			keys = append(keys, &v)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (rep *SimpleAPIKeyRepository) DeleteAPIKey(ctx context.Context, id string) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	delete(rep.keys, id)
+	return nil
+}
+
+func (rep *SimpleAPIKeyRepository) TouchAPIKey(ctx context.Context, id string, lastUsedAt time.Time) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if key, ok := rep.keys[id]; ok {
+		key.LastUsedAt = lastUsedAt
+	}
+	return nil
+}
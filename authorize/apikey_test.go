@@ -0,0 +1,167 @@
+package authorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestAuthorization_APIKey(t *testing.T) {
+	ctx := context.Background()
+	rep := NewSimpleUserRepository()
+	user := &SimpleUser{
+		ID:       "1",
+		Username: "test",
+		Secret:   "123456",
+		Password: "123456",
+		Claims:   map[string]string{"foo": "bar"},
+		Roles:    []string{"admin"},
+	}
+	rep.Add(user)
+	keys := NewAPIKeys(NewSimpleAPIKeyRepository(), APIKeyConfig{})
+	auth := New(rep, tokenutil.NewManager(), WithAPIKeys(keys))
+
+	plain, key, err := auth.CreateAPIKey(ctx, user, "ci", []string{"read"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(plain, "ck_"+key.ID+"_") || strings.Contains(key.Hash, plain) {
+		t.Errorf("unexpected key: %s %+v", plain, key)
+	}
+	pair, err := auth.AuthorizeTokenPair(ctx, &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims []tokenutil.Claims
+	handler := auth.HTTPMiddleware()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		claims = append(claims, tokenutil.FromContext(request.Context()))
+	}))
+	for _, header := range []string{"X-API-Key", "Authorization"} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header == "Authorization" {
+			request.Header.Set(header, "Bearer "+plain)
+		} else {
+			request.Header.Set(header, plain)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != http.StatusOK {
+			t.Errorf("%s rejected: %d %s", header, response.Code, response.Body.String())
+		}
+	}
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if len(claims) != 3 {
+		t.Fatalf("unexpected requests: %d", len(claims))
+	}
+	if claims[0].Get("foo") != claims[2].Get("foo") || claims[1].Get("foo") != claims[2].Get("foo") {
+		t.Errorf("claim foo differs: %v %v", claims[0], claims[2])
+	}
+	if claims[0].Get(ClaimRoles) != "" || claims[2].Get(ClaimRoles) != "admin" {
+		t.Errorf("unexpected roles: %v %v", claims[0], claims[2])
+	}
+	if claims[0].Get(ClaimScope) != "read" {
+		t.Errorf("missing scope: %v", claims[0])
+	}
+
+	list, err := keys.List(ctx, "1")
+	if err != nil || len(list) != 1 || list[0].LastUsedAt.IsZero() {
+		t.Errorf("unexpected keys: %v %v", list, err)
+	}
+	if err = keys.Revoke(ctx, "2", key.ID); err != ErrAPIKeyNotFound {
+		t.Errorf("revoked key of another user: %v", err)
+	}
+	if err = keys.Revoke(ctx, "1", key.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err = keys.Validate(ctx, plain); err != ErrInvalidAPIKey {
+		t.Errorf("revoked key is valid: %v", err)
+	}
+
+	plain, _, _ = auth.CreateAPIKey(ctx, user, "expired", nil, time.Minute)
+	keys.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if _, err = keys.Validate(ctx, plain); err != ErrInvalidAPIKey {
+		t.Errorf("expired key is valid: %v", err)
+	}
+	if _, err = keys.Validate(ctx, plain[:len(plain)-1]+"x"); err != ErrInvalidAPIKey {
+		t.Errorf("tampered key is valid: %v", err)
+	}
+}
+
+func TestAuthorization_APIKeyOwner(t *testing.T) {
+	ctx := context.Background()
+	rep := NewSimpleUserRepository()
+	user := &SimpleUser{ID: "1", Username: "test"}
+	rep.Add(user)
+	auth := New(rep, tokenutil.NewManager(), WithAPIKeys(NewAPIKeys(NewSimpleAPIKeyRepository(), APIKeyConfig{})))
+	validate := func(user User) error {
+		plain, _, err := auth.CreateAPIKey(ctx, user, "ci", nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-API-Key", plain)
+		_, err = auth.ValidateHTTPRequest(request)
+		return err
+	}
+	if err := validate(user); err != nil {
+		t.Errorf("key of the user rejected: %v", err)
+	}
+	if err := validate(&SimpleUser{ID: "2", Username: "deleted"}); err != ErrInvalidAPIKey {
+		t.Errorf("key of an unknown user accepted: %v", err)
+	}
+
+	auth = New(globalUserRepository{rep}, tokenutil.NewManager(), WithAPIKeys(NewAPIKeys(NewSimpleAPIKeyRepository(), APIKeyConfig{})))
+	if err := validate(user); err == nil || NewProblem(err).Status != http.StatusInternalServerError {
+		t.Errorf("key validated without a UserIDRepository: %v", err)
+	}
+}
+
+func TestAuthorization_APIKeyScopes(t *testing.T) {
+	ctx := context.Background()
+	rep := NewSimpleUserRepository()
+	user := &SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456", Roles: []string{"admin"}}
+	rep.Add(user)
+	roles := &staticRoleRepository{roles: []string{"admin"}}
+	rbac := NewRBAC().Grant("admin", "orders:*").WithRepository(roles)
+	auth := New(rep, tokenutil.NewManager(), WithAPIKeys(NewAPIKeys(NewSimpleAPIKeyRepository(), APIKeyConfig{})), WithPolicy(rbac))
+
+	read, _, err := auth.CreateAPIKey(ctx, user, "read", []string{"orders:read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write, _, err := auth.CreateAPIKey(ctx, user, "write", []string{"orders:write"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func(plain, permission string) int {
+		handler := auth.RequirePermission(permission)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-API-Key", plain)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+	if code := status(read, "orders:read"); code != http.StatusOK {
+		t.Errorf("scoped permission rejected: %d", code)
+	}
+	if code := status(read, "orders:write"); code != http.StatusForbidden {
+		t.Errorf("permission beyond the scopes granted: %d", code)
+	}
+	if code := status(write, "orders:write"); code != http.StatusOK {
+		t.Errorf("scoped permission rejected: %d", code)
+	}
+
+	// demoting the user demotes its keys
+	roles.roles = nil
+	if code := status(write, "orders:write"); code != http.StatusForbidden {
+		t.Errorf("permission of a demoted user granted: %d", code)
+	}
+}
@@ -2,11 +2,13 @@ package authorize
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 
+	"github.com/go-chocolate/contrib/authorize/eventutil"
 	"github.com/go-chocolate/contrib/authorize/tokenutil"
 )
 
@@ -16,10 +18,18 @@ type Request interface {
 	GetClientID() string
 }
 
+// RemoteRequest is an optional Request extension reporting the client address, it is used by the Lockout.
+type RemoteRequest interface {
+	GetClientIP() string
+}
+
 type formRequest struct {
-	username string
-	password string
-	clientID string
+	username   string
+	password   string
+	clientID   string
+	clientIP   string
+	attributes map[string]string
+	claims     map[string]string
 }
 
 func (r *formRequest) GetUsername() string {
@@ -31,44 +41,38 @@ func (r *formRequest) GetPassword() string {
 func (r *formRequest) GetClientID() string {
 	return r.clientID
 }
+func (r *formRequest) GetClientIP() string {
+	return r.clientIP
+}
+func (r *formRequest) GetAttributes() map[string]string {
+	return r.attributes
+}
+func (r *formRequest) GetClaims() map[string]string {
+	return r.claims
+}
 
-type RequestBuilder func(request *http.Request) (Request, error)
-
-type PasswordVerifier func(pwd, secret, input string) bool
+func requestClaims(request Request) map[string]string {
+	if r, ok := request.(AttributeRequest); ok {
+		return r.GetClaims()
+	}
+	return nil
+}
 
-var (
-	defaultRequestBuilder RequestBuilder = func(request *http.Request) (Request, error) {
-		clientID := request.Header.Get("X-Client-ID")
-		switch request.Header.Get("Content-Type") {
-		case "application/json":
-			m := map[string]any{}
-			if err := json.NewDecoder(request.Body).Decode(&m); err != nil {
-				return nil, err
-			}
-			return &formRequest{
-				username: m["username"].(string),
-				password: m["password"].(string),
-				clientID: clientID,
-			}, nil
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
 
-		case "application/x-www-form-urlencoded", "form-data":
-			return &formRequest{
-				username: request.FormValue("username"),
-				password: request.FormValue("password"),
-				clientID: clientID,
-			}, nil
-		default:
-			return nil, ErrUnsupportedContentType
-		}
+type RequestBuilder func(request *http.Request) (Request, error)
 
-	}
+// PasswordVerifier verifies input against the stored password pwd, it replaces the PasswordHasher based verification
+// and disables rehashing when set.
+type PasswordVerifier func(pwd, secret, input string) bool
 
-	defaultPasswordVerifier PasswordVerifier = func(pwd, secret, input string) bool {
-		b := md5.Sum([]byte(pwd + secret))
-		expected := hex.EncodeToString(b[:])
-		return expected == input
-	}
-)
+var defaultRequestBuilder RequestBuilder = NewRequestParser().Parse
 
 type Option func(*Authorization)
 
@@ -90,38 +94,173 @@ func WithPasswordVerifier(verifier PasswordVerifier) Option {
 	}
 }
 
+// WithPasswordHasher set the hasher for new passwords, default is argon2id.
+// Stored hashes of other algorithms or with weaker parameters are rehashed on successful login
+// if the UserRepository implements PasswordUpdater.
+func WithPasswordHasher(hasher PasswordHasher) Option {
+	return func(a *Authorization) {
+		a.passwordHasher = hasher
+	}
+}
+
+// WithLockout enable brute-force protection, failed logins are counted per username and client ip.
+func WithLockout(lockout *Lockout) Option {
+	return func(a *Authorization) {
+		a.lockout = lockout
+	}
+}
+
+// WithPolicy set the Policy used by RequirePermission and RequireRole, default is an RBAC without grants.
+func WithPolicy(policy Policy) Option {
+	return func(a *Authorization) {
+		a.policy = policy
+	}
+}
+
 type Authorization struct {
 	rep              UserRepository
 	token            *tokenutil.Manager
 	userRepository   UserRepository
 	requestBuilder   RequestBuilder
 	passwordVerifier PasswordVerifier
+	passwordHasher   PasswordHasher
+	lockout          *Lockout
+	policy           Policy
+	mfa              *MFA
+	events           *eventutil.Bus
+	apiKeys          *APIKeys
+	errorRenderer    ErrorRenderer
+	cookies          *CookieConfig
+	credentials      Credentials
+	tenants          *TenantConfig
 }
 
 func New(rep UserRepository, token *tokenutil.Manager, options ...Option) *Authorization {
 	a := &Authorization{
-		rep:              rep,
-		token:            token,
-		userRepository:   rep,
-		requestBuilder:   defaultRequestBuilder,
-		passwordVerifier: defaultPasswordVerifier,
+		rep:            rep,
+		token:          token,
+		userRepository: rep,
+		requestBuilder: defaultRequestBuilder,
+		passwordHasher: NewArgon2idHasher(),
+		policy:         NewRBAC(),
+		errorRenderer:  DefaultErrorRenderer,
 	}
 	applyOptions(a, options)
 	return a
 }
 
 func (a *Authorization) Authorize(ctx context.Context, request Request) (string, error) {
-	user, err := a.rep.GetByUsername(ctx, request.GetUsername())
+	pair, err := a.AuthorizeTokenPair(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	return pair.AccessToken, nil
+}
+
+// AuthorizeTokenPair verifies the request and returns an access token with its refresh token.
+// With tenants enabled the user is looked up in the tenant of ctx or the login field, see WithTenants.
+func (a *Authorization) AuthorizeTokenPair(ctx context.Context, request Request) (*tokenutil.TokenPair, error) {
+	ctx, err := a.withLoginTenant(ctx, request)
 	if err != nil {
-		return "", ErrInvalidUsername
+		return nil, err
 	}
-	if !a.passwordVerifier(user.GetPassword(), user.GetSecret(), request.GetPassword()) {
-		return "", ErrInvalidPassword
+	user, err := a.authenticate(ctx, request)
+	if err != nil {
+		return nil, err
 	}
-	return a.token.GenToken(ctx, user.GetID(), request.GetClientID(), user.GetClaims())
+	return a.issue(ctx, user, request.GetClientID(), requestClaims(request))
+}
+
+// authenticate verifies the credentials of the request and returns the user if no second step is required.
+func (a *Authorization) authenticate(ctx context.Context, request Request) (User, error) {
+	var ip string
+	if r, ok := request.(RemoteRequest); ok {
+		ip = r.GetClientIP()
+	}
+	if a.lockout != nil {
+		if err := a.lockout.Check(ctx, request.GetUsername(), ip); err != nil {
+			a.events.Publish(ctx, loginEvent(eventutil.LoginFailed, request, ip, "locked"))
+			return nil, err
+		}
+	}
+	user, err := a.verifyCredentials(ctx, request, ip)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.requireMFA(ctx, user, request, ip); err != nil {
+		return nil, err
+	}
+	if a.lockout != nil {
+		_ = a.lockout.Succeed(ctx, request.GetUsername())
+	}
+	event := loginEvent(eventutil.LoginSucceeded, request, ip, "")
+	event.UserID = user.GetID()
+	a.events.Publish(ctx, event)
+	return user, nil
+}
+
+func (a *Authorization) verifyCredentials(ctx context.Context, request Request, ip string) (User, error) {
+	if a.credentials != nil {
+		user, err := a.credentials.Verify(ctx, request)
+		switch {
+		case errors.Is(err, ErrInvalidUsername):
+			a.fail(ctx, loginEvent(eventutil.LoginFailed, request, ip, "invalid_username"))
+		case errors.Is(err, ErrInvalidPassword):
+			a.fail(ctx, loginEvent(eventutil.LoginFailed, request, ip, "invalid_password"))
+		}
+		return user, err
+	}
+	user, err := getUser(ctx, a.rep, request.GetUsername())
+	if errors.Is(err, ErrTenantsNotSupported) {
+		return nil, err
+	}
+	if err != nil || user == nil {
+		a.fail(ctx, loginEvent(eventutil.LoginFailed, request, ip, "invalid_username"))
+		return nil, ErrInvalidUsername
+	}
+	if !a.verifyPassword(ctx, user, request.GetPassword()) {
+		event := loginEvent(eventutil.LoginFailed, request, ip, "invalid_password")
+		event.UserID = user.GetID()
+		a.fail(ctx, event)
+		return nil, ErrInvalidPassword
+	}
+	return user, nil
+}
+
+// issue returns tokens with the claims of the user, extra claims of the request are added if the user has no
+// claim of the same name.
+func (a *Authorization) issue(ctx context.Context, user User, clientID string, extra map[string]string) (*tokenutil.TokenPair, error) {
+	claims, err := a.accessClaims(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range extra {
+		if _, ok := claims[k]; !ok && k != ClaimRoles && k != ClaimPermissions && k != ClaimScope {
+			claims[k] = v
+		}
+	}
+	return a.token.GenTokenPair(ctx, user.GetID(), clientID, claims)
+}
+
+func (a *Authorization) verifyPassword(ctx context.Context, user User, password string) bool {
+	if a.passwordVerifier != nil {
+		return a.passwordVerifier(user.GetPassword(), user.GetSecret(), password)
+	}
+	encoded := user.GetPassword()
+	if ok, err := VerifyPassword(password, user.GetSecret(), encoded); err != nil || !ok {
+		return false
+	}
+	if updater, ok := a.rep.(PasswordUpdater); ok && a.passwordHasher.NeedsRehash(encoded) {
+		// rehash is best effort, the user is already authenticated
+		if rehashed, err := a.passwordHasher.Hash(password); err == nil {
+			_ = updater.UpdatePassword(ctx, user, rehashed)
+		}
+	}
+	return true
 }
 
 func (a *Authorization) AuthorizeFromHTTPRequest(request *http.Request) (string, error) {
+	request = a.withRequestTenant(request)
 	req, err := a.requestBuilder(request)
 	if err != nil {
 		return "", err
@@ -131,24 +270,167 @@ func (a *Authorization) AuthorizeFromHTTPRequest(request *http.Request) (string,
 
 func (a *Authorization) HTTPHandler() http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		token, err := a.AuthorizeFromHTTPRequest(request)
+		request = a.withRequestTenant(a.withEventMetadata(request))
+		req, err := a.requestBuilder(request)
 		if err != nil {
-			var message string
-			switch err {
-			case ErrInvalidUsername, ErrInvalidPassword:
-				message = "invalid username or password"
-			default:
-				message = "system error"
+			if !errors.Is(err, ErrUnsupportedContentType) && !errors.Is(err, ErrInvalidRequest) {
+				err = fmt.Errorf("%w: %v", ErrInvalidRequest, err)
 			}
-			http.Error(writer, message, http.StatusBadRequest)
-		} else {
-			json.NewEncoder(writer).Encode(map[string]interface{}{"token": token})
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		if request, err = a.withBinding(request); err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		pair, err := a.AuthorizeTokenPair(request.Context(), req)
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		a.writeSession(writer, request, pair)
+	}
+}
+
+// RefreshHTTPHandler exchanges the refresh_token field of a json or form request for a new token pair, with
+// cookie sessions the body can be omitted.
+func (a *Authorization) RefreshHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = a.withEventMetadata(request)
+		fields := map[string]string{}
+		var err error
+		// cookie sessions are refreshed without body, the refresh token is read from the cookie
+		if a.cookies == nil || request.ContentLength != 0 {
+			if fields, err = readFields(request); err != nil {
+				a.errorRenderer(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+				return
+			}
+		}
+		refreshToken := fields["refresh_token"]
+		if refreshToken == "" && a.cookies != nil {
+			if refreshToken, err = a.cookieToken(request, a.cookies.RefreshName); err != nil {
+				a.errorRenderer(writer, request, err)
+				return
+			}
+		}
+		if request, err = a.withBinding(request); err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		pair, err := a.token.Refresh(request.Context(), refreshToken)
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		a.writeSession(writer, request, pair)
+	}
+}
+
+// LogoutHTTPHandler revokes the session of the token in the request.
+func (a *Authorization) LogoutHTTPHandler() http.HandlerFunc {
+	return a.logoutHTTPHandler(func(ctx context.Context, session *tokenutil.Session) error {
+		return a.token.Revoke(ctx, session.UserId, session.ClientId)
+	})
+}
+
+// LogoutAllHTTPHandler revokes all sessions of the user the token in the request belongs to.
+func (a *Authorization) LogoutAllHTTPHandler() http.HandlerFunc {
+	return a.logoutHTTPHandler(func(ctx context.Context, session *tokenutil.Session) error {
+		return a.token.RevokeAll(ctx, session.UserId)
+	})
+}
+
+func (a *Authorization) logoutHTTPHandler(revoke func(ctx context.Context, session *tokenutil.Session) error) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = a.withEventMetadata(request)
+		session, _, err := a.validateHTTPRequest(request)
+		if err == nil {
+			err = revoke(session.WithContext(request.Context()), session)
+		}
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
 		}
+		a.clearSession(writer, request)
+		writer.WriteHeader(http.StatusNoContent)
 	}
 }
 
+// readFields reads the string fields of a json or form request body.
+func readFields(request *http.Request) (map[string]string, error) {
+	body, err := readBody(request, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for k, v := range body {
+		if s, ok := v.(string); ok {
+			fields[k] = s
+		}
+	}
+	return fields, nil
+}
+
+func writeTokenPair(writer http.ResponseWriter, pair *tokenutil.TokenPair) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	body := map[string]interface{}{
+		"token":        pair.AccessToken,
+		"access_token": pair.AccessToken,
+		"expires_in":   pair.ExpiresIn,
+		"token_type":   "Bearer",
+	}
+	// impersonation tokens can not be refreshed
+	if pair.RefreshToken != "" {
+		body["refresh_token"] = pair.RefreshToken
+	}
+	json.NewEncoder(writer).Encode(body)
+}
+
 func (a *Authorization) ValidateHTTPRequest(request *http.Request) (tokenutil.Claims, error) {
-	return a.token.ValidateHTTPRequest(request)
+	_, claims, err := a.validateHTTPRequest(request)
+	return claims, err
+}
+
+// validateHTTPRequest authenticates the request by its API key, bearer token or session cookie.
+func (a *Authorization) validateHTTPRequest(request *http.Request) (*tokenutil.Session, tokenutil.Claims, error) {
+	session, claims, err := a.validateHTTPCredentials(request)
+	if err == nil {
+		err = a.checkTenant(request, session)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, claims, nil
+}
+
+func (a *Authorization) validateHTTPCredentials(request *http.Request) (*tokenutil.Session, tokenutil.Claims, error) {
+	if a.apiKeys != nil {
+		if key := request.Header.Get("X-API-Key"); key != "" {
+			return a.validateAPIKey(request.Context(), key)
+		}
+		if token := tokenutil.TokenFromHTTPRequest(request); a.apiKeys.owns(token) {
+			return a.validateAPIKey(request.Context(), token)
+		}
+	}
+	token := tokenutil.TokenFromHTTPRequest(request)
+	if token == "" && a.cookies != nil {
+		var err error
+		if token, err = a.cookieToken(request, a.cookies.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+	return a.token.ValidateHTTPSession(request, token)
+}
+
+// withBinding attaches the token binding of the request to its context, tokens issued or refreshed with it are
+// bound to the request, see tokenutil.WithTokenBinding.
+func (a *Authorization) withBinding(request *http.Request) (*http.Request, error) {
+	binding, err := a.token.Bind(request)
+	if err != nil || binding == nil {
+		return request, err
+	}
+	return request.WithContext(tokenutil.WithBinding(request.Context(), binding)), nil
 }
 
 func (a *Authorization) HTTPMiddleware() func(next http.Handler) http.Handler {
@@ -165,10 +447,12 @@ func (a *Authorization) HTTPMiddlewareFunc() func(next http.HandlerFunc) http.Ha
 
 func (a *Authorization) httpMiddleware(next http.Handler) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		if claims, err := a.ValidateHTTPRequest(request); err != nil {
-			http.Error(writer, err.Error(), http.StatusUnauthorized)
+		request = a.withEventMetadata(request)
+		if session, claims, err := a.validateHTTPRequest(request); err != nil {
+			a.errorRenderer(writer, request, err)
 		} else {
-			next.ServeHTTP(writer, request.WithContext(claims.WithContext(request.Context())))
+			ctx := session.WithContext(claims.WithContext(request.Context()))
+			next.ServeHTTP(writer, request.WithContext(ctx))
 		}
 	}
 }
@@ -1,6 +1,8 @@
 package authorize
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -22,7 +24,7 @@ func TestAuthorization(t *testing.T) {
 	m := tokenutil.NewManager()
 	auth := New(rep, m)
 
-	var token string
+	var token, refreshToken string
 	{
 		form := url.Values{}
 		form.Set("username", "test")
@@ -35,10 +37,18 @@ func TestAuthorization(t *testing.T) {
 		if response.Code != http.StatusOK {
 			t.Fail()
 		}
-		body := response.Body.String()
 		t.Log(response.Code)
-		t.Log(body)
-		token = body[10 : len(body)-3]
+		t.Log(response.Body.String())
+		var body map[string]any
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		token, _ = body["access_token"].(string)
+		refreshToken, _ = body["refresh_token"].(string)
+	}
+
+	if user, _ := rep.GetByUsername(context.Background(), "test"); !strings.HasPrefix(user.GetPassword(), "$argon2id$") {
+		t.Errorf("legacy password is not rehashed: %s", user.GetPassword())
 	}
 
 	{
@@ -50,4 +60,77 @@ func TestAuthorization(t *testing.T) {
 			t.Log(claims.Encode())
 		}
 	}
+
+	{
+		form := url.Values{}
+		form.Set("refresh_token", refreshToken)
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/refresh", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		auth.RefreshHTTPHandler().ServeHTTP(response, request)
+		if response.Code != http.StatusOK {
+			t.Errorf("refresh failed: %d %s", response.Code, response.Body.String())
+		}
+		var body map[string]any
+		if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		request = httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		if _, err := auth.ValidateHTTPRequest(request); err == nil {
+			t.Errorf("token is valid after refresh")
+		}
+		token, _ = body["access_token"].(string)
+	}
+
+	{
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/logout", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		auth.LogoutHTTPHandler().ServeHTTP(response, request)
+		if response.Code != http.StatusNoContent {
+			t.Errorf("logout failed: %d %s", response.Code, response.Body.String())
+		}
+		if _, err := auth.ValidateHTTPRequest(request); err == nil {
+			t.Errorf("token is valid after logout")
+		}
+	}
+}
+
+func TestAuthorization_TokenBinding(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+	m := tokenutil.NewManager(tokenutil.WithTokenBinding(tokenutil.BindingConfig{IP: true, Policy: tokenutil.BindingReauthenticate}))
+	auth := New(rep, m)
+
+	form := url.Values{"username": {"test"}, "password": {"ea48576f30be1669971699c09ad05c94"}, "client_id": {"console"}}
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.RemoteAddr = "10.0.0.1:1234"
+	auth.HTTPHandler().ServeHTTP(response, request)
+	var pair tokenutil.TokenPair
+	if err := json.NewDecoder(response.Body).Decode(&pair); err != nil || pair.AccessToken == "" {
+		t.Fatalf("login failed: %d %v", response.Code, err)
+	}
+
+	handler := auth.HTTPMiddleware()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	serve := func(ip string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		request.RemoteAddr = ip + ":1234"
+		handler.ServeHTTP(response, request)
+		return response
+	}
+	if response = serve("10.0.0.1"); response.Code != http.StatusOK {
+		t.Errorf("bound request rejected: %d %s", response.Code, response.Body.String())
+	}
+	if response = serve("10.0.0.2"); response.Code != http.StatusUnauthorized ||
+		!strings.Contains(response.Body.String(), CodeReauthenticate) {
+		t.Errorf("unexpected response: %d %s", response.Code, response.Body.String())
+	}
+	if response = serve("10.0.0.1"); response.Code != http.StatusUnauthorized {
+		t.Errorf("session not revoked: %d", response.Code)
+	}
 }
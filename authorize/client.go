@@ -0,0 +1,69 @@
+package authorize
+
+import (
+	"context"
+)
+
+const (
+	GrantPassword          = "password"
+	GrantClientCredentials = "client_credentials"
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+)
+
+// Client is an OAuth2 client, clients without a secret are public and must use PKCE.
+type Client interface {
+	GetClientID() string
+	// GetSecret returns the PHC encoded hash of the client secret, see PasswordHasher.
+	GetSecret() string
+	GetRedirectURIs() []string
+	GetGrantTypes() []string
+	GetScopes() []string
+}
+
+type ClientRepository interface {
+	GetByClientID(ctx context.Context, clientID string) (Client, error)
+}
+
+type SimpleClient struct {
+	ID           string
+	Secret       string
+	RedirectURIs []string
+	GrantTypes   []string
+	Scopes       []string
+}
+
+func (c *SimpleClient) GetClientID() string       { return c.ID }
+func (c *SimpleClient) GetSecret() string         { return c.Secret }
+func (c *SimpleClient) GetRedirectURIs() []string { return c.RedirectURIs }
+func (c *SimpleClient) GetGrantTypes() []string   { return c.GrantTypes }
+func (c *SimpleClient) GetScopes() []string       { return c.Scopes }
+
+type SimpleClientRepository struct {
+	clients map[string]*SimpleClient
+}
+
+func NewSimpleClientRepository() *SimpleClientRepository {
+	return &SimpleClientRepository{clients: make(map[string]*SimpleClient)}
+}
+
+func (rep *SimpleClientRepository) GetByClientID(ctx context.Context, clientID string) (Client, error) {
+	c, ok := rep.clients[clientID]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	return c, nil
+}
+
+func (rep *SimpleClientRepository) Add(c *SimpleClient) {
+	rep.clients[c.ID] = c
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package authorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+const (
+	// CSRFDoubleSubmit requires unsafe requests to echo the value of the CSRF cookie in the CSRF header.
+	CSRFDoubleSubmit = "double-submit"
+	// CSRFSynchronizer requires unsafe requests to send the CSRF token stored for the access token in the CSRF header.
+	CSRFSynchronizer = "synchronizer"
+)
+
+type CookieConfig struct {
+	Name           string        // access token cookie, default "access_token"
+	RefreshName    string        // refresh token cookie, default "refresh_token"
+	Domain         string        // cookie domain, default is the host of the request
+	Path           string        // cookie path, default "/"
+	SameSite       http.SameSite // default http.SameSiteLaxMode
+	Insecure       bool          // omit the Secure attribute, for local development over plain http only
+	CSRF           string        // CSRFDoubleSubmit or CSRFSynchronizer, default CSRFDoubleSubmit
+	CSRFCookieName string        // cookie of the double submit token, default "csrf_token"
+	CSRFHeaderName string        // default "X-CSRF-Token", urlencoded forms may send the csrf_token field instead
+	Storage        kv.Storage    // stores the synchronizer tokens, required by CSRFSynchronizer
+	Prefix         string        // storage key prefix, default "csrf:"
+}
+
+func (c *CookieConfig) init() {
+	if c.Name == "" {
+		c.Name = "access_token"
+	}
+	if c.RefreshName == "" {
+		c.RefreshName = "refresh_token"
+	}
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+	if c.CSRF == "" {
+		c.CSRF = CSRFDoubleSubmit
+	}
+	if c.CSRFCookieName == "" {
+		c.CSRFCookieName = "csrf_token"
+	}
+	if c.CSRFHeaderName == "" {
+		c.CSRFHeaderName = "X-CSRF-Token"
+	}
+	if c.Prefix == "" {
+		c.Prefix = "csrf:"
+	}
+}
+
+// WithCookieSession enable the cookie session mode for browser clients. The login, refresh and mfa handlers set
+// the tokens as HttpOnly cookies instead of returning them, the middleware reads the access token cookie if there
+// is no Authorization header and checks the CSRF token of unsafe requests. It panics if the CSRF mode is unknown
+// or CSRFSynchronizer is used without Storage.
+func WithCookieSession(config CookieConfig) Option {
+	config.init()
+	switch {
+	case config.CSRF != CSRFDoubleSubmit && config.CSRF != CSRFSynchronizer:
+		panic(fmt.Errorf("authorize: unknown csrf mode: %s", config.CSRF))
+	case config.CSRF == CSRFSynchronizer && config.Storage == nil:
+		panic(errors.New("authorize: csrf synchronizer requires a storage"))
+	}
+	return func(a *Authorization) {
+		a.cookies = &config
+	}
+}
+
+func (c *CookieConfig) cookie(name, value string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Secure:   !c.Insecure,
+		HttpOnly: httpOnly,
+		SameSite: c.SameSite,
+		MaxAge:   int(maxAge / time.Second),
+	}
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
+
+// writeSession sets the session cookies and responds with the CSRF token, or writes the token pair if the cookie
+// session mode is disabled.
+func (a *Authorization) writeSession(writer http.ResponseWriter, request *http.Request, pair *tokenutil.TokenPair) {
+	c := a.cookies
+	if c == nil {
+		writeTokenPair(writer, pair)
+		return
+	}
+	ctx := request.Context()
+	maxAge := time.Duration(pair.ExpiresIn) * time.Second
+	csrf := randomToken()
+	if c.CSRF == CSRFSynchronizer {
+		if err := c.Storage.Set(ctx, c.csrfKey(pair.AccessToken), []byte(csrf), maxAge); err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+	}
+	http.SetCookie(writer, c.cookie(c.Name, pair.AccessToken, maxAge, true))
+	if pair.RefreshToken != "" {
+		http.SetCookie(writer, c.cookie(c.RefreshName, pair.RefreshToken, a.token.RefreshMaxAge(), true))
+	}
+	if c.CSRF == CSRFDoubleSubmit {
+		http.SetCookie(writer, c.cookie(c.CSRFCookieName, csrf, a.token.RefreshMaxAge(), false))
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"csrf_token": csrf,
+		"expires_in": pair.ExpiresIn,
+	})
+}
+
+// clearSession expires the session cookies.
+func (a *Authorization) clearSession(writer http.ResponseWriter, request *http.Request) {
+	c := a.cookies
+	if c == nil {
+		return
+	}
+	if c.CSRF == CSRFSynchronizer {
+		if cookie, err := request.Cookie(c.Name); err == nil {
+			_ = c.Storage.Del(request.Context(), c.csrfKey(cookie.Value))
+		}
+	}
+	for _, name := range []string{c.Name, c.RefreshName, c.CSRFCookieName} {
+		http.SetCookie(writer, c.cookie(name, "", -1, true))
+	}
+}
+
+// cookieToken returns the value of the cookie, the CSRF token of unsafe requests is checked first.
+func (a *Authorization) cookieToken(request *http.Request, name string) (string, error) {
+	cookie, err := request.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return "", nil
+	}
+	if safeMethod(request.Method) {
+		return cookie.Value, nil
+	}
+	if err = a.cookies.checkCSRF(request.Context(), request); err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+func (c *CookieConfig) checkCSRF(ctx context.Context, request *http.Request) error {
+	token := request.Header.Get(c.CSRFHeaderName)
+	if token == "" && strings.HasPrefix(request.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		token = request.PostFormValue("csrf_token")
+	}
+	if token == "" {
+		return ErrInvalidCSRFToken
+	}
+	var expected string
+	switch c.CSRF {
+	case CSRFSynchronizer:
+		cookie, err := request.Cookie(c.Name)
+		if err != nil {
+			return ErrInvalidCSRFToken
+		}
+		b, err := c.Storage.Get(ctx, c.csrfKey(cookie.Value))
+		if err != nil {
+			return ErrInvalidCSRFToken
+		}
+		expected = string(b)
+	default:
+		cookie, err := request.Cookie(c.CSRFCookieName)
+		if err != nil {
+			return ErrInvalidCSRFToken
+		}
+		expected = cookie.Value
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+func (c *CookieConfig) csrfKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return c.Prefix + hex.EncodeToString(sum[:])
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package authorize
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestAuthorization_CookieSession(t *testing.T) {
+	for _, mode := range []string{CSRFDoubleSubmit, CSRFSynchronizer} {
+		t.Run(mode, func(t *testing.T) {
+			rep := NewSimpleUserRepository()
+			rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+			auth := New(rep, tokenutil.NewManager(), WithCookieSession(CookieConfig{CSRF: mode, Storage: tokenutil.NewMemoryStorage()}))
+
+			// session returns the cookies and the CSRF token set by the response
+			session := func(response *httptest.ResponseRecorder) (map[string]*http.Cookie, string) {
+				cookies := map[string]*http.Cookie{}
+				for _, cookie := range response.Result().Cookies() {
+					cookies[cookie.Name] = cookie
+				}
+				var body map[string]any
+				json.NewDecoder(response.Body).Decode(&body)
+				csrf, _ := body["csrf_token"].(string)
+				return cookies, csrf
+			}
+			// send makes a request without body like browsers do for refresh and logout
+			send := func(handler http.Handler, method string, cookies map[string]*http.Cookie, csrf string) *httptest.ResponseRecorder {
+				request := httptest.NewRequest(method, "/", nil)
+				for _, cookie := range cookies {
+					request.AddCookie(cookie)
+				}
+				if csrf != "" {
+					request.Header.Set("X-CSRF-Token", csrf)
+				}
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, request)
+				return response
+			}
+
+			request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"test","password":"ea48576f30be1669971699c09ad05c94","client_id":"web"}`))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			auth.HTTPHandler().ServeHTTP(response, request)
+			cookies, csrf := session(response)
+			if response.Code != http.StatusOK || csrf == "" || cookies["access_token"] == nil || cookies["refresh_token"] == nil {
+				t.Fatalf("login failed: %d %v", response.Code, cookies)
+			}
+			if !cookies["access_token"].HttpOnly || !cookies["access_token"].Secure {
+				t.Errorf("insecure access token cookie: %+v", cookies["access_token"])
+			}
+			if csrfCookie := cookies["csrf_token"]; (mode == CSRFDoubleSubmit) != (csrfCookie != nil) {
+				t.Errorf("unexpected csrf cookie: %+v", csrfCookie)
+			}
+
+			api := auth.HTTPMiddleware()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+			if response = send(api, http.MethodGet, cookies, ""); response.Code != http.StatusOK {
+				t.Errorf("safe request rejected: %d", response.Code)
+			}
+			if response = send(api, http.MethodPost, cookies, ""); response.Code != http.StatusForbidden {
+				t.Errorf("request without csrf token accepted: %d", response.Code)
+			}
+			if response = send(api, http.MethodPost, cookies, "wrong"); response.Code != http.StatusForbidden {
+				t.Errorf("request with mismatched csrf token accepted: %d", response.Code)
+			}
+			if response = send(api, http.MethodPost, cookies, csrf); response.Code != http.StatusOK {
+				t.Errorf("request with csrf token rejected: %d %s", response.Code, response.Body.String())
+			}
+
+			refresh := auth.RefreshHTTPHandler()
+			if response = send(refresh, http.MethodPost, cookies, ""); response.Code != http.StatusForbidden {
+				t.Errorf("refresh without csrf token accepted: %d", response.Code)
+			}
+			response = send(refresh, http.MethodPost, cookies, csrf)
+			refreshed, refreshedCSRF := session(response)
+			if response.Code != http.StatusOK || refreshedCSRF == "" || refreshed["access_token"] == nil ||
+				refreshed["access_token"].Value == cookies["access_token"].Value {
+				t.Fatalf("refresh failed: %d %v", response.Code, refreshed)
+			}
+			for name, cookie := range refreshed {
+				cookies[name] = cookie
+			}
+			if response = send(api, http.MethodPost, cookies, refreshedCSRF); response.Code != http.StatusOK {
+				t.Errorf("request of the refreshed session rejected: %d", response.Code)
+			}
+
+			response = send(auth.LogoutHTTPHandler(), http.MethodPost, cookies, refreshedCSRF)
+			cleared, _ := session(response)
+			if response.Code != http.StatusNoContent {
+				t.Fatalf("logout failed: %d %s", response.Code, response.Body.String())
+			}
+			for _, name := range []string{"access_token", "refresh_token", "csrf_token"} {
+				if cookie := cleared[name]; cookie == nil || cookie.Value != "" || cookie.MaxAge >= 0 {
+					t.Errorf("cookie %s not cleared: %+v", name, cookie)
+				}
+			}
+			if response = send(api, http.MethodGet, cookies, ""); response.Code != http.StatusUnauthorized {
+				t.Errorf("session valid after logout: %d", response.Code)
+			}
+		})
+	}
+}
+
+func TestWithCookieSession_Invalid(t *testing.T) {
+	for _, config := range []CookieConfig{{CSRF: CSRFSynchronizer}, {CSRF: "none"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("invalid config accepted: %+v", config)
+				}
+			}()
+			WithCookieSession(config)
+		}()
+	}
+}
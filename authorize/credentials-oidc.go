@@ -0,0 +1,177 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+type OIDCConfig struct {
+	Issuer        string            // issuer of the upstream provider, the iss claim must match
+	ClientID      string            // required, the aud claim must contain the client id
+	JWKSURL       string            // discovered from the openid-configuration of the issuer if empty
+	HTTPClient    *http.Client      // default http.DefaultClient
+	IDClaim       string            // default "sub"
+	UsernameClaim string            // default "preferred_username", falls back to the id
+	RolesClaim    string            // claim with a role list or a space separated string, e.g. "groups"
+	ClaimMapping  map[string]string // upstream claim to local claim
+	Leeway        time.Duration
+	Resolve       UserResolver // maps the upstream user to a local user, default is the ExternalUser
+}
+
+func (c *OIDCConfig) init() {
+	c.Issuer = strings.TrimSuffix(c.Issuer, "/")
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+	if c.IDClaim == "" {
+		c.IDClaim = "sub"
+	}
+	if c.UsernameClaim == "" {
+		c.UsernameClaim = "preferred_username"
+	}
+}
+
+// OIDCCredentials exchanges an id token of an upstream OpenID Connect provider for local tokens, the id token is
+// sent as password of the login request. The verified user carries the iss and sub claims as Issuer and Subject,
+// LinkLocalUser links local accounts by them. Its id is the IDClaim in the namespace of the issuer, see ExternalID.
+type OIDCCredentials struct {
+	config OIDCConfig
+
+	mu       sync.Mutex
+	verifier *tokenutil.JWTVerifier
+}
+
+var _ Credentials = (*OIDCCredentials)(nil)
+
+// NewOIDCCredentials panics if the config has no ClientID, id tokens issued to other clients would be accepted.
+func NewOIDCCredentials(config OIDCConfig) *OIDCCredentials {
+	config.init()
+	if config.ClientID == "" {
+		panic(errors.New("oidc: client id is required"))
+	}
+	return &OIDCCredentials{config: config}
+}
+
+func (o *OIDCCredentials) Verify(ctx context.Context, request Request) (User, error) {
+	verifier, err := o.getVerifier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := verifier.Verify(ctx, request.GetPassword())
+	if err != nil {
+		if errors.Is(err, tokenutil.ErrTokenInvalid) || errors.Is(err, tokenutil.ErrTokenExpired) || errors.Is(err, tokenutil.ErrKeyNotFound) {
+			return nil, ErrInvalidPassword
+		}
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	user := &ExternalUser{Issuer: o.config.Issuer, Subject: subject, Attributes: map[string][]string{}}
+	for k, v := range claims {
+		user.Attributes[k] = claimValues(v)
+	}
+	id := first(user.Attributes[o.config.IDClaim])
+	if id == "" || user.Subject == "" {
+		return nil, ErrInvalidPassword
+	}
+	user.ID = ExternalID(o.config.Issuer, id)
+	user.Username = first(user.Attributes[o.config.UsernameClaim])
+	if user.Username == "" {
+		user.Username = id
+	}
+	// a username sent with the token has to match, it protects against tokens of other accounts being replayed
+	if request.GetUsername() != "" && request.GetUsername() != user.Username {
+		return nil, ErrInvalidUsername
+	}
+	if o.config.RolesClaim != "" {
+		user.Roles = user.Attributes[o.config.RolesClaim]
+		if _, ok := claims[o.config.RolesClaim].(string); ok {
+			user.Roles = strings.Fields(first(user.Roles))
+		}
+	}
+	user.Claims = user.MapClaims(o.config.ClaimMapping)
+	return o.config.Resolve.Resolve(ctx, user)
+}
+
+func (o *OIDCCredentials) getVerifier(ctx context.Context) (*tokenutil.JWTVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.verifier != nil {
+		return o.verifier, nil
+	}
+	jwksURL := o.config.JWKSURL
+	if jwksURL == "" {
+		var err error
+		if jwksURL, err = o.discover(ctx); err != nil {
+			return nil, err
+		}
+	}
+	o.verifier = &tokenutil.JWTVerifier{
+		Keys:     tokenutil.NewRemoteKeySet(jwksURL, o.config.HTTPClient),
+		Issuer:   o.config.Issuer,
+		Audience: []string{o.config.ClientID},
+		Leeway:   o.config.Leeway,
+	}
+	return o.verifier, nil
+}
+
+// discover returns the jwks_uri of the provider metadata.
+func (o *OIDCCredentials) discover(ctx context.Context) (string, error) {
+	url := o.config.Issuer + "/.well-known/openid-configuration"
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := o.config.HTTPClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc discovery %s: %s", url, response.Status)
+	}
+	var metadata struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		return "", err
+	}
+	if metadata.JWKSURI == "" {
+		return "", fmt.Errorf("oidc discovery %s: missing jwks_uri", url)
+	}
+	return metadata.JWKSURI, nil
+}
+
+// claimValues flattens a claim to strings.
+func claimValues(v any) []string {
+	switch v := v.(type) {
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, claimValues(item)...)
+		}
+		return values
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case nil:
+		return nil
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
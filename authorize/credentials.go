@@ -0,0 +1,151 @@
+package authorize
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Credentials verifies the credentials of a login request in place of the UserRepository and the password hash,
+// e.g. against a directory, see the ldaputil module, or an upstream identity provider. Wrong credentials are
+// reported as ErrInvalidUsername or ErrInvalidPassword, they count as failed logins for the Lockout, other errors
+// do not.
+type Credentials interface {
+	Verify(ctx context.Context, request Request) (User, error)
+}
+
+type CredentialsFunc func(ctx context.Context, request Request) (User, error)
+
+func (f CredentialsFunc) Verify(ctx context.Context, request Request) (User, error) {
+	return f(ctx, request)
+}
+
+// WithCredentials delegate the verification of login requests, the UserRepository is still used by the other
+// features, e.g. MFA and RoleRepository.
+func WithCredentials(credentials Credentials) Option {
+	return func(a *Authorization) {
+		a.credentials = credentials
+	}
+}
+
+// ExternalUser is a user authenticated by an external credential source, it has no local password.
+// The claims and roles are mapped from the attributes of the source and embedded into issued tokens.
+type ExternalUser struct {
+	ID         string // the user id of issued tokens unless a local user is linked, see ExternalID
+	Username   string
+	Issuer     string // iss and sub claims of users of an OpenID Connect provider, empty for other sources
+	Subject    string
+	Claims     map[string]string
+	Roles      []string
+	Attributes map[string][]string // the raw attributes of the source
+}
+
+func (u *ExternalUser) GetID() string                { return u.ID }
+func (u *ExternalUser) GetSecret() string            { return "" }
+func (u *ExternalUser) GetUsername() string          { return u.Username }
+func (u *ExternalUser) GetPassword() string          { return "" }
+func (u *ExternalUser) GetClaims() map[string]string { return u.Claims }
+func (u *ExternalUser) GetRoles() []string           { return u.Roles }
+
+// ExternalID returns the id of an external user in the namespace of its source, e.g. the issuer of an OpenID Connect
+// provider or the url of a directory. Ids of different sources do not collide, neither do they with local user ids
+// unless these start with "external:", so an external user never shares the roles or sessions of a local user.
+func ExternalID(namespace, id string) string {
+	return "external:" + url.QueryEscape(namespace) + ":" + url.QueryEscape(id)
+}
+
+// UserResolver maps an external user to the user tokens are issued for, e.g. to link or provision local accounts.
+type UserResolver func(ctx context.Context, external *ExternalUser) (User, error)
+
+// ExternalIdentityRepository is an optional UserRepository extension loading the local user of the tenant of ctx
+// linked to the subject of an OpenID Connect issuer, ErrUserNotFound is returned if there is none.
+type ExternalIdentityRepository interface {
+	GetByExternalID(ctx context.Context, issuer, subject string) (User, error)
+}
+
+// LinkLocalUser returns a UserResolver issuing tokens for the linked local user in the tenant of the login, its
+// claims, roles and permissions take precedence over the mapped ones. Users of an OpenID Connect provider are
+// linked by issuer and subject, which requires an ExternalIdentityRepository, as usernames of the provider may
+// change and need not be unique. Other users are linked by username. Unknown users are rejected with
+// ErrUserNotFound unless allowUnknown is set, then the external user is used as is, with its namespaced id.
+func LinkLocalUser(rep UserRepository, allowUnknown bool) UserResolver {
+	return func(ctx context.Context, external *ExternalUser) (User, error) {
+		local, err := linkedLocalUser(ctx, rep, external)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		if local == nil {
+			if allowUnknown {
+				return external, nil
+			}
+			return nil, ErrUserNotFound
+		}
+		return &linkedUser{User: local, external: external}, nil
+	}
+}
+
+func linkedLocalUser(ctx context.Context, rep UserRepository, external *ExternalUser) (User, error) {
+	if external.Issuer == "" {
+		return getUser(ctx, rep, external.Username)
+	}
+	if rep, ok := rep.(ExternalIdentityRepository); ok {
+		return rep.GetByExternalID(ctx, external.Issuer, external.Subject)
+	}
+	return nil, ErrUserNotFound
+}
+
+type linkedUser struct {
+	User
+	external *ExternalUser
+}
+
+func (u *linkedUser) GetClaims() map[string]string {
+	claims := map[string]string{}
+	for k, v := range u.external.Claims {
+		claims[k] = v
+	}
+	for k, v := range u.User.GetClaims() {
+		claims[k] = v
+	}
+	return claims
+}
+
+func (u *linkedUser) GetRoles() []string {
+	roles := append([]string{}, u.external.Roles...)
+	if v, ok := u.User.(RoleUser); ok {
+		roles = append(roles, v.GetRoles()...)
+	}
+	return roles
+}
+
+func (u *linkedUser) GetPermissions() []string {
+	if v, ok := u.User.(PermissionUser); ok {
+		return v.GetPermissions()
+	}
+	return nil
+}
+
+// Resolve returns the user tokens are issued for, the external user itself if the resolver is nil. Users the
+// resolver does not find are reported as ErrInvalidUsername, so Credentials can return the result as is.
+func (resolve UserResolver) Resolve(ctx context.Context, external *ExternalUser) (User, error) {
+	if resolve == nil {
+		return external, nil
+	}
+	user, err := resolve(ctx, external)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, ErrInvalidUsername
+	}
+	return user, err
+}
+
+// MapClaims returns the attributes listed in mapping as claims, multiple values are comma joined.
+func (u *ExternalUser) MapClaims(mapping map[string]string) map[string]string {
+	claims := map[string]string{}
+	for attribute, claim := range mapping {
+		if values := u.Attributes[attribute]; len(values) > 0 {
+			claims[claim] = strings.Join(values, ",")
+		}
+	}
+	return claims
+}
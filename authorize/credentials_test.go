@@ -0,0 +1,114 @@
+package authorize
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestOIDCCredentials(t *testing.T) {
+	ctx := context.Background()
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := tokenutil.NewSigningKey("upstream", tokenutil.ES256, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, _ := tokenutil.NewJWK(key)
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(writer http.ResponseWriter, request *http.Request) {
+		json.NewEncoder(writer).Encode(tokenutil.JWKS{Keys: []tokenutil.JWK{jwk}})
+	})
+
+	upstream := tokenutil.NewManager(tokenutil.WithJWT(key), tokenutil.WithIssuer(server.URL), tokenutil.WithAudience("app"))
+	idToken, err := upstream.GenToken(ctx, "u-1", "upstream", tokenutil.Claims{"preferred_username": "alice", "groups": "admins dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := tokenutil.NewManager()
+	// a local user with the id of the upstream subject
+	local := struct {
+		*SimpleUserRepository
+		userRoleRepository
+	}{NewSimpleUserRepository(), userRoleRepository{"u-1": {"admin"}}}
+	auth := New(local, m, WithCredentials(NewOIDCCredentials(OIDCConfig{
+		Issuer:       server.URL,
+		ClientID:     "app",
+		RolesClaim:   "groups",
+		ClaimMapping: map[string]string{"sub": "upstream_sub"},
+	})))
+	token, err := auth.Authorize(ctx, &formRequest{password: idToken, clientID: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, claims, err := m.ValidateSession(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Get(ClaimRoles) != "admins,dev" || claims.Get("upstream_sub") != "u-1" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if session.UserId != ExternalID(server.URL, "u-1") {
+		t.Errorf("upstream user id not namespaced: %s", session.UserId)
+	}
+
+	if _, err = auth.Authorize(ctx, &formRequest{username: "bob", password: idToken, clientID: "web"}); err != ErrInvalidUsername {
+		t.Errorf("token of another user accepted: %v", err)
+	}
+	other := tokenutil.NewManager(tokenutil.WithJWT(key), tokenutil.WithIssuer(server.URL), tokenutil.WithAudience("other"))
+	idToken, _ = other.GenToken(ctx, "u-1", "upstream", nil)
+	if _, err = auth.Authorize(ctx, &formRequest{password: idToken, clientID: "web"}); err != ErrInvalidPassword {
+		t.Errorf("token of another audience accepted: %v", err)
+	}
+	if _, err = auth.Authorize(ctx, &formRequest{password: strings.Repeat("x", 20), clientID: "web"}); err != ErrInvalidPassword {
+		t.Errorf("malformed token accepted: %v", err)
+	}
+
+	// local accounts are linked by issuer and subject, not by the username of the provider
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "alice", Roles: []string{"admin"}})
+	rep.Add(&SimpleUser{ID: "2", Username: "al", ExternalIDs: map[string]string{server.URL: "u-1"}})
+	auth = New(rep, m, WithCredentials(NewOIDCCredentials(OIDCConfig{
+		Issuer:   server.URL,
+		ClientID: "app",
+		Resolve:  LinkLocalUser(rep, false),
+	})))
+	link := func(subject string) (*tokenutil.Session, error) {
+		idToken, _ := upstream.GenToken(ctx, subject, "upstream", tokenutil.Claims{"preferred_username": "alice"})
+		token, err := auth.Authorize(ctx, &formRequest{password: idToken, clientID: "web"})
+		if err != nil {
+			return nil, err
+		}
+		session, _, err := m.ValidateSession(ctx, token)
+		return session, err
+	}
+	if session, err := link("u-1"); err != nil || session.UserId != "2" {
+		t.Errorf("unexpected link: %+v %v", session, err)
+	}
+	if _, err = link("u-2"); err != ErrInvalidUsername {
+		t.Errorf("linked by username: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("credentials without client id")
+		}
+	}()
+	NewOIDCCredentials(OIDCConfig{Issuer: server.URL})
+}
@@ -1,10 +1,54 @@
 package authorize
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 var (
 	ErrInvalidUsername        = errors.New("invalid username")
 	ErrInvalidPassword        = errors.New("invalid password")
 	ErrUserNotFound           = errors.New("user not found")
 	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrInvalidRequest         = errors.New("invalid request")
+	ErrUnsupportedHash        = errors.New("unsupported password hash")
+	ErrMalformedHash          = errors.New("malformed password hash")
+	ErrAccountLocked          = errors.New("account locked")
+	ErrMFARequired            = errors.New("mfa required")
+	ErrMFANotConfigured       = errors.New("mfa not configured")
+	ErrInvalidChallenge       = errors.New("invalid mfa challenge")
+	ErrInvalidMFACode         = errors.New("invalid mfa code")
+	ErrClientNotFound         = errors.New("client not found")
+	ErrAPIKeyNotFound         = errors.New("api key not found")
+	ErrInvalidAPIKey          = errors.New("invalid api key")
+	ErrAPIKeysNotConfigured   = errors.New("api keys not configured")
+	ErrInvalidCSRFToken       = errors.New("invalid csrf token")
+	ErrWeakPassword           = errors.New("weak password")
 )
+
+// AccountLockedError is returned when too many logins failed, it matches ErrAccountLocked with errors.Is.
+type AccountLockedError struct {
+	RetryAfter time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return ErrAccountLocked.Error() + ", retry after " + e.RetryAfter.Format(time.RFC3339)
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// MFARequiredError is returned when the password is correct but the user has to pass the second step,
+// it matches ErrMFARequired with errors.Is. The challenge is exchanged by Authorization.VerifyMFA.
+type MFARequiredError struct {
+	Challenge string
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
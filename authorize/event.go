@@ -0,0 +1,48 @@
+package authorize
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+)
+
+// WithEventBus publish login succeeded, login failed and account locked events to the bus. Pass the same bus to
+// tokenutil.WithEventBus to receive token events as well.
+func WithEventBus(bus *eventutil.Bus) Option {
+	return func(a *Authorization) {
+		a.events = bus
+	}
+}
+
+// withEventMetadata attaches the request metadata to the request context for the published events.
+func (a *Authorization) withEventMetadata(request *http.Request) *http.Request {
+	if a.events == nil {
+		return request
+	}
+	ctx := eventutil.MetadataFromHTTPRequest(request).WithContext(request.Context())
+	return request.WithContext(ctx)
+}
+
+// fail publishes the failed login and records it with the lockout, an account locked event follows
+// if the failure locked the username or the client ip.
+func (a *Authorization) fail(ctx context.Context, event *eventutil.Event) {
+	a.events.Publish(ctx, event)
+	if a.lockout == nil {
+		return
+	}
+	_ = a.lockout.Fail(ctx, event.Username, event.IP)
+	if a.events == nil {
+		return
+	}
+	if err := a.lockout.Check(ctx, event.Username, event.IP); err != nil {
+		locked := *event
+		locked.Type = eventutil.AccountLocked
+		locked.Reason = ""
+		a.events.Publish(ctx, &locked)
+	}
+}
+
+func loginEvent(typ eventutil.Type, request Request, ip, reason string) *eventutil.Event {
+	return &eventutil.Event{Type: typ, Username: request.GetUsername(), ClientID: request.GetClientID(), IP: ip, Reason: reason}
+}
@@ -0,0 +1,63 @@
+package authorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestAuthorization_Events(t *testing.T) {
+	bus := eventutil.NewBus()
+	var events []*eventutil.Event
+	bus.Subscribe(eventutil.SubscriberFunc(func(ctx context.Context, event *eventutil.Event) {
+		events = append(events, event)
+	}))
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+	auth := New(rep, tokenutil.NewManager(tokenutil.WithEventBus(bus)),
+		WithEventBus(bus),
+		WithLockout(NewLockout(tokenutil.NewMemoryStorage(), LockoutConfig{UsernameThreshold: 2})),
+	)
+
+	login := func(password string) {
+		form := url.Values{"username": {"test"}, "password": {password}}
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("User-Agent", "test-agent")
+		request.Header.Set("X-Client-ID", "web")
+		auth.HTTPHandler().ServeHTTP(httptest.NewRecorder(), request)
+	}
+	login("ea48576f30be1669971699c09ad05c94")
+	login("wrong")
+	login("wrong")
+	login("ea48576f30be1669971699c09ad05c94")
+
+	expected := []eventutil.Type{
+		eventutil.LoginSucceeded,
+		eventutil.TokenIssued,
+		eventutil.LoginFailed,
+		eventutil.LoginFailed,
+		eventutil.AccountLocked,
+		eventutil.LoginFailed,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected events: %d", len(events))
+	}
+	for i, typ := range expected {
+		if events[i].Type != typ {
+			t.Errorf("event %d: expected %s, got %s", i, typ, events[i].Type)
+		}
+		if events[i].IP != "192.0.2.1" || events[i].UserAgent != "test-agent" || events[i].ClientID != "web" {
+			t.Errorf("event %d: missing request metadata: %+v", i, events[i])
+		}
+	}
+	if events[0].UserID != "1" || events[2].Reason != "invalid_password" || events[5].Reason != "locked" {
+		t.Errorf("unexpected events: %+v %+v %+v", events[0], events[2], events[5])
+	}
+}
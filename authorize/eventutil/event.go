@@ -0,0 +1,145 @@
+package eventutil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	LoginSucceeded Type = "login.succeeded"
+	LoginFailed    Type = "login.failed"
+	TokenIssued    Type = "token.issued"
+	TokenValidated Type = "token.validated"
+	TokenRevoked   Type = "token.revoked"
+	AccountLocked  Type = "account.locked"
+)
+
+// Event is an authentication event, the request metadata is taken from the context passed to Bus.Publish.
+type Event struct {
+	Type      Type              `json:"type"`
+	Time      time.Time         `json:"time"`
+	UserID    string            `json:"userId,omitempty"`
+	Username  string            `json:"username,omitempty"`
+	ClientID  string            `json:"clientId,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"userAgent,omitempty"`
+	Reason    string            `json:"reason,omitempty"` // why a login failed or a token was revoked
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// Metadata describes the request which caused an event.
+type Metadata struct {
+	IP        string
+	UserAgent string
+	ClientID  string
+}
+
+// MetadataFromHTTPRequest returns the remote address, the user agent and the X-Client-ID header of the request.
+func MetadataFromHTTPRequest(request *http.Request) Metadata {
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+	return Metadata{IP: ip, UserAgent: request.UserAgent(), ClientID: request.Header.Get("X-Client-ID")}
+}
+
+type metadataContextKey struct{}
+
+var _metadataContextKey = &metadataContextKey{}
+
+func (m Metadata) WithContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, _metadataContextKey, m)
+}
+
+func MetadataFromContext(ctx context.Context) Metadata {
+	m, _ := ctx.Value(_metadataContextKey).(Metadata)
+	return m
+}
+
+type Subscriber interface {
+	Handle(ctx context.Context, event *Event)
+}
+
+type SubscriberFunc func(ctx context.Context, event *Event)
+
+func (f SubscriberFunc) Handle(ctx context.Context, event *Event) {
+	f(ctx, event)
+}
+
+type subscription struct {
+	id         int
+	subscriber Subscriber
+	types      map[Type]bool
+}
+
+// Bus delivers published events synchronously to the subscribers in the order they subscribed,
+// a nil *Bus discards all events.
+type Bus struct {
+	mu            sync.RWMutex
+	subscriptions []*subscription
+	seq           int
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers the subscriber for the types, or all events if there are none.
+// The returned function removes the subscription.
+func (b *Bus) Subscribe(subscriber Subscriber, types ...Type) func() {
+	s := &subscription{subscriber: subscriber}
+	if len(types) > 0 {
+		s.types = make(map[Type]bool)
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+	b.mu.Lock()
+	b.seq++
+	s.id = b.seq
+	b.subscriptions = append(b.subscriptions, s)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, v := range b.subscriptions {
+			if v.id == s.id {
+				b.subscriptions = append(b.subscriptions[:i:i], b.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish completes the time and the missing request metadata of the event from ctx and delivers it.
+func (b *Bus) Publish(ctx context.Context, event *Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	m := MetadataFromContext(ctx)
+	if event.IP == "" {
+		event.IP = m.IP
+	}
+	if event.UserAgent == "" {
+		event.UserAgent = m.UserAgent
+	}
+	if event.ClientID == "" {
+		event.ClientID = m.ClientID
+	}
+	b.mu.RLock()
+	subscriptions := b.subscriptions
+	b.mu.RUnlock()
+	for _, s := range subscriptions {
+		if s.types == nil || s.types[event.Type] {
+			s.subscriber.Handle(ctx, event)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package eventutil
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBus_Subscribe(t *testing.T) {
+	bus := NewBus()
+	var count int
+	unsubscribe := bus.Subscribe(SubscriberFunc(func(ctx context.Context, event *Event) {
+		count++
+	}), TokenRevoked)
+	bus.Publish(context.Background(), &Event{Type: TokenIssued})
+	bus.Publish(context.Background(), &Event{Type: TokenRevoked})
+	unsubscribe()
+	bus.Publish(context.Background(), &Event{Type: TokenRevoked})
+	if count != 1 {
+		t.Errorf("unexpected deliveries: %d", count)
+	}
+	var nilBus *Bus
+	nilBus.Publish(context.Background(), &Event{Type: TokenRevoked})
+}
@@ -0,0 +1,115 @@
+package grpcutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+// TokenSource supplies the token of outgoing calls.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource always returning the same token, e.g. an API key.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// RefreshFunc exchanges a refresh token for a new token pair, e.g. Manager.Refresh or a call to the refresh endpoint.
+type RefreshFunc func(ctx context.Context, refreshToken string) (*tokenutil.TokenPair, error)
+
+// RefreshingTokenSource holds a token pair and refreshes it shortly before the access token expires,
+// or after the server rejected it.
+type RefreshingTokenSource struct {
+	mu      sync.Mutex
+	pair    *tokenutil.TokenPair
+	expiry  time.Time
+	refresh RefreshFunc
+	margin  time.Duration
+}
+
+func NewRefreshingTokenSource(pair *tokenutil.TokenPair, refresh RefreshFunc) *RefreshingTokenSource {
+	s := &RefreshingTokenSource{refresh: refresh, margin: 30 * time.Second}
+	s.set(pair)
+	return s
+}
+
+func (s *RefreshingTokenSource) set(pair *tokenutil.TokenPair) {
+	s.pair = pair
+	s.expiry = time.Now().Add(time.Duration(pair.ExpiresIn) * time.Second)
+}
+
+func (s *RefreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Until(s.expiry) > s.margin {
+		return s.pair.AccessToken, nil
+	}
+	pair, err := s.refresh(ctx, s.pair.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	s.set(pair)
+	return pair.AccessToken, nil
+}
+
+// Invalidate forces a refresh on the next call of Token.
+func (s *RefreshingTokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiry = time.Time{}
+}
+
+type invalidator interface {
+	Invalidate()
+}
+
+// UnaryClientInterceptor attaches the token of the source as authorization metadata. Calls rejected with
+// codes.Unauthenticated are retried once with a refreshed token if the source supports invalidation.
+func UnaryClientInterceptor(source TokenSource) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		outgoing, err := withToken(ctx, source)
+		if err != nil {
+			return err
+		}
+		err = invoker(outgoing, method, req, reply, cc, opts...)
+		v, ok := source.(invalidator)
+		if !ok || status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+		v.Invalidate()
+		if outgoing, err = withToken(ctx, source); err != nil {
+			return err
+		}
+		return invoker(outgoing, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor attaches the token of the source as authorization metadata.
+func StreamClientInterceptor(source TokenSource) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		outgoing, err := withToken(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(outgoing, desc, cc, method, opts...)
+	}
+}
+
+func withToken(ctx context.Context, source TokenSource) (context.Context, error) {
+	token, err := source.Token(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), nil
+}
@@ -0,0 +1,27 @@
+module github.com/go-chocolate/contrib/authorize/grpcutil
+
+go 1.20
+
+require (
+	github.com/go-chocolate/contrib/authorize v0.0.0-20261018123140-342e68f36e1c
+	google.golang.org/grpc v1.60.1
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-chocolate/configuration/common v0.0.0-20231226080250-a7086d866e72 // indirect
+	github.com/go-chocolate/contrib/kv v0.0.0-20261018123140-342e68f36e1c // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/redis/go-redis/v9 v9.3.1 // indirect
+	golang.org/x/net v0.16.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231002182017-d307bd883b97 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+// in-tree development builds against the sibling modules, consumers resolve the versions required above
+replace github.com/go-chocolate/contrib/authorize => ../
+
+replace github.com/go-chocolate/contrib/kv => ../../kv
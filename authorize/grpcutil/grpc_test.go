@@ -0,0 +1,160 @@
+package grpcutil
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+type healthServer struct {
+	*health.Server
+	claims chan tokenutil.Claims
+}
+
+func (s *healthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	s.claims <- tokenutil.FromContext(ctx)
+	return s.Server.Check(ctx, req)
+}
+
+func (s *healthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	s.claims <- tokenutil.FromContext(stream.Context())
+	return s.Server.Watch(req, stream)
+}
+
+func dial(t *testing.T, m *tokenutil.Manager, source TokenSource, options ...ServerOption) (grpc_health_v1.HealthClient, chan tokenutil.Claims) {
+	listener := bufconn.Listen(1 << 16)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(m, options...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(m, options...)),
+	)
+	hs := &healthServer{Server: health.NewServer(), claims: make(chan tokenutil.Claims, 10)}
+	grpc_health_v1.RegisterHealthServer(server, hs)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(source)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(source)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return grpc_health_v1.NewHealthClient(conn), hs.claims
+}
+
+func TestInterceptors(t *testing.T) {
+	ctx := context.Background()
+	m := tokenutil.NewManager()
+	pair, err := m.GenTokenPair(ctx, "1", "grpc", tokenutil.Claims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewRefreshingTokenSource(pair, m.Refresh)
+	client, claims := dial(t, m, source)
+
+	if _, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-claims; c.Get("foo") != "bar" {
+		t.Errorf("unexpected claims: %v", c)
+	}
+
+	watchCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatal(err)
+	}
+	if c := <-claims; c.Get("foo") != "bar" {
+		t.Errorf("unexpected stream claims: %v", c)
+	}
+}
+
+func TestInterceptors_Refresh(t *testing.T) {
+	ctx := context.Background()
+	m := tokenutil.NewManager()
+	pair, err := m.GenTokenPair(ctx, "1", "grpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Revoke(ctx, "1", "grpc"); err != nil {
+		t.Fatal(err)
+	}
+	refreshed := false
+	source := NewRefreshingTokenSource(pair, func(ctx context.Context, refreshToken string) (*tokenutil.TokenPair, error) {
+		refreshed = true
+		return m.GenTokenPair(ctx, "1", "grpc", nil)
+	})
+	client, _ := dial(t, m, source)
+	if _, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil || !refreshed {
+		t.Errorf("token is not refreshed: %v", err)
+	}
+}
+
+func TestInterceptors_AllowList(t *testing.T) {
+	ctx := context.Background()
+	m := tokenutil.NewManager()
+	client, claims := dial(t, m, StaticToken("invalid"), WithAllowList("/grpc.health.v1.Health/Check"))
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Errorf("public method rejected: %v", err)
+	}
+	<-claims
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestInterceptors_Binding(t *testing.T) {
+	ctx := context.Background()
+	m := tokenutil.NewManager(tokenutil.WithTokenBinding(tokenutil.BindingConfig{UserAgent: true}))
+	binding, err := m.BindClient("", "curl/8.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := m.GenTokenPair(tokenutil.WithBinding(ctx, binding), "1", "console", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, _ := dial(t, m, StaticToken(pair.AccessToken))
+	if _, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("token bound to another user agent accepted: %v", err)
+	}
+}
+
+func TestInterceptors_Unauthenticated(t *testing.T) {
+	ctx := context.Background()
+	key, _ := tokenutil.GenerateSigningKey(tokenutil.HS256)
+	other, _ := tokenutil.GenerateSigningKey(tokenutil.HS256)
+	m := tokenutil.NewManager(tokenutil.WithJWT(key))
+	token, err := tokenutil.NewManager(tokenutil.WithJWT(other)).GenToken(ctx, "1", "grpc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"malformed": "a!.b.c", "unknown key": token} {
+		client, _ := dial(t, m, StaticToken(token))
+		if _, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+	}
+}
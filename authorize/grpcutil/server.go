@@ -0,0 +1,159 @@
+package grpcutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+type ServerOption func(c *serverConfig)
+
+type serverConfig struct {
+	allow []string
+}
+
+func applyServerOptions(c *serverConfig, options []ServerOption) {
+	for _, option := range options {
+		option(c)
+	}
+}
+
+// WithAllowList set the methods served without a token, e.g. "/auth.v1.AuthService/Login",
+// "/grpc.health.v1.Health/*" allows all methods of the service.
+func WithAllowList(methods ...string) ServerOption {
+	return func(c *serverConfig) {
+		c.allow = append(c.allow, methods...)
+	}
+}
+
+func (c *serverConfig) allowed(fullMethod string) bool {
+	for _, pattern := range c.allow {
+		if pattern == fullMethod {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(fullMethod, pattern[:len(pattern)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor validates the token of the authorization metadata like Manager.ValidateToken and injects
+// the claims and the session into the context, invalid tokens are rejected with codes.Unauthenticated. The binding
+// of the session is enforced against the peer address and the user-agent metadata, sessions bound to a DPoP key
+// are rejected.
+func UnaryServerInterceptor(m *tokenutil.Manager, options ...ServerOption) grpc.UnaryServerInterceptor {
+	c := &serverConfig{}
+	applyServerOptions(c, options)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if c.allowed(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := authenticate(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor.
+func StreamServerInterceptor(m *tokenutil.Manager, options ...ServerOption) grpc.StreamServerInterceptor {
+	c := &serverConfig{}
+	applyServerOptions(c, options)
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if c.allowed(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		ctx, err := authenticate(stream.Context(), m)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &serverStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// TokenFromContext returns the token of the incoming authorization metadata, the Bearer scheme is optional.
+func TokenFromContext(ctx context.Context) string {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	token := values[0]
+	if len(token) > 7 && strings.ToLower(token[:7]) == "bearer " {
+		token = token[7:]
+	}
+	return token
+}
+
+func authenticate(ctx context.Context, m *tokenutil.Manager) (context.Context, error) {
+	token := TokenFromContext(ctx)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing token")
+	}
+	// an invalid peer address leaves the binding empty, bound sessions do not match it
+	binding, _ := m.BindClient(peerIP(ctx), userAgent(ctx))
+	session, claims, err := m.ValidateBoundSession(ctx, token, binding)
+	if err == nil {
+		return session.WithContext(claims.WithContext(ctx)), nil
+	}
+	if unauthenticated(err) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return nil, status.Error(codes.Internal, "system error")
+}
+
+// unauthenticated reports whether err is a validation error of the token, other errors are failures of the server.
+func unauthenticated(err error) bool {
+	for _, target := range []error{
+		tokenutil.ErrTokenInvalid,
+		tokenutil.ErrTokenExpired,
+		tokenutil.ErrTokenReused,
+		tokenutil.ErrKeyNotFound,
+		tokenutil.ErrTenantMismatch,
+		tokenutil.ErrBindingMismatch,
+		tokenutil.ErrReauthenticate,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+func userAgent(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, "user-agent"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
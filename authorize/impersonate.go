@@ -0,0 +1,114 @@
+package authorize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+// PermissionImpersonate is the default permission required to impersonate users.
+const PermissionImpersonate = "users:impersonate"
+
+// AllowImpersonation returns an ImpersonationConfig.Allow function granting impersonation to an authenticated
+// actor holding the permission, the context must carry the session and claims of the actor, e.g. the context of
+// a request passed through HTTPMiddleware. An empty permission defaults to PermissionImpersonate.
+// The target is loaded from the repository, which must implement UserIDRepository, and the actor must hold every
+// role and permission of the target, so impersonation can not escalate privileges. Roles the actor lacks are
+// accepted if the actor holds all permissions the RBAC policy grants to them.
+func AllowImpersonation(rep UserRepository, policy Policy, permission string) func(ctx context.Context, actorUID, targetUID string) (bool, error) {
+	if permission == "" {
+		permission = PermissionImpersonate
+	}
+	return func(ctx context.Context, actorUID, targetUID string) (bool, error) {
+		if session := tokenutil.SessionFromContext(ctx); session == nil || session.UserId != actorUID {
+			return false, nil
+		}
+		if ok, err := policy.HasPermission(ctx, permission); err != nil || !ok {
+			return false, err
+		}
+		users, ok := rep.(UserIDRepository)
+		if !ok {
+			return false, nil
+		}
+		target, err := users.GetByUserID(ctx, targetUID)
+		if errors.Is(err, ErrUserNotFound) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		claims, err := userClaims(ctx, rep, target)
+		if err != nil {
+			return false, err
+		}
+		session := &tokenutil.Session{UserId: targetUID, TenantId: tokenutil.TenantFromContext(ctx)}
+		return holdsPrivileges(ctx, policy, session.WithContext(claims.WithContext(ctx)))
+	}
+}
+
+// holdsPrivileges reports whether the context ctx holds every role and permission of the target context.
+func holdsPrivileges(ctx context.Context, policy Policy, target context.Context) (bool, error) {
+	claims := tokenutil.FromContext(target)
+	roles, permissions := splitList(claims.Get(ClaimRoles)), splitList(claims.Get(ClaimPermissions))
+	rbac, _ := policy.(*RBAC)
+	if rbac != nil {
+		var err error
+		if roles, err = rbac.roles(target); err != nil {
+			return false, err
+		}
+		if permissions, err = rbac.permissions(target); err != nil {
+			return false, err
+		}
+	}
+	for _, role := range roles {
+		ok, err := policy.HasRole(ctx, role)
+		if err != nil {
+			return false, err
+		} else if ok {
+			continue
+		}
+		if rbac == nil || len(rbac.grants[role]) == 0 {
+			return false, nil
+		}
+		permissions = append(permissions, rbac.grants[role]...)
+	}
+	for _, permission := range permissions {
+		if ok, err := policy.HasPermission(ctx, permission); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ImpersonateHTTPHandler issues an impersonation token of the user in the user_id field for the authenticated
+// user of the request, tokens expire after ttl. The token is returned in the response body only, the session
+// cookies of the actor are not replaced.
+func (a *Authorization) ImpersonateHTTPHandler(ttl time.Duration) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = a.withEventMetadata(request)
+		session, claims, err := a.validateHTTPRequest(request)
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		fields, err := readFields(request)
+		if err != nil || fields["user_id"] == "" {
+			a.errorRenderer(writer, request, fmt.Errorf("%w: user_id is required", ErrInvalidRequest))
+			return
+		}
+		if request, err = a.withBinding(request); err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		ctx := session.WithContext(claims.WithContext(request.Context()))
+		pair, err := a.token.Impersonate(ctx, session.UserId, fields["user_id"], ttl)
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		writeTokenPair(writer, pair)
+	}
+}
@@ -0,0 +1,119 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestAuthorization_Impersonate(t *testing.T) {
+	ctx := context.Background()
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+	rep.Add(&SimpleUser{ID: "2", Username: "support", Secret: "123456", Password: "123456",
+		Roles: []string{"support"}})
+	policy := NewRBAC().Grant("support", PermissionImpersonate)
+	m := tokenutil.NewManager(tokenutil.WithImpersonation(tokenutil.ImpersonationConfig{Allow: AllowImpersonation(rep, policy, "")}))
+	auth := New(rep, m, WithPolicy(policy))
+
+	login := func(username string) string {
+		pair, err := auth.AuthorizeTokenPair(ctx, &formRequest{username: username, password: "ea48576f30be1669971699c09ad05c94", clientID: "web"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pair.AccessToken
+	}
+	impersonate := func(token, userID string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/impersonate", strings.NewReader(url.Values{"user_id": {userID}}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Authorization", "Bearer "+token)
+		auth.ImpersonateHTTPHandler(time.Minute).ServeHTTP(response, request)
+		return response
+	}
+
+	if response := impersonate(login("test"), "2"); response.Code != http.StatusForbidden {
+		t.Errorf("impersonated without permission: %d %s", response.Code, response.Body.String())
+	}
+	response := impersonate(login("support"), "1")
+	var body map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("impersonation failed: %d %v", response.Code, err)
+	}
+	if _, ok := body["refresh_token"]; ok {
+		t.Errorf("impersonation token can be refreshed: %v", body)
+	}
+	token, _ := body["access_token"].(string)
+
+	var claims tokenutil.Claims
+	var session *tokenutil.Session
+	handler := auth.HTTPMiddleware()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		claims = tokenutil.FromContext(request.Context())
+		session = tokenutil.SessionFromContext(request.Context())
+	}))
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	if session == nil || session.UserId != "1" || claims.Actor() != "2" {
+		t.Errorf("unexpected impersonation: %+v %v", session, claims)
+	}
+	if response = impersonate(token, "2"); response.Code != http.StatusForbidden {
+		t.Errorf("impersonated with impersonation token: %d", response.Code)
+	}
+}
+
+type userRoleRepository map[string][]string
+
+func (r userRoleRepository) GetRoles(ctx context.Context, userId string) ([]string, error) {
+	return r[userId], nil
+}
+
+func (r userRoleRepository) GetPermissions(ctx context.Context, userId string) ([]string, error) {
+	return nil, nil
+}
+
+func TestAllowImpersonation_Target(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test"})
+	rep.Add(&SimpleUser{ID: "2", Username: "support", Roles: []string{"support"}})
+	rep.Add(&SimpleUser{ID: "3", Username: "admin", Roles: []string{"admin"}})
+	rep.Add(&SimpleUser{ID: "4", Username: "staff", Roles: []string{"staff"}})
+	rep.Add(&SimpleUser{ID: "5", Username: "writer", Permissions: []string{"orders:write"}})
+	rep.Add(&SimpleUser{ID: "6", Username: "reader", Roles: []string{"reader"}})
+	rep.Add(&SimpleUser{ID: "7", Username: "root"})
+	roles := userRoleRepository{"7": {"admin"}}
+	policy := NewRBAC().
+		Grant("support", PermissionImpersonate, "orders:read").
+		Grant("admin", "*").
+		Grant("reader", "orders:read").
+		WithRepository(roles)
+	allow := AllowImpersonation(rep, policy, "")
+
+	session := &tokenutil.Session{UserId: "2"}
+	ctx := session.WithContext(tokenutil.Claims{ClaimRoles: "support"}.WithContext(context.Background()))
+	cases := map[string]bool{
+		"1":   true,  // no privileges
+		"3":   false, // role with permissions the actor lacks
+		"4":   false, // role without grants the actor lacks
+		"5":   false, // permission the actor lacks
+		"6":   true,  // role whose grants the actor holds
+		"7":   false, // admin resolved by the role repository
+		"404": false, // unknown user
+	}
+	for target, expected := range cases {
+		if ok, err := allow(ctx, "2", target); err != nil || ok != expected {
+			t.Errorf("%s: unexpected result %v %v", target, ok, err)
+		}
+	}
+
+	if ok, _ := AllowImpersonation(struct{ UserRepository }{rep}, policy, "")(ctx, "2", "1"); ok {
+		t.Error("target not verified without UserIDRepository")
+	}
+}
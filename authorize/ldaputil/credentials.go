@@ -0,0 +1,140 @@
+package ldaputil
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/go-chocolate/contrib/authorize"
+)
+
+type Config struct {
+	URL               string      // ldap:// or ldaps:// url of the directory
+	StartTLS          bool        // upgrade ldap:// connections with StartTLS
+	TLSConfig         *tls.Config // for ldaps:// and StartTLS
+	BindDN            string      // service account searching users, anonymous search if empty
+	BindPassword      string
+	BaseDN            string                 // search base of users
+	UserFilter        string                 // filter with the escaped username as %s, default "(uid=%s)"
+	IDAttribute       string                 // attribute of the user id, default is the DN, see authorize.ExternalID
+	UsernameAttribute string                 // default "uid"
+	GroupAttribute    string                 // attribute of the group DNs mapped to roles, e.g. "memberOf"
+	AttributeMapping  map[string]string      // ldap attribute to claim
+	Timeout           time.Duration          // default 10s
+	Resolve           authorize.UserResolver // maps the directory user to a local user, default is the ExternalUser
+}
+
+func (c *Config) init() {
+	if c.UserFilter == "" {
+		c.UserFilter = "(uid=%s)"
+	}
+	if c.UsernameAttribute == "" {
+		c.UsernameAttribute = "uid"
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+}
+
+// Credentials verifies passwords by a bind as the user, the user entry is looked up by a search first.
+type Credentials struct {
+	config Config
+}
+
+var _ authorize.Credentials = (*Credentials)(nil)
+
+func NewCredentials(config Config) *Credentials {
+	config.init()
+	return &Credentials{config: config}
+}
+
+func (l *Credentials) Verify(ctx context.Context, request authorize.Request) (authorize.User, error) {
+	// an empty password is an unauthenticated bind which succeeds for any DN
+	if request.GetUsername() == "" || request.GetPassword() == "" {
+		return nil, authorize.ErrInvalidPassword
+	}
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.config.BindDN != "" {
+		if err = conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	attributes := []string{l.config.UsernameAttribute}
+	if l.config.IDAttribute != "" {
+		attributes = append(attributes, l.config.IDAttribute)
+	}
+	if l.config.GroupAttribute != "" {
+		attributes = append(attributes, l.config.GroupAttribute)
+	}
+	for attribute := range l.config.AttributeMapping {
+		attributes = append(attributes, attribute)
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(l.config.Timeout/time.Second), false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(request.GetUsername())), attributes, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, authorize.ErrInvalidUsername
+	}
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, request.GetPassword()); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, authorize.ErrInvalidPassword
+		}
+		return nil, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	user := &authorize.ExternalUser{ID: entry.DN, Username: entry.GetAttributeValue(l.config.UsernameAttribute), Attributes: map[string][]string{}}
+	for _, attribute := range entry.Attributes {
+		user.Attributes[attribute.Name] = attribute.Values
+	}
+	if l.config.IDAttribute != "" {
+		user.ID = entry.GetAttributeValue(l.config.IDAttribute)
+	}
+	user.ID = authorize.ExternalID(l.config.URL, user.ID)
+	if user.Username == "" {
+		user.Username = request.GetUsername()
+	}
+	if l.config.GroupAttribute != "" {
+		for _, group := range entry.GetAttributeValues(l.config.GroupAttribute) {
+			user.Roles = append(user.Roles, groupName(group))
+		}
+	}
+	user.Claims = user.MapClaims(l.config.AttributeMapping)
+	return l.config.Resolve.Resolve(ctx, user)
+}
+
+func (l *Credentials) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.config.URL, ldap.DialWithTLSConfig(l.config.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(l.config.Timeout)
+	if l.config.StartTLS {
+		if err = conn.StartTLS(l.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// groupName returns the value of the first RDN of a group DN, e.g. "admins" of "cn=admins,ou=groups,dc=example".
+func groupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
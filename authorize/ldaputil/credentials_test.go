@@ -0,0 +1,183 @@
+package ldaputil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+
+	"github.com/go-chocolate/contrib/authorize"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+type ldapEntry struct {
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAP is a minimal LDAP server supporting simple binds and equality filters on uid.
+type fakeLDAP struct {
+	listener net.Listener
+	entries  map[string]*ldapEntry
+}
+
+func newFakeLDAP(t *testing.T, entries map[string]*ldapEntry) *fakeLDAP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeLDAP{listener: listener, entries: entries}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeLDAP) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeLDAP) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if entry, ok := s.entries[dn]; ok && entry.password == op.Children[2].Data.String() {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for dn, entry := range s.entries {
+				if uid := entry.attributes["uid"]; len(uid) == 0 || filter != "(uid="+ldap.EscapeFilter(uid[0])+")" {
+					continue
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, ""))
+				attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+				for name, values := range entry.attributes {
+					attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					for _, value := range values {
+						set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+					}
+					attribute.AppendChild(set)
+					attributes.AppendChild(attribute)
+				}
+				result.AppendChild(attributes)
+				conn.Write(ldapMessage(id, result).Bytes())
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(id int64, tag ber.Tag, code int) []byte {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op).Bytes()
+}
+
+func TestCredentials(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeLDAP(t, map[string]*ldapEntry{
+		"cn=admin,dc=example": {password: "admin"},
+		"uid=test,ou=people,dc=example": {password: "ldap-secret", attributes: map[string][]string{
+			"uid":      {"test"},
+			"mail":     {"test@example.com"},
+			"memberOf": {"cn=admins,ou=groups,dc=example", "cn=dev,ou=groups,dc=example"},
+		}},
+	})
+	rep := authorize.NewSimpleUserRepository()
+	rep.Add(&authorize.SimpleUser{ID: "1", Username: "test", Claims: map[string]string{"foo": "bar"}})
+	credentials := NewCredentials(Config{
+		URL:              server.URL(),
+		BindDN:           "cn=admin,dc=example",
+		BindPassword:     "admin",
+		BaseDN:           "ou=people,dc=example",
+		GroupAttribute:   "memberOf",
+		AttributeMapping: map[string]string{"mail": "email"},
+		Resolve:          authorize.LinkLocalUser(rep, false),
+	})
+	m := tokenutil.NewManager()
+	auth := authorize.New(rep, m, authorize.WithCredentials(credentials))
+
+	token, err := auth.Authorize(ctx, &loginRequest{username: "test", password: "ldap-secret", clientID: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := m.ValidateToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Get("foo") != "bar" || claims.Get("email") != "test@example.com" || claims.Get(authorize.ClaimRoles) != "admins,dev" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	cases := map[string]struct {
+		username, password string
+		err                error
+	}{
+		"wrong password":   {"test", "wrong", authorize.ErrInvalidPassword},
+		"empty password":   {"test", "", authorize.ErrInvalidPassword},
+		"unknown user":     {"nobody", "ldap-secret", authorize.ErrInvalidUsername},
+		"filter injection": {"*", "ldap-secret", authorize.ErrInvalidUsername},
+	}
+	for name, c := range cases {
+		if _, err = auth.Authorize(ctx, &loginRequest{username: c.username, password: c.password, clientID: "web"}); !errors.Is(err, c.err) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	credentials = NewCredentials(Config{URL: server.URL(), BindDN: "cn=admin,dc=example", BindPassword: "admin", BaseDN: "ou=people,dc=example"})
+	if user, err := credentials.Verify(ctx, &loginRequest{username: "test", password: "ldap-secret"}); err != nil ||
+		user.GetID() != authorize.ExternalID(server.URL(), "uid=test,ou=people,dc=example") {
+		t.Errorf("unexpected directory user: %v %v", user, err)
+	}
+
+	credentials = NewCredentials(Config{URL: server.URL(), BindDN: "cn=admin,dc=example", BindPassword: "wrong"})
+	if _, err = credentials.Verify(ctx, &loginRequest{username: "test", password: "ldap-secret"}); err == nil ||
+		errors.Is(err, authorize.ErrInvalidPassword) || errors.Is(err, authorize.ErrInvalidUsername) {
+		t.Errorf("service bind failure reported as wrong credentials: %v", err)
+	}
+}
+
+type loginRequest struct {
+	username, password, clientID string
+}
+
+func (r *loginRequest) GetUsername() string { return r.username }
+func (r *loginRequest) GetPassword() string { return r.password }
+func (r *loginRequest) GetClientID() string { return r.clientID }
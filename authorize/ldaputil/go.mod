@@ -0,0 +1,26 @@
+module github.com/go-chocolate/contrib/authorize/ldaputil
+
+go 1.20
+
+require (
+	github.com/go-asn1-ber/asn1-ber v1.5.5
+	github.com/go-chocolate/contrib/authorize v0.0.0-20261018123140-342e68f36e1c
+	github.com/go-ldap/ldap/v3 v3.4.6
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
+	github.com/go-chocolate/configuration/common v0.0.0-20231226080250-a7086d866e72 // indirect
+	github.com/go-chocolate/contrib/kv v0.0.0-20261018123140-342e68f36e1c // indirect
+	github.com/google/uuid v1.3.1 // indirect
+	github.com/redis/go-redis/v9 v9.3.1 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
+
+// in-tree development builds against the sibling modules, consumers resolve the versions required above
+replace github.com/go-chocolate/contrib/authorize => ../
+
+replace github.com/go-chocolate/contrib/kv => ../../kv
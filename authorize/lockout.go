@@ -0,0 +1,185 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-chocolate/contrib/kv"
+)
+
+type LockoutConfig struct {
+	UsernameThreshold int           // failures per username before lockout, default 5, negative means unlimited
+	IPThreshold       int           // failures per client ip before lockout, default 20, negative means unlimited
+	Window            time.Duration // failures older than window are forgotten, default 15m
+	LockoutDuration   time.Duration // first lockout duration, doubled on every further failure, default 1m
+	MaxLockout        time.Duration // upper bound of the lockout duration, default 1h
+	Prefix            string        // storage key prefix, default "lockout:"
+}
+
+func (c *LockoutConfig) init() {
+	if c.UsernameThreshold == 0 {
+		c.UsernameThreshold = 5
+	}
+	if c.IPThreshold == 0 {
+		c.IPThreshold = 20
+	}
+	if c.Window <= 0 {
+		c.Window = 15 * time.Minute
+	}
+	if c.LockoutDuration <= 0 {
+		c.LockoutDuration = time.Minute
+	}
+	if c.MaxLockout <= 0 {
+		c.MaxLockout = time.Hour
+	}
+	if c.Prefix == "" {
+		c.Prefix = "lockout:"
+	}
+}
+
+type lockoutRecord struct {
+	Failures    int   `json:"failures"`
+	LockedUntil int64 `json:"lockedUntil"`
+}
+
+// Lockout counts failed logins per username and per client ip and locks them out temporarily
+// once a threshold is reached. Failures are counted atomically if the storage is a kv.CompareAndSwapper,
+// otherwise only within the process.
+type Lockout struct {
+	storage kv.Storage
+	config  LockoutConfig
+	now     func() time.Time
+	mu      sync.Mutex
+}
+
+func NewLockout(storage kv.Storage, config LockoutConfig) *Lockout {
+	config.init()
+	return &Lockout{storage: storage, config: config, now: time.Now}
+}
+
+// Check returns an *AccountLockedError if the username or the client ip is locked.
+func (l *Lockout) Check(ctx context.Context, username, ip string) error {
+	now := l.now()
+	var until int64
+	for _, key := range l.keys(username, ip) {
+		if rec := l.load(ctx, key); rec.LockedUntil > until {
+			until = rec.LockedUntil
+		}
+	}
+	if until > now.UnixMilli() {
+		return &AccountLockedError{RetryAfter: time.UnixMilli(until)}
+	}
+	return nil
+}
+
+// Fail records a failed login for the username and the client ip.
+func (l *Lockout) Fail(ctx context.Context, username, ip string) error {
+	if username != "" {
+		if err := l.fail(ctx, l.usernameKey(username), l.config.UsernameThreshold); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := l.fail(ctx, l.ipKey(ip), l.config.IPThreshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed resets the failures of the username. The ip counter is kept, otherwise an attacker
+// could reset it by logging into an account of their own.
+func (l *Lockout) Succeed(ctx context.Context, username string) error {
+	return l.Unlock(ctx, username)
+}
+
+// Unlock resets the failures and the lockout of the username.
+func (l *Lockout) Unlock(ctx context.Context, username string) error {
+	return l.storage.Del(ctx, l.usernameKey(username))
+}
+
+// UnlockIP resets the failures and the lockout of the client ip.
+func (l *Lockout) UnlockIP(ctx context.Context, ip string) error {
+	return l.storage.Del(ctx, l.ipKey(ip))
+}
+
+func (l *Lockout) fail(ctx context.Context, key string, threshold int) error {
+	cas, atomic := l.storage.(kv.CompareAndSwapper)
+	if !atomic {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	for {
+		old, err := l.storage.Get(ctx, key)
+		if errors.Is(err, kv.ErrNotFound) {
+			old, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec lockoutRecord
+		if len(old) > 0 {
+			_ = json.Unmarshal(old, &rec)
+		}
+		b, ttl := l.failed(rec, threshold)
+		if !atomic {
+			return l.storage.Set(ctx, key, b, ttl)
+		}
+		// retry if the record was changed concurrently
+		if swapped, err := cas.CompareAndSwap(ctx, key, old, b, ttl); err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// failed returns the record with one more failure and its time to live.
+func (l *Lockout) failed(rec lockoutRecord, threshold int) ([]byte, time.Duration) {
+	rec.Failures++
+	ttl := l.config.Window
+	if threshold > 0 && rec.Failures >= threshold {
+		d := l.config.MaxLockout
+		if n := rec.Failures - threshold; n < 30 {
+			if v := l.config.LockoutDuration << n; v < d {
+				d = v
+			}
+		}
+		rec.LockedUntil = l.now().Add(d).UnixMilli()
+		if d > ttl {
+			ttl = d
+		}
+	}
+	b, _ := json.Marshal(rec)
+	return b, ttl
+}
+
+func (l *Lockout) load(ctx context.Context, key string) lockoutRecord {
+	var rec lockoutRecord
+	// kv drivers report missing keys as errors, both are treated as no failures
+	if b, err := l.storage.Get(ctx, key); err == nil && len(b) > 0 {
+		_ = json.Unmarshal(b, &rec)
+	}
+	return rec
+}
+
+func (l *Lockout) keys(username, ip string) []string {
+	var keys []string
+	if username != "" {
+		keys = append(keys, l.usernameKey(username))
+	}
+	if ip != "" {
+		keys = append(keys, l.ipKey(ip))
+	}
+	return keys
+}
+
+func (l *Lockout) usernameKey(username string) string {
+	return fmt.Sprintf("%suser:%s", l.config.Prefix, username)
+}
+
+func (l *Lockout) ipKey(ip string) string {
+	return fmt.Sprintf("%sip:%s", l.config.Prefix, ip)
+}
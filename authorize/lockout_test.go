@@ -0,0 +1,109 @@
+package authorize
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	l := NewLockout(tokenutil.NewMemoryStorage(), LockoutConfig{UsernameThreshold: 3, IPThreshold: 10})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.Fail(ctx, "test", "127.0.0.1")
+	}
+	if err := l.Check(ctx, "test", "127.0.0.1"); err != nil {
+		t.Errorf("locked before threshold: %v", err)
+	}
+	l.Fail(ctx, "test", "127.0.0.1")
+	err := l.Check(ctx, "test", "127.0.0.1")
+	var locked *AccountLockedError
+	if !errors.As(err, &locked) || !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !locked.RetryAfter.Equal(now.Add(time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("unexpected retry after: %v", locked.RetryAfter)
+	}
+
+	l.Fail(ctx, "test", "127.0.0.1")
+	if err := l.Check(ctx, "test", ""); !errors.As(err, &locked) || !locked.RetryAfter.Equal(now.Add(2*time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("lockout is not doubled: %v", err)
+	}
+
+	if err := l.Unlock(ctx, "test"); err != nil {
+		t.Error(err)
+	}
+	if err := l.Check(ctx, "test", "127.0.0.1"); err != nil {
+		t.Errorf("still locked after unlock: %v", err)
+	}
+}
+
+func TestLockout_Concurrent(t *testing.T) {
+	storages := map[string]kv.Storage{
+		"locked": tokenutil.NewMemoryStorage(),
+		"cas":    kv.MustNew(kv.Config{Driver: kv.MEMORY, Option: kv.Option{"CleanupInterval": "-1s"}}),
+	}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			l := NewLockout(storage, LockoutConfig{UsernameThreshold: -1, IPThreshold: -1})
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := l.Fail(ctx, "test", "127.0.0.1"); err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+			for _, key := range l.keys("test", "127.0.0.1") {
+				if rec := l.load(ctx, key); rec.Failures != 50 {
+					t.Errorf("%s: lost failures: %d", key, rec.Failures)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorization_Lockout(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+	auth := New(rep, tokenutil.NewManager(), WithLockout(NewLockout(tokenutil.NewMemoryStorage(), LockoutConfig{UsernameThreshold: 2})))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("username", "test")
+		form.Set("password", password)
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/login", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		auth.HTTPHandler().ServeHTTP(response, request)
+		return response
+	}
+	for i := 0; i < 2; i++ {
+		if response := login("wrong"); response.Code != http.StatusBadRequest {
+			t.Errorf("unexpected status: %d", response.Code)
+		}
+	}
+	response := login("wrong")
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("unexpected status: %d", response.Code)
+	}
+	if response.Header().Get("Retry-After") != "60" {
+		t.Errorf("unexpected Retry-After: %s", response.Header().Get("Retry-After"))
+	}
+}
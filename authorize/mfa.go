@@ -0,0 +1,331 @@
+package authorize
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+	"github.com/go-chocolate/contrib/authorize/otputil"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+// MFAUser is an optional User extension, users with a TOTP secret must pass a second step after the password.
+type MFAUser interface {
+	// GetMFASecret returns the base32 TOTP secret, empty if the user has not opted into MFA.
+	GetMFASecret() string
+	// GetRecoveryCodes returns the hashes of the unused recovery codes, see otputil.HashRecoveryCode.
+	GetRecoveryCodes() []string
+}
+
+// RecoveryCodeUpdater is an optional UserRepository extension to consume recovery codes,
+// recovery codes are rejected if the repository does not implement it.
+type RecoveryCodeUpdater interface {
+	UpdateRecoveryCodes(ctx context.Context, user User, codes []string) error
+}
+
+type MFAConfig struct {
+	OTP          otputil.Config
+	Issuer       string        // issuer shown by authenticator apps
+	ChallengeTTL time.Duration // how long a challenge can be exchanged, default 5m
+	MaxAttempts  int           // wrong codes before a challenge is discarded, default 5
+	Prefix       string        // storage key prefix, default "mfa:"
+}
+
+func (c *MFAConfig) init() {
+	if c.OTP == (otputil.Config{}) {
+		c.OTP = otputil.DefaultConfig()
+	}
+	if c.ChallengeTTL <= 0 {
+		c.ChallengeTTL = 5 * time.Minute
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 5
+	}
+	if c.Prefix == "" {
+		c.Prefix = "mfa:"
+	}
+}
+
+type mfaChallenge struct {
+	Username string            `json:"username"`
+	ClientID string            `json:"clientId"`
+	ClientIP string            `json:"clientIp"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Claims   map[string]string `json:"claims,omitempty"`
+	Attempts int               `json:"attempts"`
+	Expires  int64             `json:"expires"`
+}
+
+// MFA keeps the pending second step challenges and the used TOTP steps to prevent replays.
+type MFA struct {
+	storage kv.Storage
+	config  MFAConfig
+	now     func() time.Time
+	mu      sync.Mutex // updates keys if the storage does not support compare and swap
+	codes   sync.Mutex // consumes recovery codes, repositories cannot update them atomically
+}
+
+func NewMFA(storage kv.Storage, config MFAConfig) *MFA {
+	config.init()
+	return &MFA{storage: storage, config: config, now: time.Now}
+}
+
+// Enroll generates a TOTP secret for the account and its otpauth:// provisioning uri.
+func (m *MFA) Enroll(account string) (secret string, uri string, err error) {
+	if secret, err = otputil.GenerateSecret(); err != nil {
+		return "", "", err
+	}
+	return secret, m.config.OTP.ProvisioningURI(m.config.Issuer, account, secret), nil
+}
+
+// RecoveryCodes generates n recovery codes to show to the user once and the hashes to store.
+func (m *MFA) RecoveryCodes(n int) (codes []string, hashes []string, err error) {
+	if codes, err = otputil.GenerateRecoveryCodes(n); err != nil {
+		return nil, nil, err
+	}
+	for _, code := range codes {
+		hashes = append(hashes, otputil.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func (m *MFA) challenge(ctx context.Context, request Request, ip string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	c := &mfaChallenge{
+		Username: request.GetUsername(),
+		ClientID: request.GetClientID(),
+		ClientIP: ip,
+		Tenant:   tokenutil.TenantFromContext(ctx),
+		Claims:   requestClaims(request),
+		Expires:  m.now().Add(m.config.ChallengeTTL).UnixMilli(),
+	}
+	return id, m.save(ctx, id, c)
+}
+
+func (m *MFA) load(ctx context.Context, id string) *mfaChallenge {
+	var c mfaChallenge
+	if b, err := m.storage.Get(ctx, m.config.Prefix+"challenge:"+id); err != nil || json.Unmarshal(b, &c) != nil {
+		return nil
+	}
+	// the storage may not honour expiration
+	if m.now().UnixMilli() > c.Expires {
+		return nil
+	}
+	return &c
+}
+
+func (m *MFA) save(ctx context.Context, id string, c *mfaChallenge) error {
+	b, _ := json.Marshal(c)
+	return m.storage.Set(ctx, m.config.Prefix+"challenge:"+id, b, m.config.ChallengeTTL)
+}
+
+// consume deletes the challenge and reports whether it was still pending.
+func (m *MFA) consume(ctx context.Context, id string) (bool, error) {
+	return m.update(ctx, m.config.Prefix+"challenge:"+id, func(old []byte) ([]byte, time.Duration, bool) {
+		return nil, 0, old != nil
+	})
+}
+
+// attempt counts a wrong code for the challenge and discards it after MaxAttempts.
+func (m *MFA) attempt(ctx context.Context, id string) error {
+	_, err := m.update(ctx, m.config.Prefix+"challenge:"+id, func(old []byte) ([]byte, time.Duration, bool) {
+		var c mfaChallenge
+		if old == nil || json.Unmarshal(old, &c) != nil {
+			return nil, 0, false
+		}
+		if c.Attempts++; c.Attempts >= m.config.MaxAttempts {
+			return nil, 0, true
+		}
+		b, _ := json.Marshal(&c)
+		return b, m.config.ChallengeTTL, true
+	})
+	return err
+}
+
+// update replaces the value of key by the result of fn atomically, a nil value deletes the key and
+// fn returns false to leave it unchanged. It reports whether the value was replaced.
+func (m *MFA) update(ctx context.Context, key string, fn func(old []byte) ([]byte, time.Duration, bool)) (bool, error) {
+	cas, atomic := m.storage.(kv.CompareAndSwapper)
+	if !atomic {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+	for {
+		old, err := m.storage.Get(ctx, key)
+		if errors.Is(err, kv.ErrNotFound) {
+			old, err = nil, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		val, ttl, ok := fn(old)
+		if !ok {
+			return false, nil
+		}
+		if !atomic {
+			if val == nil {
+				return true, m.storage.Del(ctx, key)
+			}
+			return true, m.storage.Set(ctx, key, val, ttl)
+		}
+		// retry if the value was changed concurrently
+		if swapped, err := cas.CompareAndSwap(ctx, key, old, val, ttl); err != nil || swapped {
+			return swapped, err
+		}
+	}
+}
+
+// verifyTOTP validates the code and rejects codes of a step which has been used already.
+func (m *MFA) verifyTOTP(ctx context.Context, user User, secret, code string) (bool, error) {
+	step, ok := m.config.OTP.ValidateTOTP(secret, code, m.now())
+	if !ok {
+		return false, nil
+	}
+	window := time.Duration(2*m.config.OTP.Skew+1) * m.config.OTP.Period
+	return m.update(ctx, m.config.Prefix+"used:"+user.GetID(), func(old []byte) ([]byte, time.Duration, bool) {
+		if used, err := strconv.ParseInt(string(old), 10, 64); err == nil && step <= used {
+			return nil, 0, false
+		}
+		return []byte(strconv.FormatInt(step, 10)), window, true
+	})
+}
+
+func (a *Authorization) verifyRecoveryCode(ctx context.Context, username, code string) (bool, error) {
+	updater, ok := a.rep.(RecoveryCodeUpdater)
+	if !ok {
+		return false, nil
+	}
+	a.mfa.codes.Lock()
+	defer a.mfa.codes.Unlock()
+	// the codes are read again under the lock, another request may have consumed one
+	user, err := getUser(ctx, a.rep, username)
+	if err != nil || user == nil {
+		return false, err
+	}
+	u, ok := user.(MFAUser)
+	if !ok {
+		return false, nil
+	}
+	hashes := u.GetRecoveryCodes()
+	hashed := otputil.HashRecoveryCode(code)
+	for i, v := range hashes {
+		if subtle.ConstantTimeCompare([]byte(v), []byte(hashed)) == 1 {
+			remaining := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+			return true, updater.UpdateRecoveryCodes(ctx, user, remaining)
+		}
+	}
+	return false, nil
+}
+
+// WithMFA enable the second step for users implementing MFAUser, without it their logins fail.
+func WithMFA(mfa *MFA) Option {
+	return func(a *Authorization) {
+		a.mfa = mfa
+	}
+}
+
+// requireMFA returns an *MFARequiredError with a new challenge if the user has opted into MFA.
+func (a *Authorization) requireMFA(ctx context.Context, user User, request Request, ip string) error {
+	u, ok := user.(MFAUser)
+	if !ok || u.GetMFASecret() == "" {
+		return nil
+	}
+	if a.mfa == nil {
+		return ErrMFANotConfigured
+	}
+	challenge, err := a.mfa.challenge(ctx, request, ip)
+	if err != nil {
+		return err
+	}
+	return &MFARequiredError{Challenge: challenge}
+}
+
+// VerifyMFA exchanges the challenge returned with an *MFARequiredError and a TOTP or recovery code for tokens.
+func (a *Authorization) VerifyMFA(ctx context.Context, challenge, code string) (*tokenutil.TokenPair, error) {
+	if a.mfa == nil {
+		return nil, ErrMFANotConfigured
+	}
+	c := a.mfa.load(ctx, challenge)
+	if c == nil {
+		return nil, ErrInvalidChallenge
+	}
+	if a.lockout != nil {
+		if err := a.lockout.Check(ctx, c.Username, c.ClientIP); err != nil {
+			return nil, err
+		}
+	}
+	// the challenge is bound to the tenant of the login
+	if tenant := tokenutil.TenantFromContext(ctx); tenant != "" && tenant != c.Tenant {
+		return nil, ErrInvalidChallenge
+	}
+	ctx = tokenutil.WithTenant(ctx, c.Tenant)
+	user, err := getUser(ctx, a.rep, c.Username)
+	if err != nil || user == nil {
+		return nil, ErrInvalidChallenge
+	}
+	u, ok := user.(MFAUser)
+	if !ok || u.GetMFASecret() == "" {
+		return nil, ErrInvalidChallenge
+	}
+
+	if len(code) == a.mfa.config.OTP.Digits {
+		ok, err = a.mfa.verifyTOTP(ctx, user, u.GetMFASecret(), code)
+	} else {
+		ok, err = a.verifyRecoveryCode(ctx, c.Username, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		a.fail(ctx, &eventutil.Event{Type: eventutil.LoginFailed, UserID: user.GetID(), Username: c.Username, ClientID: c.ClientID, IP: c.ClientIP, Reason: "invalid_mfa_code"})
+		_ = a.mfa.attempt(ctx, challenge)
+		return nil, ErrInvalidMFACode
+	}
+	// concurrent requests with valid codes exchange the challenge only once
+	if ok, err = a.mfa.consume(ctx, challenge); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInvalidChallenge
+	}
+	if a.lockout != nil {
+		_ = a.lockout.Succeed(ctx, c.Username)
+	}
+	a.events.Publish(ctx, &eventutil.Event{Type: eventutil.LoginSucceeded, UserID: user.GetID(), Username: c.Username, ClientID: c.ClientID, IP: c.ClientIP})
+	return a.issue(ctx, user, c.ClientID, c.Claims)
+}
+
+// MFAHTTPHandler exchanges the challenge and code fields of a json or form request for tokens.
+func (a *Authorization) MFAHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = a.withEventMetadata(request)
+		fields, err := readFields(request)
+		if err != nil {
+			a.errorRenderer(writer, request, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+			return
+		}
+		if request, err = a.withBinding(request); err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		pair, err := a.VerifyMFA(request.Context(), fields["challenge"], fields["code"])
+		if err != nil {
+			a.errorRenderer(writer, request, err)
+			return
+		}
+		a.writeSession(writer, request, pair)
+	}
+}
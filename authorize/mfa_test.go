@@ -0,0 +1,165 @@
+package authorize
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/otputil"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+func TestAuthorization_MFA(t *testing.T) {
+	ctx := context.Background()
+	mfa := NewMFA(tokenutil.NewMemoryStorage(), MFAConfig{Issuer: "contrib"})
+	secret, uri, err := mfa.Enroll("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(uri)
+	codes, hashes, _ := mfa.RecoveryCodes(2)
+
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456", MFASecret: secret, RecoveryCodes: hashes})
+	auth := New(rep, tokenutil.NewManager(), WithMFA(mfa))
+
+	login := func() string {
+		_, err := auth.Authorize(ctx, &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "1"})
+		var required *MFARequiredError
+		if !errors.As(err, &required) || !errors.Is(err, ErrMFARequired) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return required.Challenge
+	}
+
+	challenge := login()
+	if _, err = auth.VerifyMFA(ctx, challenge, "000000"); err != ErrInvalidMFACode {
+		t.Errorf("unexpected error: %v", err)
+	}
+	code, _ := otputil.DefaultConfig().TOTP(secret, time.Now())
+	pair, err := auth.VerifyMFA(ctx, challenge, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.token.ValidateToken(ctx, pair.AccessToken); err != nil {
+		t.Error(err)
+	}
+	if _, err = auth.VerifyMFA(ctx, challenge, code); err != ErrInvalidChallenge {
+		t.Errorf("challenge is reused: %v", err)
+	}
+	if _, err = auth.VerifyMFA(ctx, login(), code); err != ErrInvalidMFACode {
+		t.Errorf("totp code is replayed: %v", err)
+	}
+
+	if _, err = auth.VerifyMFA(ctx, login(), codes[0]); err != nil {
+		t.Errorf("recovery code: %v", err)
+	}
+	if _, err = auth.VerifyMFA(ctx, login(), codes[0]); err != ErrInvalidMFACode {
+		t.Errorf("recovery code is reused: %v", err)
+	}
+	if user, _ := rep.GetByUsername(ctx, "test"); len(user.(*SimpleUser).RecoveryCodes) != 1 {
+		t.Errorf("recovery code is not consumed")
+	}
+}
+
+// slowStorage delays the results of reads to widen the window between reading and writing a key.
+type slowStorage struct {
+	kv.Storage
+}
+
+func (s slowStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.Storage.Get(ctx, key)
+	time.Sleep(time.Millisecond)
+	return val, err
+}
+
+// slowRepository returns delayed copies of the users, like repositories reading from a database.
+type slowRepository struct {
+	*SimpleUserRepository
+}
+
+func (rep slowRepository) GetByUsername(ctx context.Context, username string) (User, error) {
+	user, err := rep.SimpleUserRepository.GetByUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	u := *user.(*SimpleUser)
+	time.Sleep(time.Millisecond)
+	return &u, nil
+}
+
+func TestAuthorization_ConcurrentMFA(t *testing.T) {
+	storages := map[string]func() kv.Storage{
+		"memory": func() kv.Storage { return slowStorage{tokenutil.NewMemoryStorage()} },
+		"kv": func() kv.Storage {
+			storage := kv.MustNew(kv.Config{Driver: kv.MEMORY})
+			return struct {
+				slowStorage
+				kv.CompareAndSwapper
+			}{slowStorage{storage}, storage.(kv.CompareAndSwapper)}
+		},
+	}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			mfa := NewMFA(storage(), MFAConfig{})
+			secret, _, _ := mfa.Enroll("test")
+			codes, hashes, _ := mfa.RecoveryCodes(1)
+			rep := NewSimpleUserRepository()
+			rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456", MFASecret: secret, RecoveryCodes: hashes})
+			auth := New(slowRepository{rep}, tokenutil.NewManager(), WithMFA(mfa))
+			login := func() string {
+				_, err := auth.Authorize(ctx, &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "1"})
+				var required *MFARequiredError
+				if !errors.As(err, &required) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return required.Challenge
+			}
+			// verify submits the codes concurrently and counts the exchanged challenges
+			verify := func(challenges []string, code string) int {
+				var wg sync.WaitGroup
+				var succeeded atomic.Int32
+				for _, challenge := range challenges {
+					wg.Add(1)
+					go func(challenge string) {
+						defer wg.Done()
+						if _, err := auth.VerifyMFA(ctx, challenge, code); err == nil {
+							succeeded.Add(1)
+						}
+					}(challenge)
+				}
+				wg.Wait()
+				return int(succeeded.Load())
+			}
+
+			challenge := login()
+			if n := verify([]string{challenge, challenge, challenge, challenge}, codes[0]); n != 1 {
+				t.Errorf("challenge exchanged %d times", n)
+			}
+			code, _ := otputil.DefaultConfig().TOTP(secret, time.Now())
+			if n := verify([]string{login(), login(), login(), login()}, code); n != 1 {
+				t.Errorf("totp code used %d times", n)
+			}
+			codes, hashes, _ = mfa.RecoveryCodes(1)
+			_ = rep.UpdateRecoveryCodes(ctx, &SimpleUser{Username: "test"}, hashes)
+			if n := verify([]string{login(), login(), login(), login()}, codes[0]); n != 1 {
+				t.Errorf("recovery code used %d times", n)
+			}
+
+			challenge = login()
+			attempts := make([]string, mfa.config.MaxAttempts)
+			for i := range attempts {
+				attempts[i] = challenge
+			}
+			verify(attempts, "000000")
+			if mfa.load(ctx, challenge) != nil {
+				t.Error("wrong codes lost, challenge not discarded")
+			}
+		})
+	}
+}
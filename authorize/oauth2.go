@@ -0,0 +1,499 @@
+package authorize
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+const ClaimScope = "scope"
+
+// OAuth2Error is an OAuth2 error response as defined by RFC 6749 section 5.2.
+type OAuth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	status      int
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+func oauth2Error(code, description string) *OAuth2Error {
+	status := http.StatusBadRequest
+	if code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	return &OAuth2Error{Code: code, Description: description, status: status}
+}
+
+type OAuth2Config struct {
+	CodeTTL time.Duration // lifetime of authorization codes, default 10m
+	Prefix  string        // storage key prefix, default "oauth2:"
+}
+
+func (c *OAuth2Config) init() {
+	if c.CodeTTL <= 0 {
+		c.CodeTTL = 10 * time.Minute
+	}
+	if c.Prefix == "" {
+		c.Prefix = "oauth2:"
+	}
+}
+
+type authorizationCode struct {
+	ClientID            string           `json:"clientId"`
+	RedirectURI         string           `json:"redirectUri"`
+	RedirectURIGiven    bool             `json:"redirectUriGiven,omitempty"` // redirect_uri was part of the request
+	UserID              string           `json:"userId"`
+	Claims              tokenutil.Claims `json:"claims"`
+	CodeChallenge       string           `json:"codeChallenge,omitempty"`
+	CodeChallengeMethod string           `json:"codeChallengeMethod,omitempty"`
+	Expires             int64            `json:"expires"`
+}
+
+// OAuth2Server serves the OAuth2 authorization, token, introspection (RFC 7662) and revocation (RFC 7009)
+// endpoints, tokens are issued by the tokenutil.Manager of the Authorization with the OAuth2 client id as
+// client id of the session.
+type OAuth2Server struct {
+	auth    *Authorization
+	clients ClientRepository
+	storage kv.Storage
+	config  OAuth2Config
+	mu      sync.Mutex // redeems codes if the storage does not support compare and swap
+}
+
+func NewOAuth2Server(auth *Authorization, clients ClientRepository, storage kv.Storage, config OAuth2Config) *OAuth2Server {
+	config.init()
+	return &OAuth2Server{auth: auth, clients: clients, storage: storage, config: config}
+}
+
+// AuthorizeHTTPHandler serves the authorization endpoint of the authorization_code grant. The resource owner must
+// be signed in, the handler should be wrapped by a consent page if the client is not trusted.
+func (s *OAuth2Server) AuthorizeHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = s.auth.withEventMetadata(request)
+		ctx := request.Context()
+		query := request.URL.Query()
+		client, err := s.clients.GetByClientID(ctx, query.Get("client_id"))
+		if err != nil || client == nil {
+			writeOAuth2Error(writer, oauth2Error("invalid_request", "unknown client"))
+			return
+		}
+		redirectURI := query.Get("redirect_uri")
+		if redirectURI == "" && len(client.GetRedirectURIs()) == 1 {
+			redirectURI = client.GetRedirectURIs()[0]
+		}
+		if !contains(client.GetRedirectURIs(), redirectURI) {
+			writeOAuth2Error(writer, oauth2Error("invalid_request", "unregistered redirect_uri"))
+			return
+		}
+
+		session := tokenutil.SessionFromContext(ctx)
+		claims := tokenutil.FromContext(ctx)
+		if session == nil {
+			if session, claims, err = s.auth.token.ValidateHTTPSession(request, tokenutil.TokenFromHTTPRequest(request)); err != nil {
+				s.auth.errorRenderer(writer, request, err)
+				return
+			}
+		}
+
+		code, err := s.authorize(ctx, client, session.UserId, claims, redirectURI, query)
+		target, _ := url.Parse(redirectURI)
+		params := target.Query()
+		var e *OAuth2Error
+		if errors.As(err, &e) {
+			params.Set("error", e.Code)
+			params.Set("error_description", e.Description)
+		} else if err != nil {
+			params.Set("error", "server_error")
+		} else {
+			params.Set("code", code)
+		}
+		if state := query.Get("state"); state != "" {
+			params.Set("state", state)
+		}
+		target.RawQuery = params.Encode()
+		http.Redirect(writer, request, target.String(), http.StatusFound)
+	}
+}
+
+func (s *OAuth2Server) authorize(ctx context.Context, client Client, userID string, claims tokenutil.Claims, redirectURI string, query url.Values) (string, error) {
+	if query.Get("response_type") != "code" {
+		return "", oauth2Error("unsupported_response_type", "")
+	}
+	if !contains(client.GetGrantTypes(), GrantAuthorizationCode) {
+		return "", oauth2Error("unauthorized_client", "")
+	}
+	scope, err := s.scope(client, query.Get("scope"))
+	if err != nil {
+		return "", err
+	}
+	challenge, method := query.Get("code_challenge"), query.Get("code_challenge_method")
+	if method == "" && challenge != "" {
+		method = "plain"
+	}
+	if challenge == "" && client.GetSecret() == "" {
+		return "", oauth2Error("invalid_request", "code_challenge is required for public clients")
+	}
+	if challenge != "" && method != "S256" && method != "plain" {
+		return "", oauth2Error("invalid_request", "unsupported code_challenge_method")
+	}
+
+	code := randomToken()
+	record := authorizationCode{
+		ClientID:            client.GetClientID(),
+		RedirectURI:         redirectURI,
+		RedirectURIGiven:    query.Get("redirect_uri") != "",
+		UserID:              userID,
+		Claims:              withScope(claims, scope),
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: method,
+		Expires:             time.Now().Add(s.config.CodeTTL).UnixMilli(),
+	}
+	b, _ := json.Marshal(record)
+	if err = s.storage.Set(ctx, s.config.Prefix+"code:"+code, b, s.config.CodeTTL); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// TokenHTTPHandler serves the token endpoint for the password, client_credentials, authorization_code and
+// refresh_token grants.
+func (s *OAuth2Server) TokenHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = s.auth.withEventMetadata(request)
+		if request.Method != http.MethodPost {
+			writeOAuth2Error(writer, oauth2Error("invalid_request", "POST is required"))
+			return
+		}
+		client, err := s.authenticateClient(request)
+		if err != nil {
+			writeOAuth2Error(writer, err)
+			return
+		}
+		if request, err = s.auth.withBinding(request); err != nil {
+			writeOAuth2Error(writer, oauth2Error("invalid_dpop_proof", ""))
+			return
+		}
+		ctx := request.Context()
+		grantType := request.PostFormValue("grant_type")
+		if !contains(client.GetGrantTypes(), grantType) {
+			writeOAuth2Error(writer, oauth2Error("unauthorized_client", ""))
+			return
+		}
+
+		var pair *tokenutil.TokenPair
+		var scope string
+		switch grantType {
+		case GrantPassword:
+			pair, scope, err = s.passwordGrant(ctx, client, request)
+		case GrantClientCredentials:
+			pair, scope, err = s.clientCredentialsGrant(ctx, client, request)
+		case GrantAuthorizationCode:
+			pair, scope, err = s.authorizationCodeGrant(ctx, client, request)
+		case GrantRefreshToken:
+			pair, err = s.refreshTokenGrant(ctx, client, request)
+		default:
+			err = oauth2Error("unsupported_grant_type", "")
+		}
+		if err != nil {
+			writeOAuth2Error(writer, err)
+			return
+		}
+
+		body := map[string]any{
+			"access_token": pair.AccessToken,
+			"token_type":   "Bearer",
+			"expires_in":   pair.ExpiresIn,
+		}
+		if pair.RefreshToken != "" {
+			body["refresh_token"] = pair.RefreshToken
+		}
+		if scope != "" {
+			body["scope"] = scope
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(writer).Encode(body)
+	}
+}
+
+func (s *OAuth2Server) passwordGrant(ctx context.Context, client Client, request *http.Request) (*tokenutil.TokenPair, string, error) {
+	scope, err := s.scope(client, request.PostFormValue("scope"))
+	if err != nil {
+		return nil, "", err
+	}
+	user, err := s.auth.authenticate(ctx, &formRequest{
+		username: request.PostFormValue("username"),
+		password: request.PostFormValue("password"),
+		clientID: client.GetClientID(),
+		clientIP: clientIP(request),
+	})
+	if err != nil {
+		return nil, "", oauth2Error("invalid_grant", err.Error())
+	}
+	claims, err := s.auth.accessClaims(ctx, user)
+	if err != nil {
+		return nil, "", err
+	}
+	pair, err := s.auth.token.GenTokenPair(ctx, user.GetID(), client.GetClientID(), withScope(claims, scope))
+	return pair, scope, err
+}
+
+func (s *OAuth2Server) clientCredentialsGrant(ctx context.Context, client Client, request *http.Request) (*tokenutil.TokenPair, string, error) {
+	if client.GetSecret() == "" {
+		return nil, "", oauth2Error("unauthorized_client", "public clients can not use client_credentials")
+	}
+	scope, err := s.scope(client, request.PostFormValue("scope"))
+	if err != nil {
+		return nil, "", err
+	}
+	pair, err := s.auth.token.GenTokenPair(ctx, "client:"+client.GetClientID(), client.GetClientID(), withScope(nil, scope))
+	if err != nil {
+		return nil, "", err
+	}
+	// RFC 6749 section 4.4.3, no refresh token for client credentials
+	pair.RefreshToken = ""
+	return pair, scope, nil
+}
+
+func (s *OAuth2Server) authorizationCodeGrant(ctx context.Context, client Client, request *http.Request) (*tokenutil.TokenPair, string, error) {
+	record, err := s.redeemCode(ctx, request.PostFormValue("code"))
+	if err != nil {
+		return nil, "", err
+	}
+	// RFC 6749 section 4.1.3, redirect_uri is required if it was part of the authorization request
+	redirectURI := request.PostFormValue("redirect_uri")
+	if redirectURI == "" && !record.RedirectURIGiven {
+		redirectURI = record.RedirectURI
+	}
+	if record.ClientID != client.GetClientID() || record.RedirectURI != redirectURI {
+		return nil, "", oauth2Error("invalid_grant", "code was issued to another client or redirect_uri")
+	}
+	if record.CodeChallenge != "" && !verifyCodeChallenge(record.CodeChallenge, record.CodeChallengeMethod, request.PostFormValue("code_verifier")) {
+		return nil, "", oauth2Error("invalid_grant", "invalid code_verifier")
+	}
+	pair, err := s.auth.token.GenTokenPair(ctx, record.UserID, client.GetClientID(), record.Claims)
+	return pair, record.Claims.Get(ClaimScope), err
+}
+
+// redeemCode returns the record of the authorization code and deletes it, a code is redeemed only once even by
+// concurrent requests.
+func (s *OAuth2Server) redeemCode(ctx context.Context, code string) (*authorizationCode, error) {
+	key := s.config.Prefix + "code:" + code
+	cas, atomic := s.storage.(kv.CompareAndSwapper)
+	if !atomic {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	b, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, oauth2Error("invalid_grant", "invalid code")
+	}
+	if atomic {
+		var swapped bool
+		if swapped, err = cas.CompareAndSwap(ctx, key, b, nil); err != nil {
+			return nil, err
+		} else if !swapped {
+			return nil, oauth2Error("invalid_grant", "invalid code")
+		}
+	} else if err = s.storage.Del(ctx, key); err != nil {
+		return nil, err
+	}
+	var record authorizationCode
+	if json.Unmarshal(b, &record) != nil || time.Now().UnixMilli() > record.Expires {
+		return nil, oauth2Error("invalid_grant", "invalid code")
+	}
+	return &record, nil
+}
+
+func (s *OAuth2Server) refreshTokenGrant(ctx context.Context, client Client, request *http.Request) (*tokenutil.TokenPair, error) {
+	refreshToken := request.PostFormValue("refresh_token")
+	session, err := s.auth.token.ValidateRefreshToken(ctx, refreshToken)
+	if errors.Is(err, tokenutil.ErrTokenReused) {
+		// refreshing revokes the session of a reused refresh token
+		_, err = s.auth.token.Refresh(ctx, refreshToken)
+	}
+	if err != nil {
+		return nil, oauth2Error("invalid_grant", err.Error())
+	}
+	if session.ClientId != client.GetClientID() {
+		return nil, oauth2Error("invalid_grant", "refresh token was issued to another client")
+	}
+	pair, err := s.auth.token.Refresh(ctx, refreshToken)
+	if err != nil {
+		return nil, oauth2Error("invalid_grant", err.Error())
+	}
+	return pair, nil
+}
+
+// IntrospectHTTPHandler serves the RFC 7662 token introspection endpoint for authenticated confidential clients.
+func (s *OAuth2Server) IntrospectHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = s.auth.withEventMetadata(request)
+		ctx := request.Context()
+		if client, err := s.authenticateClient(request); err != nil {
+			writeOAuth2Error(writer, err)
+			return
+		} else if client.GetSecret() == "" {
+			writeOAuth2Error(writer, oauth2Error("invalid_client", "public clients can not introspect tokens"))
+			return
+		}
+		token := request.PostFormValue("token")
+		body := map[string]any{"active": false}
+		if session, claims, err := s.auth.token.ValidateSession(ctx, token); err == nil {
+			body = map[string]any{
+				"active":     true,
+				"token_type": "access_token",
+				"sub":        session.UserId,
+				"client_id":  session.ClientId,
+				"iat":        session.IssuedAt.Unix(),
+			}
+			if scope := claims.Get(ClaimScope); scope != "" {
+				body["scope"] = scope
+			}
+		} else if session, err := s.auth.token.ValidateRefreshToken(ctx, token); err == nil {
+			body = map[string]any{
+				"active":     true,
+				"token_type": "refresh_token",
+				"sub":        session.UserId,
+				"client_id":  session.ClientId,
+				"iat":        session.IssuedAt.Unix(),
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(writer).Encode(body)
+	}
+}
+
+// RevokeHTTPHandler serves the RFC 7009 token revocation endpoint, clients can only revoke their own tokens.
+// Revoking an access or refresh token ends the whole session.
+func (s *OAuth2Server) RevokeHTTPHandler() http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		request = s.auth.withEventMetadata(request)
+		client, err := s.authenticateClient(request)
+		if err != nil {
+			writeOAuth2Error(writer, err)
+			return
+		}
+		if request, err = s.auth.withBinding(request); err != nil {
+			writeOAuth2Error(writer, oauth2Error("invalid_dpop_proof", ""))
+			return
+		}
+		ctx := request.Context()
+		token := request.PostFormValue("token")
+		session, _, err := s.auth.token.ValidateSession(ctx, token)
+		if err != nil {
+			session, err = s.auth.token.ValidateRefreshToken(ctx, token)
+		}
+		// invalid tokens do not cause an error response
+		if err == nil && session.ClientId == client.GetClientID() {
+			if err = s.auth.token.Revoke(ctx, session.UserId, session.ClientId); err != nil {
+				writeOAuth2Error(writer, err)
+				return
+			}
+		}
+		writer.WriteHeader(http.StatusOK)
+	}
+}
+
+// authenticateClient authenticates the client by HTTP basic authentication or the client_id and client_secret
+// form fields, public clients only present their client_id.
+func (s *OAuth2Server) authenticateClient(request *http.Request) (Client, error) {
+	clientID, secret, ok := request.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = request.PostFormValue("client_id"), request.PostFormValue("client_secret")
+	}
+	client, err := s.clients.GetByClientID(request.Context(), clientID)
+	if err != nil || client == nil {
+		return nil, oauth2Error("invalid_client", "")
+	}
+	if client.GetSecret() == "" {
+		if secret != "" {
+			return nil, oauth2Error("invalid_client", "")
+		}
+		return client, nil
+	}
+	if ok, err := VerifyPassword(secret, "", client.GetSecret()); err != nil || !ok {
+		return nil, oauth2Error("invalid_client", "")
+	}
+	return client, nil
+}
+
+// scope validates the requested space separated scopes against the client, all scopes of the client are granted
+// if none is requested.
+func (s *OAuth2Server) scope(client Client, requested string) (string, error) {
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return strings.Join(client.GetScopes(), " "), nil
+	}
+	for _, scope := range scopes {
+		if !contains(client.GetScopes(), scope) {
+			return "", oauth2Error("invalid_scope", scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// withScope returns the claims of a scoped token, the scope claim is set even if it is empty to limit the
+// permissions of the token, see RBAC.
+func withScope(claims tokenutil.Claims, scope string) tokenutil.Claims {
+	c := tokenutil.Claims{}
+	for k, v := range claims {
+		c[k] = v
+	}
+	c[ClaimScope] = scope
+	return c
+}
+
+func verifyCodeChallenge(challenge, method, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	if method == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(verifier)) == 1
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeOAuth2Error(writer http.ResponseWriter, err error) {
+	var e *OAuth2Error
+	if !errors.As(err, &e) {
+		e = &OAuth2Error{Code: "server_error", status: http.StatusInternalServerError}
+	}
+	if e.status == http.StatusUnauthorized {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(e.status)
+	json.NewEncoder(writer).Encode(e)
+}
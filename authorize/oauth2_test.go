@@ -0,0 +1,272 @@
+package authorize
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+func newOAuth2Test(t *testing.T) (*OAuth2Server, *tokenutil.Manager) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{
+		ID:       "1",
+		Username: "test",
+		Secret:   "123456",
+		Password: "123456",
+	})
+	secret, err := NewArgon2idHasher().Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clients := NewSimpleClientRepository()
+	clients.Add(&SimpleClient{
+		ID:         "service",
+		Secret:     secret,
+		GrantTypes: []string{GrantPassword, GrantClientCredentials, GrantRefreshToken},
+		Scopes:     []string{"read", "write"},
+	})
+	clients.Add(&SimpleClient{
+		ID:           "spa",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{GrantAuthorizationCode, GrantRefreshToken},
+		Scopes:       []string{"read"},
+	})
+	m := tokenutil.NewManager()
+	return NewOAuth2Server(New(rep, m), clients, tokenutil.NewMemoryStorage(), OAuth2Config{}), m
+}
+
+func postForm(handler http.Handler, form url.Values, basic ...string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/oauth2", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(basic) == 2 {
+		request.SetBasicAuth(basic[0], basic[1])
+	}
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func decodeBody(t *testing.T, response *httptest.ResponseRecorder) map[string]any {
+	var body map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestOAuth2Server_Password(t *testing.T) {
+	s, _ := newOAuth2Test(t)
+
+	form := url.Values{"grant_type": {GrantPassword}, "username": {"test"}, "password": {"ea48576f30be1669971699c09ad05c94"}, "scope": {"read"}}
+	if response := postForm(s.TokenHTTPHandler(), form, "service", "wrong"); response.Code != http.StatusUnauthorized ||
+		response.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("wrong client secret accepted: %d", response.Code)
+	}
+	response := postForm(s.TokenHTTPHandler(), form, "service", "s3cret")
+	if response.Code != http.StatusOK {
+		t.Fatalf("password grant failed: %d %s", response.Code, response.Body.String())
+	}
+	body := decodeBody(t, response)
+	if body["scope"] != "read" || body["refresh_token"] == nil {
+		t.Errorf("unexpected response: %v", body)
+	}
+	accessToken, refreshToken := body["access_token"].(string), body["refresh_token"].(string)
+
+	introspect := decodeBody(t, postForm(s.IntrospectHTTPHandler(), url.Values{"token": {accessToken}}, "service", "s3cret"))
+	if introspect["active"] != true || introspect["sub"] != "1" || introspect["client_id"] != "service" || introspect["scope"] != "read" {
+		t.Errorf("unexpected introspection: %v", introspect)
+	}
+
+	form = url.Values{"grant_type": {GrantRefreshToken}, "refresh_token": {refreshToken}}
+	if response = postForm(s.TokenHTTPHandler(), form, "service", "s3cret"); response.Code != http.StatusOK {
+		t.Fatalf("refresh token grant failed: %d %s", response.Code, response.Body.String())
+	}
+	refreshToken = decodeBody(t, response)["refresh_token"].(string)
+
+	if response = postForm(s.RevokeHTTPHandler(), url.Values{"token": {refreshToken}}, "service", "s3cret"); response.Code != http.StatusOK {
+		t.Errorf("revocation failed: %d", response.Code)
+	}
+	introspect = decodeBody(t, postForm(s.IntrospectHTTPHandler(), url.Values{"token": {refreshToken}}, "service", "s3cret"))
+	if introspect["active"] != false {
+		t.Errorf("revoked token is active: %v", introspect)
+	}
+
+	form = url.Values{"grant_type": {GrantPassword}, "username": {"test"}, "password": {"ea48576f30be1669971699c09ad05c94"}, "scope": {"admin"}}
+	if response = postForm(s.TokenHTTPHandler(), form, "service", "s3cret"); decodeBody(t, response)["error"] != "invalid_scope" {
+		t.Errorf("unknown scope granted")
+	}
+}
+
+func TestOAuth2Server_ClientCredentials(t *testing.T) {
+	s, _ := newOAuth2Test(t)
+	form := url.Values{"grant_type": {GrantClientCredentials}, "client_id": {"service"}, "client_secret": {"s3cret"}}
+	response := postForm(s.TokenHTTPHandler(), form)
+	if response.Code != http.StatusOK {
+		t.Fatalf("client credentials grant failed: %d %s", response.Code, response.Body.String())
+	}
+	if body := decodeBody(t, response); body["refresh_token"] != nil || body["scope"] != "read write" {
+		t.Errorf("unexpected response: %v", body)
+	}
+
+	form = url.Values{"grant_type": {GrantClientCredentials}, "client_id": {"spa"}}
+	if body := decodeBody(t, postForm(s.TokenHTTPHandler(), form)); body["error"] != "unauthorized_client" {
+		t.Errorf("public client used client credentials: %v", body)
+	}
+}
+
+func TestOAuth2Server_AuthorizationCode(t *testing.T) {
+	s, m := newOAuth2Test(t)
+	pair, err := s.auth.AuthorizeTokenPair(context.Background(), &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := strings.Repeat("v", 64)
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"spa"},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+query.Encode(), nil)
+	request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	s.AuthorizeHTTPHandler().ServeHTTP(response, request)
+	if response.Code != http.StatusFound {
+		t.Fatalf("authorization failed: %d %s", response.Code, response.Body.String())
+	}
+	location, _ := url.Parse(response.Header().Get("Location"))
+	if location.Query().Get("state") != "xyz" || location.Query().Get("code") == "" {
+		t.Fatalf("unexpected redirect: %s", location)
+	}
+
+	form := url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"client_id":     {"spa"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"code_verifier": {strings.Repeat("x", 64)},
+	}
+	if body := decodeBody(t, postForm(s.TokenHTTPHandler(), form)); body["error"] != "invalid_grant" {
+		t.Errorf("wrong code verifier accepted: %v", body)
+	}
+
+	// the code is consumed by the failed attempt
+	query.Set("code_challenge", verifier)
+	query.Set("code_challenge_method", "plain")
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+query.Encode(), nil)
+	request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	s.AuthorizeHTTPHandler().ServeHTTP(response, request)
+	location, _ = url.Parse(response.Header().Get("Location"))
+	form.Set("code", location.Query().Get("code"))
+	form.Set("code_verifier", verifier)
+	response = postForm(s.TokenHTTPHandler(), form)
+	if response.Code != http.StatusOK {
+		t.Fatalf("authorization code grant failed: %d %s", response.Code, response.Body.String())
+	}
+	claims, err := m.ValidateToken(context.Background(), decodeBody(t, response)["access_token"].(string))
+	if err != nil || claims.Get(ClaimScope) != "read" {
+		t.Errorf("unexpected claims: %v %v", claims, err)
+	}
+	if body := decodeBody(t, postForm(s.TokenHTTPHandler(), form)); body["error"] != "invalid_grant" {
+		t.Errorf("code used twice: %v", body)
+	}
+}
+
+func TestOAuth2Server_Introspect(t *testing.T) {
+	s, _ := newOAuth2Test(t)
+	form := url.Values{"grant_type": {GrantPassword}, "username": {"test"}, "password": {"ea48576f30be1669971699c09ad05c94"}}
+	body := decodeBody(t, postForm(s.TokenHTTPHandler(), form, "service", "s3cret"))
+	accessToken, rotated := body["access_token"].(string), body["refresh_token"].(string)
+
+	response := postForm(s.IntrospectHTTPHandler(), url.Values{"token": {accessToken}, "client_id": {"spa"}})
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("public client introspected a token: %d %s", response.Code, response.Body.String())
+	}
+
+	form = url.Values{"grant_type": {GrantRefreshToken}, "refresh_token": {rotated}}
+	refreshToken := decodeBody(t, postForm(s.TokenHTTPHandler(), form, "service", "s3cret"))["refresh_token"].(string)
+	if introspect := decodeBody(t, postForm(s.IntrospectHTTPHandler(), url.Values{"token": {rotated}}, "service", "s3cret")); introspect["active"] != false {
+		t.Errorf("rotated refresh token is active: %v", introspect)
+	}
+	postForm(s.RevokeHTTPHandler(), url.Values{"token": {rotated}}, "service", "s3cret")
+	if introspect := decodeBody(t, postForm(s.IntrospectHTTPHandler(), url.Values{"token": {refreshToken}}, "service", "s3cret")); introspect["active"] != true {
+		t.Errorf("session revoked by introspecting a rotated refresh token: %v", introspect)
+	}
+
+	// presenting the rotated refresh token at the token endpoint still revokes the session
+	if body = decodeBody(t, postForm(s.TokenHTTPHandler(), form, "service", "s3cret")); body["error"] != "invalid_grant" {
+		t.Errorf("reused refresh token accepted: %v", body)
+	}
+	if introspect := decodeBody(t, postForm(s.IntrospectHTTPHandler(), url.Values{"token": {refreshToken}}, "service", "s3cret")); introspect["active"] != false {
+		t.Errorf("session not revoked on reuse: %v", introspect)
+	}
+}
+
+func TestOAuth2Server_AuthorizationCodeOnce(t *testing.T) {
+	storages := map[string]kv.Storage{
+		"locked": tokenutil.NewMemoryStorage(),
+		"cas":    kv.MustNew(kv.Config{Driver: kv.MEMORY, Option: kv.Option{"CleanupInterval": "-1s"}}),
+	}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			s, _ := newOAuth2Test(t)
+			s.storage = storage
+			testAuthorizationCodeOnce(t, s)
+		})
+	}
+}
+
+func testAuthorizationCodeOnce(t *testing.T, s *OAuth2Server) {
+	pair, err := s.auth.AuthorizeTokenPair(context.Background(), &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := strings.Repeat("v", 64)
+	// redirect_uri defaults to the only registered one and is omitted at the token endpoint too
+	query := url.Values{"response_type": {"code"}, "client_id": {"spa"}, "code_challenge": {verifier}}
+	response := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/oauth2/authorize?"+query.Encode(), nil)
+	request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	s.AuthorizeHTTPHandler().ServeHTTP(response, request)
+	location, _ := url.Parse(response.Header().Get("Location"))
+	form := url.Values{
+		"grant_type":    {GrantAuthorizationCode},
+		"client_id":     {"spa"},
+		"code":          {location.Query().Get("code")},
+		"code_verifier": {verifier},
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var redeemed int
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if response := postForm(s.TokenHTTPHandler(), form); response.Code == http.StatusOK {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if redeemed != 1 {
+		t.Errorf("code redeemed %d times", redeemed)
+	}
+}
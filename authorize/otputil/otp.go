@@ -0,0 +1,184 @@
+package otputil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SHA1   = "SHA1"
+	SHA256 = "SHA256"
+	SHA512 = "SHA512"
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Config is the one-time password configuration shared by the generator and the authenticator app.
+type Config struct {
+	Digits    int           // default 6
+	Period    time.Duration // TOTP time step, default 30s
+	Algorithm string        // SHA1, SHA256 or SHA512, default SHA1
+	Skew      int           // accepted TOTP steps before and after the current one, default 1
+}
+
+func DefaultConfig() Config {
+	return Config{Digits: 6, Period: 30 * time.Second, Algorithm: SHA1, Skew: 1}
+}
+
+func (c Config) init() Config {
+	if c.Digits <= 0 {
+		c.Digits = 6
+	}
+	if c.Period <= 0 {
+		c.Period = 30 * time.Second
+	}
+	if c.Algorithm == "" {
+		c.Algorithm = SHA1
+	}
+	return c
+}
+
+func (c Config) hash() func() hash.Hash {
+	switch c.Algorithm {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	default:
+		return sha1.New
+	}
+}
+
+// GenerateSecret generates a random base32 encoded secret of 160 bits.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(b), nil
+}
+
+// HOTP generates the RFC 4226 one-time password of the counter.
+func (c Config) HOTP(secret string, counter uint64) (string, error) {
+	c = c.init()
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "=")))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(c.hash(), key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < c.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", c.Digits, value%mod), nil
+}
+
+// TOTP generates the RFC 6238 one-time password of the time step containing t.
+func (c Config) TOTP(secret string, t time.Time) (string, error) {
+	return c.HOTP(secret, uint64(c.Step(t)))
+}
+
+// Step returns the TOTP time step containing t.
+func (c Config) Step(t time.Time) int64 {
+	c = c.init()
+	return t.Unix() / int64(c.Period/time.Second)
+}
+
+// ValidateHOTP checks code against the counters from counter to counter+lookAhead,
+// the counter following the matched one is returned and must be stored for the next validation.
+func (c Config) ValidateHOTP(secret, code string, counter uint64, lookAhead int) (uint64, bool) {
+	for i := 0; i <= lookAhead; i++ {
+		if expected, err := c.HOTP(secret, counter+uint64(i)); err == nil && equal(expected, code) {
+			return counter + uint64(i) + 1, true
+		}
+	}
+	return counter, false
+}
+
+// ValidateTOTP checks code against the time steps around t within the skew, the matched step is returned
+// and should be remembered to reject replays of the same code.
+func (c Config) ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	step := c.Step(t)
+	for i := -c.Skew; i <= c.Skew; i++ {
+		if expected, err := c.HOTP(secret, uint64(step+int64(i))); err == nil && equal(expected, code) {
+			return step + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// ProvisioningURI returns the otpauth:// uri of a TOTP secret, usually rendered as QR code for authenticator apps.
+func (c Config) ProvisioningURI(issuer, account, secret string) string {
+	return c.provisioningURI("totp", issuer, account, secret, nil)
+}
+
+// HOTPProvisioningURI returns the otpauth:// uri of a HOTP secret starting at counter.
+func (c Config) HOTPProvisioningURI(issuer, account, secret string, counter uint64) string {
+	return c.provisioningURI("hotp", issuer, account, secret, &counter)
+}
+
+func (c Config) provisioningURI(kind, issuer, account, secret string, counter *uint64) string {
+	c = c.init()
+	query := url.Values{}
+	query.Set("secret", secret)
+	if issuer != "" {
+		query.Set("issuer", issuer)
+		account = issuer + ":" + account
+	}
+	query.Set("algorithm", c.Algorithm)
+	query.Set("digits", strconv.Itoa(c.Digits))
+	if counter != nil {
+		query.Set("counter", strconv.FormatUint(*counter, 10))
+	} else {
+		query.Set("period", strconv.Itoa(int(c.Period/time.Second)))
+	}
+	u := url.URL{Scheme: "otpauth", Host: kind, Path: "/" + account, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// GenerateRecoveryCodes generates n one-time recovery codes like "k3m9x-p2q7w", only their hashes should be stored.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, n)
+	b := make([]byte, 10)
+	for i := range codes {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = alphabet[int(b[j])%len(alphabet)]
+		}
+		codes[i] = string(b[:5]) + "-" + string(b[5:])
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode returns the hex encoded sha256 of the normalized recovery code.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,67 @@
+package otputil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// secret of the RFC 4226 and RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTP(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	c := DefaultConfig()
+	for counter, code := range expected {
+		if v, _ := c.HOTP(rfcSecret, uint64(counter)); v != code {
+			t.Errorf("counter %d: %s != %s", counter, v, code)
+		}
+	}
+	if next, ok := c.ValidateHOTP(rfcSecret, "969429", 1, 3); !ok || next != 4 {
+		t.Errorf("look ahead validation failed: %d %v", next, ok)
+	}
+	if _, ok := c.ValidateHOTP(rfcSecret, "520489", 1, 3); ok {
+		t.Errorf("code beyond look ahead window is accepted")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	c := Config{Digits: 8}
+	cases := map[int64]string{59: "94287082", 1111111109: "07081804", 1234567890: "89005924", 20000000000: "65353130"}
+	for unix, code := range cases {
+		if v, _ := c.TOTP(rfcSecret, time.Unix(unix, 0)); v != code {
+			t.Errorf("time %d: %s != %s", unix, v, code)
+		}
+	}
+
+	c = DefaultConfig()
+	now := time.Now()
+	code, _ := c.TOTP(rfcSecret, now.Add(-30*time.Second))
+	if step, ok := c.ValidateTOTP(rfcSecret, code, now); !ok || step != c.Step(now)-1 {
+		t.Errorf("code of the previous step is rejected")
+	}
+	code, _ = c.TOTP(rfcSecret, now.Add(-90*time.Second))
+	if _, ok := c.ValidateTOTP(rfcSecret, code, now); ok {
+		t.Errorf("code beyond skew is accepted")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := DefaultConfig().ProvisioningURI("Example", "alice@example.com", rfcSecret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Example:alice@example.com?") || !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 || len(codes[0]) != 11 {
+		t.Errorf("unexpected codes: %v", codes)
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))) {
+		t.Errorf("recovery code is not normalized")
+	}
+}
@@ -0,0 +1,89 @@
+package authorize
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      64 * 1024,
+		Iterations:  1,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		HashArgon2id, argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(password, secret, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < h.Memory ||
+		params.Iterations < h.Iterations ||
+		params.Parallelism < h.Parallelism ||
+		uint32(len(key)) < h.KeyLength
+}
+
+func (h *Argon2idHasher) decode(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+	fields, err := splitHash(encoded, HashArgon2id, 4)
+	if err != nil {
+		return
+	}
+	var version int
+	if _, err = fmt.Sscanf(fields[0], "v=%d", &version); err != nil || version != argon2.Version {
+		err = ErrMalformedHash
+		return
+	}
+	if _, err = fmt.Sscanf(fields[1], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(fields[2]); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(fields[3]); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if len(salt) == 0 || len(key) == 0 || params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		err = ErrMalformedHash
+	}
+	return
+}
@@ -0,0 +1,70 @@
+package authorize
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy describes the passwords accepted when users choose one, existing passwords are not checked.
+type PasswordPolicy struct {
+	MinLength     int // minimum number of characters, default 8
+	MaxLength     int // maximum number of characters, default 128, it bounds the hashing cost
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	Forbidden     []string // rejected passwords, e.g. the most common ones, compared case-insensitively
+}
+
+// Validate returns an error matching ErrWeakPassword describing the first violated rule, passwords containing
+// the username are rejected as well.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	minLength, maxLength := p.MinLength, p.MaxLength
+	if minLength <= 0 {
+		minLength = 8
+	}
+	if maxLength <= 0 {
+		maxLength = 128
+	}
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		return fmt.Errorf("%w: at least %d characters required", ErrWeakPassword, minLength)
+	}
+	if length > maxLength {
+		return fmt.Errorf("%w: at most %d characters allowed", ErrWeakPassword, maxLength)
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return fmt.Errorf("%w: an upper case letter required", ErrWeakPassword)
+	case p.RequireLower && !lower:
+		return fmt.Errorf("%w: a lower case letter required", ErrWeakPassword)
+	case p.RequireDigit && !digit:
+		return fmt.Errorf("%w: a digit required", ErrWeakPassword)
+	case p.RequireSymbol && !symbol:
+		return fmt.Errorf("%w: a symbol required", ErrWeakPassword)
+	}
+	if len(username) >= 3 && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: must not contain the username", ErrWeakPassword)
+	}
+	for _, forbidden := range p.Forbidden {
+		if strings.EqualFold(password, forbidden) {
+			return fmt.Errorf("%w: too common", ErrWeakPassword)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package authorize
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+type ScryptHasher struct {
+	LogN       uint8 // CPU/memory cost is 2^LogN
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+func NewScryptHasher() *ScryptHasher {
+	return &ScryptHasher{
+		LogN:       15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	}
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, h.KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s",
+		HashScrypt, h.LogN, h.R, h.P,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *ScryptHasher) Verify(password, secret, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.R, params.P, len(key))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params.LogN < h.LogN ||
+		params.R < h.R ||
+		params.P < h.P ||
+		len(key) < h.KeyLength
+}
+
+func (h *ScryptHasher) decode(encoded string) (params ScryptHasher, salt, key []byte, err error) {
+	fields, err := splitHash(encoded, HashScrypt, 3)
+	if err != nil {
+		return
+	}
+	if _, err = fmt.Sscanf(fields[0], "ln=%d,r=%d,p=%d", &params.LogN, &params.R, &params.P); err != nil || params.LogN > 63 {
+		err = ErrMalformedHash
+		return
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(fields[1]); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(fields[2]); err != nil {
+		err = ErrMalformedHash
+		return
+	}
+	if len(salt) == 0 || len(key) == 0 || params.LogN == 0 || params.R <= 0 || params.P <= 0 {
+		err = ErrMalformedHash
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package authorize
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// PasswordHasher hashes passwords into PHC-style encoded strings, e.g. $argon2id$v=19$m=65536,t=1,p=4$salt$hash,
+// so the algorithm and its parameters travel with the stored hash.
+type PasswordHasher interface {
+	// Hash encodes the password with the hasher's current parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, secret is the per-user secret used by legacy hashes.
+	Verify(password, secret, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced by another algorithm or with weaker parameters.
+	NeedsRehash(encoded string) bool
+}
+
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "2b"
+	HashScrypt   = "scrypt"
+	HashMD5      = "" // legacy: the password is stored as is and clients submit hex encoded md5(password+secret)
+)
+
+var passwordHashersMu sync.RWMutex
+
+var passwordHashers = map[string]PasswordHasher{
+	HashArgon2id: NewArgon2idHasher(),
+	"2a":         NewBcryptHasher(),
+	HashBcrypt:   NewBcryptHasher(),
+	"2y":         NewBcryptHasher(),
+	HashScrypt:   NewScryptHasher(),
+	HashMD5:      &md5Hasher{},
+}
+
+// RegisterPasswordHasher registers a hasher for encoded hashes with the given PHC identifier.
+func RegisterPasswordHasher(id string, hasher PasswordHasher) {
+	passwordHashersMu.Lock()
+	defer passwordHashersMu.Unlock()
+	passwordHashers[id] = hasher
+}
+
+// LookupPasswordHasher returns the hasher able to verify encoded, or nil if the algorithm is unknown.
+func LookupPasswordHasher(encoded string) PasswordHasher {
+	passwordHashersMu.RLock()
+	defer passwordHashersMu.RUnlock()
+	return passwordHashers[hashIdentifier(encoded)]
+}
+
+// VerifyPassword verifies password against encoded using the algorithm recorded in encoded.
+func VerifyPassword(password, secret, encoded string) (bool, error) {
+	hasher := LookupPasswordHasher(encoded)
+	if hasher == nil {
+		return false, ErrUnsupportedHash
+	}
+	return hasher.Verify(password, secret, encoded)
+}
+
+func hashIdentifier(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return HashMD5
+	}
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	return id
+}
+
+// splitHash splits a PHC string $id$params$salt$hash into its fields, the leading id is dropped.
+func splitHash(encoded string, id string, n int) ([]string, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != n+2 || fields[0] != "" || fields[1] != id {
+		return nil, ErrMalformedHash
+	}
+	return fields[2:], nil
+}
+
+// md5Hasher verifies the legacy scheme of stored plain passwords, password is the md5 digest submitted by the client.
+type md5Hasher struct{}
+
+func (h *md5Hasher) Hash(password string) (string, error) {
+	return "", ErrUnsupportedHash
+}
+
+func (h *md5Hasher) Verify(password, secret, encoded string) (bool, error) {
+	if !isMD5Hex(password) {
+		return false, nil
+	}
+	b := md5.Sum([]byte(encoded + secret))
+	expected := hex.EncodeToString(b[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(password))) == 1, nil
+}
+
+func isMD5Hex(s string) bool {
+	if len(s) != hex.EncodedLen(md5.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func (h *md5Hasher) NeedsRehash(encoded string) bool {
+	return true
+}
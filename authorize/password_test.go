@@ -0,0 +1,92 @@
+package authorize
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPasswordHasher(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		HashArgon2id: NewArgon2idHasher(),
+		HashBcrypt:   &BcryptHasher{Cost: 4},
+		HashScrypt:   &ScryptHasher{LogN: 10, R: 8, P: 1, SaltLength: 16, KeyLength: 32},
+	}
+	for name, hasher := range hashers {
+		encoded, err := hasher.Hash("123456")
+		if err != nil {
+			t.Error(name, err)
+			continue
+		}
+		t.Log(encoded)
+		if ok, err := VerifyPassword("123456", "", encoded); err != nil || !ok {
+			t.Errorf("%s: verify failed: %v", name, err)
+		}
+		if ok, _ := VerifyPassword("654321", "", encoded); ok {
+			t.Errorf("%s: wrong password verified", name)
+		}
+		if hasher.NeedsRehash(encoded) {
+			t.Errorf("%s: fresh hash needs rehash", name)
+		}
+	}
+
+	if ok, _ := VerifyPassword("ea48576f30be1669971699c09ad05c94", "123456", "123456"); !ok {
+		t.Errorf("legacy md5 verify failed")
+	}
+	if ok, _ := VerifyPassword("123456", "123456", "123456"); ok {
+		t.Errorf("legacy md5 verified a password which is not a digest")
+	}
+	if _, err := VerifyPassword("123456", "", "$unknown$foo"); err != ErrUnsupportedHash {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPasswordHasher_Malformed(t *testing.T) {
+	for _, encoded := range []string{
+		"$scrypt$ln=15,r=8,p=1$c2FsdHNhbHQ$",
+		"$scrypt$ln=15,r=8,p=1$$c2FsdHNhbHQ",
+		"$scrypt$ln=0,r=8,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"$scrypt$ln=15,r=0,p=1$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"$scrypt$ln=15,r=8,p=0$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"$argon2id$v=19$m=65536,t=1,p=4$c2FsdHNhbHQ$",
+		"$argon2id$v=19$m=65536,t=1,p=4$$c2FsdHNhbHQ",
+		"$argon2id$v=19$m=0,t=1,p=4$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"$argon2id$v=19$m=65536,t=0,p=4$c2FsdHNhbHQ$c2FsdHNhbHQ",
+		"$argon2id$v=19$m=65536,t=1,p=0$c2FsdHNhbHQ$c2FsdHNhbHQ",
+	} {
+		if ok, err := VerifyPassword("anything", "", encoded); ok || err != ErrMalformedHash {
+			t.Errorf("%s: unexpected result %v %v", encoded, ok, err)
+		}
+	}
+}
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	weak, _ := (&ScryptHasher{LogN: 10, R: 8, P: 1, SaltLength: 16, KeyLength: 32}).Hash("123456")
+	if !NewScryptHasher().NeedsRehash(weak) {
+		t.Errorf("weak scrypt hash should be rehashed")
+	}
+	if !NewArgon2idHasher().NeedsRehash(weak) {
+		t.Errorf("scrypt hash should be rehashed to argon2id")
+	}
+	if !NewArgon2idHasher().NeedsRehash("ea48576f30be1669971699c09ad05c94") {
+		t.Errorf("legacy md5 hash should be rehashed")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &PasswordPolicy{RequireUpper: true, RequireDigit: true, Forbidden: []string{"Passw0rd"}}
+	cases := map[string]bool{
+		"Sh0rt":            false,
+		"nouppercase1":     false,
+		"NoDigitsHere":     false,
+		"passw0rD":         false,
+		"Alice-Rocks-2024": false,
+		"Corr3ct-Horse":    true,
+	}
+	for password, valid := range cases {
+		if err := policy.Validate("alice", password); (err == nil) != valid {
+			t.Errorf("%s: unexpected result %v", password, err)
+		} else if err != nil && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("%s: unexpected error %v", password, err)
+		}
+	}
+}
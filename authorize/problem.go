@@ -0,0 +1,190 @@
+package authorize
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+// Stable error codes of Problem, clients should rely on them instead of the human readable title.
+const (
+	CodeInvalidRequest         = "invalid_request"
+	CodeInvalidCredentials     = "invalid_credentials"
+	CodeTokenExpired           = "token_expired"
+	CodeTokenInvalid           = "token_invalid"
+	CodeTokenBindingMismatch   = "token_binding_mismatch"
+	CodeReauthenticate         = "reauthentication_required"
+	CodeLocked                 = "locked"
+	CodeUnsupportedContentType = "unsupported_content_type"
+	CodeMFARequired            = "mfa_required"
+	CodeInvalidMFACode         = "invalid_mfa_code"
+	CodeForbidden              = "forbidden"
+	CodeInvalidCSRFToken       = "csrf_invalid"
+	CodeWeakPassword           = "weak_password"
+	CodeServerError            = "server_error"
+)
+
+// Problem is an RFC 7807 problem details object with a machine-readable code, extension members are
+// serialized next to the standard members. The Header is written with the response, e.g. WWW-Authenticate.
+type Problem struct {
+	Type       string         `json:"type,omitempty"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Code       string         `json:"code"`
+	Detail     string         `json:"detail,omitempty"`
+	Extensions map[string]any `json:"-"`
+	Header     http.Header    `json:"-"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := map[string]any{}
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	m["title"] = p.Title
+	m["status"] = p.Status
+	m["code"] = p.Code
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	return json.Marshal(m)
+}
+
+func newProblem(status int, code, title string) *Problem {
+	return &Problem{Title: title, Status: status, Code: code, Header: http.Header{}}
+}
+
+// NewProblem maps errors of this package and tokenutil to a Problem, unknown errors become a server_error
+// without details.
+func NewProblem(err error) *Problem {
+	var problem *Problem
+	var locked *AccountLockedError
+	var mfaRequired *MFARequiredError
+	var invalid *RequestError
+	switch {
+	case errors.As(err, &problem):
+		if problem.Header == nil {
+			problem.Header = http.Header{}
+		}
+		return problem
+	case errors.As(err, &locked):
+		problem = newProblem(http.StatusTooManyRequests, CodeLocked, "too many failed attempts")
+		retryAfter := math.Ceil(time.Until(locked.RetryAfter).Seconds())
+		problem.Header.Set("Retry-After", strconv.Itoa(int(math.Max(retryAfter, 1))))
+	case errors.As(err, &mfaRequired):
+		problem = newProblem(http.StatusUnauthorized, CodeMFARequired, "mfa required")
+		problem.Extensions = map[string]any{"challenge": mfaRequired.Challenge}
+	case errors.Is(err, ErrInvalidUsername), errors.Is(err, ErrInvalidPassword):
+		problem = newProblem(http.StatusBadRequest, CodeInvalidCredentials, "invalid username or password")
+	case errors.Is(err, ErrInvalidChallenge), errors.Is(err, ErrInvalidMFACode):
+		problem = newProblem(http.StatusUnauthorized, CodeInvalidMFACode, err.Error())
+	case errors.Is(err, tokenutil.ErrTokenExpired):
+		problem = newProblem(http.StatusUnauthorized, CodeTokenExpired, "token expired")
+	case errors.Is(err, tokenutil.ErrTokenInvalid), errors.Is(err, tokenutil.ErrTokenReused), errors.Is(err, ErrInvalidAPIKey),
+		errors.Is(err, tokenutil.ErrTenantMismatch):
+		problem = newProblem(http.StatusUnauthorized, CodeTokenInvalid, "token invalid")
+	case errors.Is(err, tokenutil.ErrBindingMismatch):
+		problem = newProblem(http.StatusUnauthorized, CodeTokenBindingMismatch, "token binding mismatch")
+	case errors.Is(err, tokenutil.ErrReauthenticate):
+		problem = newProblem(http.StatusUnauthorized, CodeReauthenticate, "reauthentication required")
+	case errors.Is(err, tokenutil.ErrImpersonationDenied):
+		problem = newProblem(http.StatusForbidden, CodeForbidden, "impersonation denied")
+	case errors.Is(err, ErrUnsupportedContentType):
+		problem = newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedContentType, "unsupported content type")
+	case errors.Is(err, ErrInvalidCSRFToken):
+		problem = newProblem(http.StatusForbidden, CodeInvalidCSRFToken, "invalid csrf token")
+	case errors.Is(err, ErrWeakPassword):
+		problem = newProblem(http.StatusBadRequest, CodeWeakPassword, "weak password")
+		problem.Detail = strings.TrimPrefix(strings.TrimPrefix(err.Error(), ErrWeakPassword.Error()), ": ")
+	case errors.Is(err, ErrInvalidRequest):
+		problem = newProblem(http.StatusBadRequest, CodeInvalidRequest, "invalid request")
+		problem.Detail = strings.TrimPrefix(strings.TrimPrefix(err.Error(), ErrInvalidRequest.Error()), ": ")
+		if errors.As(err, &invalid) {
+			problem.Extensions = map[string]any{"errors": invalid.Errors}
+		}
+	default:
+		return newProblem(http.StatusInternalServerError, CodeServerError, "system error")
+	}
+	if problem.Status == http.StatusUnauthorized {
+		challenge := `Bearer realm="authorize"`
+		switch problem.Code {
+		case CodeTokenExpired, CodeTokenInvalid, CodeTokenBindingMismatch, CodeReauthenticate:
+			challenge += `, error="invalid_token", error_description="` + problem.Title + `"`
+		}
+		problem.Header.Set("WWW-Authenticate", challenge)
+	}
+	return problem
+}
+
+// ErrorRenderer writes the error response of the HTTP handlers and middlewares.
+type ErrorRenderer func(writer http.ResponseWriter, request *http.Request, err error)
+
+// DefaultErrorRenderer writes a problem+json response, or the plain text title if the client prefers text/plain.
+func DefaultErrorRenderer(writer http.ResponseWriter, request *http.Request, err error) {
+	problem := NewProblem(err)
+	for k, v := range problem.Header {
+		writer.Header()[k] = v
+	}
+	if prefersText(request.Header.Get("Accept")) {
+		http.Error(writer, problem.Title, problem.Status)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/problem+json")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(problem.Status)
+	json.NewEncoder(writer).Encode(problem)
+}
+
+// prefersText reports whether text/plain has a higher quality than json in the Accept header, json wins ties
+// unless it is only accepted by a wildcard.
+func prefersText(accept string) bool {
+	var text, js, wildcard float64 = -1, -1, -1
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch {
+		case mediaType == "text/plain":
+			text = math.Max(text, q)
+		case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+			js = math.Max(js, q)
+		case mediaType == "*/*":
+			wildcard = math.Max(wildcard, q)
+		}
+	}
+	if js < 0 {
+		return text > 0 && text >= wildcard
+	}
+	return text > js
+}
+
+// WithErrorRenderer set the renderer of error responses, default is DefaultErrorRenderer.
+func WithErrorRenderer(renderer ErrorRenderer) Option {
+	return func(a *Authorization) {
+		a.errorRenderer = renderer
+	}
+}
@@ -0,0 +1,89 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestDefaultErrorRenderer(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456"})
+	auth := New(rep, tokenutil.NewManager(tokenutil.WithMaxAge(50*time.Millisecond)))
+	pair, err := auth.AuthorizeTokenPair(context.Background(), &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	handler := auth.HTTPMiddleware()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {}))
+	serve := func(handler http.Handler, request *http.Request) (*httptest.ResponseRecorder, *Problem) {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		var problem Problem
+		if response.Header().Get("Content-Type") == "application/problem+json" {
+			if err := json.NewDecoder(response.Body).Decode(&problem); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response, &problem
+	}
+
+	for token, code := range map[string]string{pair.AccessToken: CodeTokenExpired, "invalid": CodeTokenInvalid, "a!.b.c": CodeTokenInvalid} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, problem := serve(handler, request)
+		if response.Code != http.StatusUnauthorized || problem.Code != code || problem.Status != http.StatusUnauthorized {
+			t.Errorf("unexpected problem: %d %+v", response.Code, problem)
+		}
+		if challenge := response.Header().Get("WWW-Authenticate"); !strings.HasPrefix(challenge, "Bearer ") ||
+			!strings.Contains(challenge, `error="invalid_token"`) {
+			t.Errorf("unexpected WWW-Authenticate: %s", challenge)
+		}
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Accept", "text/plain, */*;q=0.8")
+	if response, _ := serve(handler, request); !strings.HasPrefix(response.Header().Get("Content-Type"), "text/plain") ||
+		strings.TrimSpace(response.Body.String()) != "token invalid" {
+		t.Errorf("unexpected text response: %s %s", response.Header().Get("Content-Type"), response.Body.String())
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=test"))
+	request.Header.Set("Content-Type", "text/xml")
+	if response, problem := serve(auth.HTTPHandler(), request); response.Code != http.StatusUnsupportedMediaType ||
+		problem.Code != CodeUnsupportedContentType {
+		t.Errorf("unexpected problem: %d %+v", response.Code, problem)
+	}
+
+	request = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("username=test&password=wrong"))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if response, problem := serve(auth.HTTPHandler(), request); response.Code != http.StatusBadRequest ||
+		problem.Code != CodeInvalidCredentials {
+		t.Errorf("unexpected problem: %d %+v", response.Code, problem)
+	}
+}
+
+func TestPrefersText(t *testing.T) {
+	cases := map[string]bool{
+		"":                                   false,
+		"*/*":                                false,
+		"text/plain":                         true,
+		"text/plain, */*":                    true,
+		"application/json, text/plain":       false,
+		"application/json;q=0.5, text/plain": true,
+		"text/plain;q=0, */*":                false,
+		"application/problem+json":           false,
+	}
+	for accept, expected := range cases {
+		if prefersText(accept) != expected {
+			t.Errorf("%q: expected %v", accept, expected)
+		}
+	}
+}
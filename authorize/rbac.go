@@ -0,0 +1,252 @@
+package authorize
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+const (
+	ClaimRoles       = "roles"
+	ClaimPermissions = "permissions"
+)
+
+// RoleUser is an optional User extension, the roles are embedded in the claims of issued tokens.
+type RoleUser interface {
+	GetRoles() []string
+}
+
+// PermissionUser is an optional User extension, the permissions are embedded in the claims of issued tokens.
+type PermissionUser interface {
+	GetPermissions() []string
+}
+
+// RoleRepository is an optional UserRepository extension loading roles and permissions by user id,
+// it is used when tokens are issued and by RBAC to resolve them on every request.
+type RoleRepository interface {
+	GetRoles(ctx context.Context, userId string) ([]string, error)
+	GetPermissions(ctx context.Context, userId string) ([]string, error)
+}
+
+// Policy decides whether the authenticated request context holds a permission or a role,
+// the context carries tokenutil.Claims and the tokenutil.Session set by the HTTP middleware.
+type Policy interface {
+	HasPermission(ctx context.Context, permission string) (bool, error)
+	HasRole(ctx context.Context, role string) (bool, error)
+}
+
+// MatchPermission reports whether the granted permission pattern matches permission. Permissions are colon
+// separated segments, "*" matches any single segment and a trailing "*" matches all remaining segments,
+// e.g. "orders:*:read" matches "orders:42:read" and "orders:*" matches "orders:42:write".
+func MatchPermission(pattern, permission string) bool {
+	patterns := strings.Split(pattern, ":")
+	segments := strings.Split(permission, ":")
+	for i, p := range patterns {
+		if i >= len(segments) {
+			return false
+		}
+		if p == "*" {
+			if i == len(patterns)-1 {
+				return true
+			}
+			continue
+		}
+		if p != segments[i] {
+			return false
+		}
+	}
+	return len(patterns) == len(segments)
+}
+
+// RBAC is the default Policy, roles and permissions are read from the claims and optionally from a
+// RoleRepository, role permissions are granted by Grant. Scoped tokens, i.e. OAuth2 client tokens and API keys
+// carrying the scope claim, hold no roles and only the permissions matched by one of their scopes, e.g. a token
+// with scope "orders:read" is not granted "orders:write" even if its user is.
+type RBAC struct {
+	grants     map[string][]string
+	repository RoleRepository
+}
+
+var _ Policy = (*RBAC)(nil)
+
+func NewRBAC() *RBAC {
+	return &RBAC{grants: make(map[string][]string)}
+}
+
+// Grant grants permission patterns to the role.
+func (r *RBAC) Grant(role string, permissions ...string) *RBAC {
+	r.grants[role] = append(r.grants[role], permissions...)
+	return r
+}
+
+// WithRepository resolve roles and permissions from the repository in addition to the claims.
+func (r *RBAC) WithRepository(repository RoleRepository) *RBAC {
+	r.repository = repository
+	return r
+}
+
+func (r *RBAC) HasRole(ctx context.Context, role string) (bool, error) {
+	if _, scoped := scopes(ctx); scoped {
+		return false, nil
+	}
+	roles, err := r.roles(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range roles {
+		if v == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *RBAC) HasPermission(ctx context.Context, permission string) (bool, error) {
+	if scopes, scoped := scopes(ctx); scoped && !matchAny(scopes, permission) {
+		return false, nil
+	}
+	patterns, err := r.permissions(ctx)
+	if err != nil {
+		return false, err
+	}
+	roles, err := r.roles(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		patterns = append(patterns, r.grants[role]...)
+	}
+	return matchAny(patterns, permission), nil
+}
+
+// permissions returns the permissions of the claims and the repository, without the grants of roles.
+func (r *RBAC) permissions(ctx context.Context) ([]string, error) {
+	patterns := splitList(tokenutil.FromContext(ctx).Get(ClaimPermissions))
+	if session := tokenutil.SessionFromContext(ctx); r.repository != nil && session != nil {
+		permissions, err := r.repository.GetPermissions(ctx, session.UserId)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, permissions...)
+	}
+	return patterns, nil
+}
+
+func matchAny(patterns []string, permission string) bool {
+	for _, pattern := range patterns {
+		if MatchPermission(pattern, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// scopes returns the scopes of a scoped token, false if the claims carry no scope claim.
+func scopes(ctx context.Context) ([]string, bool) {
+	scope, ok := tokenutil.FromContext(ctx)[ClaimScope]
+	return strings.Fields(scope), ok
+}
+
+func (r *RBAC) roles(ctx context.Context) ([]string, error) {
+	roles := splitList(tokenutil.FromContext(ctx).Get(ClaimRoles))
+	if session := tokenutil.SessionFromContext(ctx); r.repository != nil && session != nil {
+		v, err := r.repository.GetRoles(ctx, session.UserId)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, v...)
+	}
+	return roles, nil
+}
+
+// RequirePermission returns a middleware rejecting requests without the permission with 403,
+// the token is validated first unless an outer HTTPMiddleware did already.
+func (a *Authorization) RequirePermission(permission string) func(next http.Handler) http.Handler {
+	return a.require(func(ctx context.Context) (bool, error) {
+		return a.policy.HasPermission(ctx, permission)
+	}, map[string]any{"permission": permission})
+}
+
+// RequireRole returns a middleware rejecting requests without the role with 403,
+// the token is validated first unless an outer HTTPMiddleware did already.
+func (a *Authorization) RequireRole(role string) func(next http.Handler) http.Handler {
+	return a.require(func(ctx context.Context) (bool, error) {
+		return a.policy.HasRole(ctx, role)
+	}, map[string]any{"role": role})
+}
+
+func (a *Authorization) require(check func(ctx context.Context) (bool, error), detail map[string]any) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		guarded := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			granted, err := check(request.Context())
+			if err != nil {
+				a.errorRenderer(writer, request, err)
+				return
+			}
+			if !granted {
+				problem := newProblem(http.StatusForbidden, CodeForbidden, "insufficient privileges")
+				problem.Extensions = detail
+				a.errorRenderer(writer, request, problem)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if tokenutil.SessionFromContext(request.Context()) != nil {
+				guarded.ServeHTTP(writer, request)
+			} else {
+				a.httpMiddleware(guarded).ServeHTTP(writer, request)
+			}
+		})
+	}
+}
+
+// accessClaims returns the claims embedded into the tokens of the user, roles and permissions of the user
+// are added to its own claims.
+func (a *Authorization) accessClaims(ctx context.Context, user User) (tokenutil.Claims, error) {
+	return userClaims(ctx, a.rep, user)
+}
+
+func userClaims(ctx context.Context, rep UserRepository, user User) (tokenutil.Claims, error) {
+	var roles, permissions []string
+	if u, ok := user.(RoleUser); ok {
+		roles = append(roles, u.GetRoles()...)
+	}
+	if u, ok := user.(PermissionUser); ok {
+		permissions = append(permissions, u.GetPermissions()...)
+	}
+	if rep, ok := rep.(RoleRepository); ok {
+		v, err := rep.GetRoles(ctx, user.GetID())
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, v...)
+		if v, err = rep.GetPermissions(ctx, user.GetID()); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, v...)
+	}
+	claims := tokenutil.Claims{}
+	for k, v := range user.GetClaims() {
+		claims[k] = v
+	}
+	if len(roles) > 0 {
+		claims[ClaimRoles] = strings.Join(roles, ",")
+	}
+	if len(permissions) > 0 {
+		claims[ClaimPermissions] = strings.Join(permissions, ",")
+	}
+	return claims, nil
+}
+
+func splitList(text string) []string {
+	var list []string
+	for _, v := range strings.Split(text, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,112 @@
+package authorize
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestMatchPermission(t *testing.T) {
+	cases := []struct {
+		pattern, permission string
+		expected            bool
+	}{
+		{"orders:write", "orders:write", true},
+		{"orders:write", "orders:read", false},
+		{"orders:*:read", "orders:42:read", true},
+		{"orders:*:read", "orders:42:write", false},
+		{"orders:*:read", "orders:read", false},
+		{"orders:*", "orders:42:write", true},
+		{"orders:*", "orders", false},
+		{"*", "users:delete", true},
+		{"orders", "orders:write", false},
+	}
+	for _, c := range cases {
+		if MatchPermission(c.pattern, c.permission) != c.expected {
+			t.Errorf("MatchPermission(%q, %q) != %v", c.pattern, c.permission, c.expected)
+		}
+	}
+}
+
+func TestAuthorization_RequirePermission(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "admin", Roles: []string{"admin"}})
+	rep.Add(&SimpleUser{ID: "2", Username: "clerk", Permissions: []string{"orders:*:read"}})
+	auth := New(rep, tokenutil.NewManager(),
+		WithPasswordVerifier(func(pwd, secret, input string) bool { return true }),
+		WithPolicy(NewRBAC().Grant("admin", "orders:*")),
+	)
+	ok := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {})
+
+	cases := []struct {
+		username string
+		handler  http.Handler
+		expected int
+	}{
+		{"admin", auth.RequirePermission("orders:write")(ok), http.StatusOK},
+		{"admin", auth.RequireRole("admin")(ok), http.StatusOK},
+		{"clerk", auth.RequirePermission("orders:42:read")(ok), http.StatusOK},
+		{"clerk", auth.RequirePermission("orders:write")(ok), http.StatusForbidden},
+		{"clerk", auth.HTTPMiddleware()(auth.RequireRole("admin")(ok)), http.StatusForbidden},
+	}
+	for _, c := range cases {
+		token, err := auth.Authorize(context.Background(), &formRequest{username: c.username, clientID: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		c.handler.ServeHTTP(response, request)
+		if response.Code != c.expected {
+			t.Errorf("%s: unexpected status %d %s", c.username, response.Code, response.Body.String())
+		}
+	}
+
+	response := httptest.NewRecorder()
+	auth.RequireRole("admin")(ok).ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/api/v1/orders", nil))
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status %d", response.Code)
+	}
+}
+
+type staticRoleRepository struct {
+	roles, permissions []string
+}
+
+func (r *staticRoleRepository) GetRoles(ctx context.Context, userId string) ([]string, error) {
+	return r.roles, nil
+}
+
+func (r *staticRoleRepository) GetPermissions(ctx context.Context, userId string) ([]string, error) {
+	return r.permissions, nil
+}
+
+func TestRBAC_ScopedToken(t *testing.T) {
+	rbac := NewRBAC().Grant("admin", "orders:*").WithRepository(&staticRoleRepository{permissions: []string{"users:*"}})
+	session := &tokenutil.Session{UserId: "1", ClientId: "third-party"}
+	ctx := session.WithContext(tokenutil.Claims{ClaimRoles: "admin", ClaimScope: "orders:read users:read"}.WithContext(context.Background()))
+
+	cases := map[string]bool{
+		"orders:read":  true,
+		"orders:write": false,
+		"users:read":   true,
+		"users:delete": false,
+	}
+	for permission, expected := range cases {
+		if granted, err := rbac.HasPermission(ctx, permission); err != nil || granted != expected {
+			t.Errorf("%s: unexpected result %v %v", permission, granted, err)
+		}
+	}
+	if granted, _ := rbac.HasRole(ctx, "admin"); granted {
+		t.Error("scoped token holds a role")
+	}
+
+	ctx = tokenutil.Claims{ClaimPermissions: "*", ClaimScope: ""}.WithContext(context.Background())
+	if granted, _ := rbac.HasPermission(ctx, "orders:read"); granted {
+		t.Error("token without scopes holds a permission")
+	}
+}
@@ -0,0 +1,251 @@
+package authorize
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Names of the login fields backing the Request getters.
+const (
+	FieldUsername = "username"
+	FieldPassword = "password"
+	FieldClientID = "client_id"
+)
+
+// AttributeRequest is an optional Request extension carrying additional login fields, e.g. a captcha response
+// or a tenant. Credentials receive the whole request, the claims are embedded into the issued tokens.
+type AttributeRequest interface {
+	GetAttributes() map[string]string
+	// GetClaims returns the attributes to embed as claims, they never replace claims of the user.
+	GetClaims() map[string]string
+}
+
+// LoginField declares a field of login requests and its validation rules.
+type LoginField struct {
+	Name      string   // name of the attribute, FieldUsername, FieldPassword and FieldClientID back the Request getters
+	Aliases   []string // other accepted field names, e.g. "email" for the username
+	Header    string   // header read if the field is missing in the body, e.g. X-Client-ID
+	Required  bool
+	MinLength int
+	MaxLength int
+	Pattern   string // regular expression the whole value has to match
+	Claim     string // claim the value is embedded as, not embedded if empty
+
+	pattern *regexp.Regexp
+}
+
+// DefaultLoginFields are the fields of the default request parser.
+var DefaultLoginFields = []LoginField{
+	{Name: FieldUsername, Required: true, MaxLength: 256},
+	{Name: FieldPassword, Required: true, MaxLength: 1024},
+	{Name: FieldClientID, Header: "X-Client-ID", MaxLength: 128},
+}
+
+// FieldError describes why a field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// RequestError is returned for invalid requests, it matches ErrInvalidRequest with errors.Is and is rendered
+// as a problem with an "errors" member listing the fields.
+type RequestError struct {
+	Errors []FieldError
+}
+
+func (e *RequestError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, v := range e.Errors {
+		messages = append(messages, v.Field+" "+v.Message)
+	}
+	return ErrInvalidRequest.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e *RequestError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// RequestParser parses json, form and multipart login requests into a Request with the declared fields.
+// Fields are read from the body only, credentials in the query string are ignored.
+type RequestParser struct {
+	fields      []LoginField
+	maxBodySize int64
+}
+
+type RequestParserOption func(p *RequestParser)
+
+// WithMaxBodySize set the maximum size of request bodies, default 1MB.
+func WithMaxBodySize(size int64) RequestParserOption {
+	return func(p *RequestParser) {
+		p.maxBodySize = size
+	}
+}
+
+// WithLoginFields add fields to the parsed fields, a field with the name of a declared one replaces it.
+func WithLoginFields(fields ...LoginField) RequestParserOption {
+	return func(p *RequestParser) {
+	next:
+		for _, field := range fields {
+			for i := range p.fields {
+				if p.fields[i].Name == field.Name {
+					p.fields[i] = field
+					continue next
+				}
+			}
+			p.fields = append(p.fields, field)
+		}
+	}
+}
+
+// NewRequestParser returns a parser of the DefaultLoginFields and the fields of the options,
+// it panics if a field pattern does not compile.
+func NewRequestParser(options ...RequestParserOption) *RequestParser {
+	p := &RequestParser{
+		fields:      append([]LoginField{}, DefaultLoginFields...),
+		maxBodySize: 1 << 20,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	for i := range p.fields {
+		if p.fields[i].Pattern != "" {
+			p.fields[i].pattern = regexp.MustCompile("^(?:" + p.fields[i].Pattern + ")$")
+		}
+	}
+	return p
+}
+
+// Parse is a RequestBuilder, see WithRequestBuilder. Invalid fields are reported as *RequestError.
+func (p *RequestParser) Parse(request *http.Request) (Request, error) {
+	body, err := readBody(request, p.maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	req := &formRequest{clientIP: clientIP(request), attributes: map[string]string{}}
+	var errs []FieldError
+	for _, field := range p.fields {
+		value, err := field.value(request, body)
+		if err != nil {
+			errs = append(errs, FieldError{Field: field.Name, Message: err.Error()})
+			continue
+		}
+		if err = field.validate(value); err != nil {
+			errs = append(errs, FieldError{Field: field.Name, Message: err.Error()})
+			continue
+		}
+		switch field.Name {
+		case FieldUsername:
+			req.username = value
+		case FieldPassword:
+			req.password = value
+		case FieldClientID:
+			req.clientID = value
+		default:
+			req.attributes[field.Name] = value
+		}
+		if field.Claim != "" && value != "" {
+			if req.claims == nil {
+				req.claims = map[string]string{}
+			}
+			req.claims[field.Claim] = value
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &RequestError{Errors: errs}
+	}
+	return req, nil
+}
+
+func (f *LoginField) value(request *http.Request, body map[string]any) (string, error) {
+	for _, name := range append([]string{f.Name}, f.Aliases...) {
+		v, ok := body[name]
+		if !ok || v == nil {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		default:
+			return "", errors.New("must be a string")
+		}
+	}
+	if f.Header != "" {
+		return request.Header.Get(f.Header), nil
+	}
+	return "", nil
+}
+
+func (f *LoginField) validate(value string) error {
+	if value == "" {
+		if f.Required {
+			return errors.New("is required")
+		}
+		return nil
+	}
+	length := utf8.RuneCountInString(value)
+	if f.MinLength > 0 && length < f.MinLength {
+		return fmt.Errorf("must be at least %d characters", f.MinLength)
+	}
+	if f.MaxLength > 0 && length > f.MaxLength {
+		return fmt.Errorf("must be at most %d characters", f.MaxLength)
+	}
+	if f.pattern != nil && !f.pattern.MatchString(value) {
+		return errors.New("has an invalid format")
+	}
+	return nil
+}
+
+// readBody decodes a json, form or multipart body, json values are kept as decoded with json.Number,
+// form fields as their first value. Parameters of the media type like charset are ignored.
+func readBody(request *http.Request, maxBodySize int64) (map[string]any, error) {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrUnsupportedContentType
+	}
+	if request.Body != nil && maxBodySize > 0 {
+		request.Body = http.MaxBytesReader(nil, request.Body, maxBodySize)
+	}
+	body := map[string]any{}
+	switch {
+	case mediaType == "application/json", strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"):
+		decoder := json.NewDecoder(request.Body)
+		decoder.UseNumber()
+		if err = decoder.Decode(&body); err != nil {
+			if err == io.EOF {
+				err = errors.New("empty body")
+			}
+			return nil, &RequestError{Errors: []FieldError{{Field: "body", Message: err.Error()}}}
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if err = request.ParseForm(); err != nil {
+			return nil, &RequestError{Errors: []FieldError{{Field: "body", Message: err.Error()}}}
+		}
+		for k, v := range request.PostForm {
+			body[k] = v[0]
+		}
+	case mediaType == "multipart/form-data":
+		if err = request.ParseMultipartForm(maxBodySize); err != nil {
+			return nil, &RequestError{Errors: []FieldError{{Field: "body", Message: err.Error()}}}
+		}
+		for k, v := range request.MultipartForm.Value {
+			if len(v) > 0 {
+				body[k] = v[0]
+			}
+		}
+	default:
+		return nil, ErrUnsupportedContentType
+	}
+	return body, nil
+}
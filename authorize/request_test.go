@@ -0,0 +1,128 @@
+package authorize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+func TestRequestParser(t *testing.T) {
+	parser := NewRequestParser(WithLoginFields(
+		LoginField{Name: FieldUsername, Aliases: []string{"email"}, Required: true, Pattern: `[a-z0-9@.]+`},
+		LoginField{Name: "captcha", Required: true, MinLength: 4},
+		LoginField{Name: "device", Header: "X-Device", Claim: "dev"},
+	))
+	parse := func(contentType string, body string) (Request, error) {
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		request.Header.Set("X-Client-ID", "web")
+		request.Header.Set("X-Device", "phone")
+		return parser.Parse(request)
+	}
+
+	req, err := parse("application/json; charset=utf-8", `{"email":"test","password":"123456","captcha":"abcd"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributes := req.(AttributeRequest)
+	if req.GetUsername() != "test" || req.GetPassword() != "123456" || req.GetClientID() != "web" ||
+		attributes.GetAttributes()["captcha"] != "abcd" || attributes.GetClaims()["dev"] != "phone" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if req, err = parse("application/x-www-form-urlencoded", "username=test&password=123456&captcha=abcd&client_id=app"); err != nil ||
+		req.GetClientID() != "app" {
+		t.Errorf("unexpected request: %+v %v", req, err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("username", "test")
+	writer.WriteField("password", "123456")
+	writer.WriteField("captcha", "abcd")
+	writer.Close()
+	if req, err = parse(writer.FormDataContentType(), body.String()); err != nil || req.GetUsername() != "test" {
+		t.Errorf("unexpected request: %+v %v", req, err)
+	}
+
+	cases := map[string][]string{
+		`{"username":`: {"body"},
+		`{"username":{"foo":"bar"},"password":"123456","captcha":"abcd"}`: {FieldUsername},
+		`{"username":"Test!","captcha":"abc"}`:                            {FieldUsername, FieldPassword, "captcha"},
+	}
+	for text, fields := range cases {
+		_, err = parse("application/json", text)
+		var invalid *RequestError
+		if !errors.Is(err, ErrInvalidRequest) || !errors.As(err, &invalid) || len(invalid.Errors) != len(fields) {
+			t.Errorf("%s: unexpected error %v", text, err)
+			continue
+		}
+		for i, field := range fields {
+			if invalid.Errors[i].Field != field {
+				t.Errorf("%s: unexpected field errors %v", text, invalid.Errors)
+			}
+		}
+	}
+	if _, err = parse("text/plain", "username=test"); err != ErrUnsupportedContentType {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAuthorization_RequestAttributes(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Username: "test", Secret: "123456", Password: "123456", Claims: map[string]string{"foo": "bar"}})
+	m := tokenutil.NewManager()
+	var captcha string
+	auth := New(rep, m,
+		WithRequestBuilder(NewRequestParser(WithLoginFields(
+			LoginField{Name: "captcha"},
+			LoginField{Name: "device", Claim: "dev"},
+			LoginField{Name: "foo", Claim: "foo"},
+		)).Parse),
+		WithCredentials(CredentialsFunc(func(ctx context.Context, request Request) (User, error) {
+			captcha = request.(AttributeRequest).GetAttributes()["captcha"]
+			return rep.GetByUsername(ctx, request.GetUsername())
+		})),
+	)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json; charset=utf-8")
+		response := httptest.NewRecorder()
+		auth.HTTPHandler().ServeHTTP(response, request)
+		return response
+	}
+	response := post(`{"username":"test","password":"ea48576f30be1669971699c09ad05c94","client_id":"web","captcha":"abcd","device":"phone","foo":"baz"}`)
+	if response.Code != http.StatusOK {
+		t.Fatalf("login failed: %d %s", response.Code, response.Body.String())
+	}
+	if captcha != "abcd" {
+		t.Errorf("attribute not passed to credentials: %q", captcha)
+	}
+	var pair map[string]any
+	json.NewDecoder(response.Body).Decode(&pair)
+	claims, err := m.ValidateToken(context.Background(), pair["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Get("dev") != "phone" || claims.Get("foo") != "bar" {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+
+	response = post(`{"username":"test"}`)
+	var problem map[string]any
+	json.NewDecoder(response.Body).Decode(&problem)
+	if response.Code != http.StatusBadRequest || problem["code"] != CodeInvalidRequest || problem["detail"] != "password is required" {
+		t.Errorf("unexpected problem: %d %v", response.Code, problem)
+	}
+	if errs, _ := problem["errors"].([]any); len(errs) != 1 {
+		t.Errorf("unexpected field errors: %v", problem["errors"])
+	}
+}
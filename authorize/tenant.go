@@ -0,0 +1,122 @@
+package authorize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+// ErrTenantsNotSupported is returned for logins of a tenant if the UserRepository is not a TenantUserRepository.
+var ErrTenantsNotSupported = errors.New("user repository does not support tenants")
+
+// TenantUserRepository is an optional UserRepository extension looking up users of a tenant, usernames and user
+// ids only have to be unique per tenant.
+type TenantUserRepository interface {
+	GetByTenantUsername(ctx context.Context, tenant, username string) (User, error)
+}
+
+// TenantConfig enables multi-tenancy. The tenant of a request is taken from the header or the subdomain, login
+// requests may carry it in a login field instead. Tokens are issued and stored per tenant, see tokenutil.WithTenant.
+type TenantConfig struct {
+	Header   string // header carrying the tenant, e.g. "X-Tenant-ID"
+	Domain   string // base domain, the subdomain left of it is the tenant, e.g. "example.com" for acme.example.com
+	Field    string // login field carrying the tenant, see LoginField
+	Required bool   // reject logins without tenant
+	Restrict bool   // the HTTP middleware rejects tokens of another tenant than the one of the request
+}
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// WithTenants enable multi-tenancy, see TenantConfig.
+func WithTenants(config TenantConfig) Option {
+	return func(a *Authorization) {
+		config.Domain = strings.ToLower(strings.TrimPrefix(config.Domain, "."))
+		a.tenants = &config
+	}
+}
+
+// requestTenant returns the tenant of the header or the subdomain.
+func (a *Authorization) requestTenant(request *http.Request) string {
+	if a.tenants.Header != "" {
+		if tenant := request.Header.Get(a.tenants.Header); tenant != "" {
+			return tenant
+		}
+	}
+	if a.tenants.Domain != "" {
+		host, _, err := net.SplitHostPort(request.Host)
+		if err != nil {
+			host = request.Host
+		}
+		if sub, ok := strings.CutSuffix(strings.ToLower(host), "."+a.tenants.Domain); ok && !strings.Contains(sub, ".") {
+			return sub
+		}
+	}
+	return ""
+}
+
+// withLoginTenant scopes ctx to the tenant of the login, a tenant of the login field has to match the one of ctx.
+func (a *Authorization) withLoginTenant(ctx context.Context, request Request) (context.Context, error) {
+	if a.tenants == nil {
+		return ctx, nil
+	}
+	tenant := tokenutil.TenantFromContext(ctx)
+	if r, ok := request.(AttributeRequest); ok && a.tenants.Field != "" {
+		if field := r.GetAttributes()[a.tenants.Field]; field != "" {
+			if tenant != "" && field != tenant {
+				return nil, &RequestError{Errors: []FieldError{{Field: a.tenants.Field, Message: "does not match the tenant of the request"}}}
+			}
+			tenant = field
+		}
+	}
+	field := a.tenants.Field
+	if field == "" {
+		field = "tenant"
+	}
+	if tenant == "" && a.tenants.Required {
+		return nil, &RequestError{Errors: []FieldError{{Field: field, Message: "is required"}}}
+	}
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		return nil, &RequestError{Errors: []FieldError{{Field: field, Message: "has an invalid format"}}}
+	}
+	return tokenutil.WithTenant(ctx, tenant), nil
+}
+
+// withRequestTenant scopes the context of the request to its tenant.
+func (a *Authorization) withRequestTenant(request *http.Request) *http.Request {
+	if a.tenants == nil {
+		return request
+	}
+	if tenant := a.requestTenant(request); tenant != "" {
+		return request.WithContext(tokenutil.WithTenant(request.Context(), tenant))
+	}
+	return request
+}
+
+// checkTenant rejects sessions of another tenant than the request if the middleware restricts tenants.
+func (a *Authorization) checkTenant(request *http.Request, session *tokenutil.Session) error {
+	if a.tenants == nil || !a.tenants.Restrict {
+		return nil
+	}
+	if session.TenantId != a.requestTenant(request) {
+		return tokenutil.ErrTenantMismatch
+	}
+	return nil
+}
+
+// getUser looks the user up in the tenant of ctx.
+func getUser(ctx context.Context, rep UserRepository, username string) (User, error) {
+	tenant := tokenutil.TenantFromContext(ctx)
+	if tenant == "" {
+		return rep.GetByUsername(ctx, username)
+	}
+	if r, ok := rep.(TenantUserRepository); ok {
+		return r.GetByTenantUsername(ctx, tenant, username)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrTenantsNotSupported, rep)
+}
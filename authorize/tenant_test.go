@@ -0,0 +1,95 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+)
+
+type globalUserRepository struct {
+	UserRepository
+}
+
+func TestAuthorization_Tenants(t *testing.T) {
+	rep := NewSimpleUserRepository()
+	rep.Add(&SimpleUser{ID: "1", Tenant: "acme", Username: "test", Secret: "123456", Password: "123456"})
+	rep.Add(&SimpleUser{ID: "1", Tenant: "globex", Username: "test", Secret: "654321", Password: "654321", Claims: map[string]string{"foo": "bar"}})
+	config := TenantConfig{Header: "X-Tenant-ID", Domain: "example.com", Field: "tenant", Required: true, Restrict: true}
+	auth := New(rep, tokenutil.NewManager(),
+		WithTenants(config),
+		WithRequestBuilder(NewRequestParser(WithLoginFields(LoginField{Name: "tenant"})).Parse),
+	)
+
+	login := func(host, header, body string) (*httptest.ResponseRecorder, map[string]any) {
+		request := httptest.NewRequest(http.MethodPost, "http://"+host+"/login", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if header != "" {
+			request.Header.Set("X-Tenant-ID", header)
+		}
+		response := httptest.NewRecorder()
+		auth.HTTPHandler().ServeHTTP(response, request)
+		var v map[string]any
+		json.NewDecoder(response.Body).Decode(&v)
+		return response, v
+	}
+	acme := `{"username":"test","password":"ea48576f30be1669971699c09ad05c94","client_id":"web"}`
+	globex := `{"username":"test","password":"aeb8f189a441f4511962055e3c30cd2c","client_id":"web"}`
+
+	response, body := login("api.example.org", "acme", acme)
+	if response.Code != http.StatusOK {
+		t.Fatalf("header login failed: %d %v", response.Code, body)
+	}
+	acmeToken := body["access_token"].(string)
+	if response, body = login("globex.example.com:8080", "", globex); response.Code != http.StatusOK {
+		t.Fatalf("subdomain login failed: %d %v", response.Code, body)
+	}
+	if response, body = login("api.example.org", "", `{"username":"test","password":"aeb8f189a441f4511962055e3c30cd2c","tenant":"globex","client_id":"web"}`); response.Code != http.StatusOK {
+		t.Fatalf("field login failed: %d %v", response.Code, body)
+	}
+	// the login replaced the session of the subdomain login
+	globexToken := body["access_token"].(string)
+
+	cases := map[string][3]string{
+		"other tenant password": {"api.example.org", "acme", globex},
+		"missing tenant":        {"api.example.org", "", acme},
+		"mismatching field":     {"api.example.org", "acme", `{"username":"test","password":"ea48576f30be1669971699c09ad05c94","tenant":"globex"}`},
+		"invalid tenant":        {"api.example.org", "../acme", acme},
+	}
+	for name, c := range cases {
+		if response, body = login(c[0], c[1], c[2]); response.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected response %d %v", name, response.Code, body)
+		}
+	}
+
+	var tenant string
+	var claims tokenutil.Claims
+	handler := auth.HTTPMiddleware()(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		tenant = tokenutil.TenantFromContext(request.Context())
+		claims = tokenutil.FromContext(request.Context())
+	}))
+	serve := func(header, token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-Tenant-ID", header)
+		request.Header.Set("Authorization", "Bearer "+token)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response.Code
+	}
+	if code := serve("globex", globexToken); code != http.StatusOK || tenant != "globex" || claims.Get("foo") != "bar" {
+		t.Errorf("unexpected response: %d %s %v", code, tenant, claims)
+	}
+	if code := serve("globex", acmeToken); code != http.StatusUnauthorized {
+		t.Errorf("token of another tenant accepted: %d", code)
+	}
+
+	auth = New(globalUserRepository{rep}, tokenutil.NewManager(), WithTenants(config))
+	if _, err := auth.Authorize(tokenutil.WithTenant(context.Background(), "acme"), &formRequest{username: "test", password: "ea48576f30be1669971699c09ad05c94", clientID: "web"}); err == nil ||
+		NewProblem(err).Status != http.StatusInternalServerError {
+		t.Errorf("tenant login with a global repository: %v", err)
+	}
+}
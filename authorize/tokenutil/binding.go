@@ -0,0 +1,347 @@
+package tokenutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+)
+
+// BindingPolicy decides how a request is handled if it does not match the binding of its token.
+type BindingPolicy int
+
+const (
+	// BindingReject rejects the request with ErrBindingMismatch, the session stays valid.
+	BindingReject BindingPolicy = iota
+	// BindingReauthenticate revokes the session and returns ErrReauthenticate, the user has to log in again.
+	BindingReauthenticate
+)
+
+type BindingConfig struct {
+	IP                bool          // bind to the network of the client ip
+	IPv4Prefix        int           // prefix length of the bound ipv4 network, default 32
+	IPv6Prefix        int           // prefix length of the bound ipv6 network, default 64
+	UserAgent         bool          // bind to a fingerprint of the User-Agent header
+	ProofOfPossession bool          // bind to the key of a DPoP proof, requests must carry a proof signed by it
+	ProofMaxAge       time.Duration // how long a proof is accepted after its iat, default 1m
+	Required          bool          // reject tokens without binding, e.g. issued before the binding was enabled
+	Policy            BindingPolicy
+	ClientIP          func(request *http.Request) string // default is the host of RemoteAddr
+	// ProofStorage records the ids of used DPoP proofs apart from the tokens, default is a memory storage which
+	// detects replays within the process only.
+	ProofStorage Storage
+}
+
+func (c *BindingConfig) init() {
+	if c.IPv4Prefix <= 0 || c.IPv4Prefix > 32 {
+		c.IPv4Prefix = 32
+	}
+	if c.IPv6Prefix <= 0 || c.IPv6Prefix > 128 {
+		c.IPv6Prefix = 64
+	}
+	if c.ProofMaxAge <= 0 {
+		c.ProofMaxAge = time.Minute
+	}
+	if c.ClientIP == nil {
+		c.ClientIP = remoteIP
+	}
+	if c.ProofStorage == nil {
+		c.ProofStorage = NewMemoryStorage()
+	}
+}
+
+// WithTokenBinding binds new sessions to attributes of the login request, see Manager.Bind. The binding is
+// enforced by ValidateHTTPSession, ValidateBoundSession and by Refresh against the binding ctx carries, see
+// WithBinding. ValidateSession and ValidateToken do not enforce it.
+func WithTokenBinding(config BindingConfig) Option {
+	return func(m *Manager) {
+		config.init()
+		m.binding = &config
+	}
+}
+
+// Binding are the request attributes a session is bound to, empty attributes are not checked.
+type Binding struct {
+	Network       string `json:"network,omitempty"`   // network of the client ip in CIDR notation
+	UserAgent     string `json:"userAgent,omitempty"` // sha256 of the User-Agent header
+	KeyThumbprint string `json:"jkt,omitempty"`       // RFC 7638 thumbprint of the DPoP key
+}
+
+// matches reports whether the attributes of the request match the binding.
+func (b *Binding) matches(request *Binding) bool {
+	if request == nil {
+		return false
+	}
+	return (b.Network == "" || b.Network == request.Network) &&
+		(b.UserAgent == "" || b.UserAgent == request.UserAgent) &&
+		(b.KeyThumbprint == "" || b.KeyThumbprint == request.KeyThumbprint)
+}
+
+type bindingContextKey struct{}
+
+var _bindingContextKey = &bindingContextKey{}
+
+// WithBinding attaches the binding of a request to ctx, sessions created with ctx are bound to it.
+func WithBinding(ctx context.Context, binding *Binding) context.Context {
+	return context.WithValue(ctx, _bindingContextKey, binding)
+}
+
+func bindingFromContext(ctx context.Context) *Binding {
+	b, _ := ctx.Value(_bindingContextKey).(*Binding)
+	return b
+}
+
+// Bind returns the binding of the request, nil if token binding is not enabled. ErrBindingMismatch is returned
+// if a required DPoP proof is missing or invalid.
+func (m *Manager) Bind(request *http.Request) (*Binding, error) {
+	return m.bind(request, "")
+}
+
+// BindClient returns the binding of a client by its ip and user agent, e.g. of a gRPC peer, nil if token binding
+// is not enabled. Sessions bound to a DPoP key do not match it.
+func (m *Manager) BindClient(clientIP, userAgent string) (*Binding, error) {
+	if m.binding == nil {
+		return nil, nil
+	}
+	binding := &Binding{}
+	if m.binding.IP {
+		ip := net.ParseIP(clientIP)
+		if ip == nil {
+			return nil, ErrBindingMismatch
+		}
+		prefix, bits := m.binding.IPv6Prefix, 128
+		if ip.To4() != nil {
+			ip, prefix, bits = ip.To4(), m.binding.IPv4Prefix, 32
+		}
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+		binding.Network = network.String()
+	}
+	if m.binding.UserAgent {
+		sum := sha256.Sum256([]byte(userAgent))
+		binding.UserAgent = hex.EncodeToString(sum[:])
+	}
+	return binding, nil
+}
+
+// bind returns the binding of the request, a DPoP proof must contain the hash of the access token if it is set.
+func (m *Manager) bind(request *http.Request, accessToken string) (*Binding, error) {
+	if m.binding == nil {
+		return nil, nil
+	}
+	binding, err := m.BindClient(m.binding.ClientIP(request), request.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+	if m.binding.ProofOfPossession {
+		thumbprint, err := m.verifyProof(request, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		binding.KeyThumbprint = thumbprint
+	}
+	return binding, nil
+}
+
+// enforcesBinding reports whether the binding of the session has to be checked.
+func (m *Manager) enforcesBinding(token *Token) bool {
+	return m.binding != nil && (token.Binding != nil || m.binding.Required)
+}
+
+// checkBinding applies the binding policy if the request binding does not match the binding of the session.
+func (m *Manager) checkBinding(ctx context.Context, userId string, token *Token, request *Binding) error {
+	if !m.enforcesBinding(token) {
+		return nil
+	}
+	if token.Binding == nil {
+		return ErrBindingMismatch
+	}
+	if token.Binding.matches(request) {
+		return nil
+	}
+	if m.binding.Policy != BindingReauthenticate {
+		return ErrBindingMismatch
+	}
+	err := m.update(ctx, storageKey(token.TenantId, userId), func(tokens Tokens) error {
+		if current := tokens.Get(token.ClientId); current == nil || current.SessionId != token.SessionId {
+			return errUnchanged
+		}
+		tokens.Remove(token.ClientId)
+		return nil
+	})
+	if err == nil {
+		m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenRevoked, UserID: userId, ClientID: token.ClientId, Reason: "binding"})
+	} else if err != errUnchanged {
+		return err
+	}
+	return ErrReauthenticate
+}
+
+// ValidateHTTPSession validates the token like ValidateSession and enforces its binding against the request.
+func (m *Manager) ValidateHTTPSession(request *http.Request, tokenString string) (*Session, Claims, error) {
+	return m.validateBound(request.Context(), tokenString, func() (*Binding, error) {
+		return m.bind(request, tokenString)
+	})
+}
+
+// ValidateBoundSession validates the token like ValidateSession and enforces its binding against the binding of
+// the request, e.g. returned by BindClient for requests other than HTTP.
+func (m *Manager) ValidateBoundSession(ctx context.Context, tokenString string, binding *Binding) (*Session, Claims, error) {
+	return m.validateBound(ctx, tokenString, func() (*Binding, error) {
+		return binding, nil
+	})
+}
+
+// validateBound validates the token, the binding of the request is only computed for sessions with binding.
+func (m *Manager) validateBound(ctx context.Context, tokenString string, bind func() (*Binding, error)) (*Session, Claims, error) {
+	uid, token, claims, err := m.validate(ctx, tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.enforcesBinding(token) {
+		var binding *Binding
+		if binding, err = bind(); err != nil && err != ErrBindingMismatch {
+			return nil, nil, err
+		}
+		if err = m.checkBinding(ctx, uid, token, binding); err != nil {
+			return nil, nil, err
+		}
+	}
+	return token.session(uid), claims, nil
+}
+
+type proofHeader struct {
+	Type      string `json:"typ"`
+	Algorithm string `json:"alg"`
+	JWK       *JWK   `json:"jwk"`
+}
+
+type proofPayload struct {
+	ID       string `json:"jti"`
+	Method   string `json:"htm"`
+	URI      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	Hash     string `json:"ath,omitempty"`
+}
+
+// verifyProof verifies the DPoP header of the request and returns the thumbprint of its key. The proof must be
+// signed by the embedded public key, match the method and url of the request and be used only once.
+func (m *Manager) verifyProof(request *http.Request, accessToken string) (string, error) {
+	texts := strings.Split(request.Header.Get("DPoP"), ".")
+	if len(texts) != 3 {
+		return "", ErrBindingMismatch
+	}
+	var header proofHeader
+	var payload proofPayload
+	if b, err := jwtEncoding.DecodeString(texts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		return "", ErrBindingMismatch
+	}
+	if b, err := jwtEncoding.DecodeString(texts[1]); err != nil || json.Unmarshal(b, &payload) != nil {
+		return "", ErrBindingMismatch
+	}
+	if header.Type != "dpop+jwt" || header.JWK == nil || header.Algorithm == HS256 {
+		return "", ErrBindingMismatch
+	}
+	key, err := header.JWK.SigningKey()
+	if err != nil || key.Algorithm != header.Algorithm {
+		return "", ErrBindingMismatch
+	}
+	signature, err := jwtEncoding.DecodeString(texts[2])
+	if err != nil || !key.verify([]byte(texts[0]+"."+texts[1]), signature) {
+		return "", ErrBindingMismatch
+	}
+
+	age := time.Since(time.Unix(payload.IssuedAt, 0))
+	if payload.ID == "" || age > m.binding.ProofMaxAge || age < -m.binding.ProofMaxAge {
+		return "", ErrBindingMismatch
+	}
+	if payload.Method != request.Method || payload.URI != requestURI(request) {
+		return "", ErrBindingMismatch
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if payload.Hash != jwtEncoding.EncodeToString(sum[:]) {
+			return "", ErrBindingMismatch
+		}
+	}
+	if err = m.useProof(request.Context(), payload.ID); err != nil {
+		return "", err
+	}
+	return header.JWK.Thumbprint()
+}
+
+// useProof records the jti of a proof until it expires, ErrBindingMismatch is returned for a replayed proof.
+func (m *Manager) useProof(ctx context.Context, id string) error {
+	apply := func(val []byte) ([]byte, error) {
+		if val != nil {
+			return nil, ErrBindingMismatch
+		}
+		return []byte("1"), nil
+	}
+	key, expiration := "dpop:"+id, 2*m.binding.ProofMaxAge
+	if storage, ok := m.binding.ProofStorage.(AtomicStorage); ok {
+		return storage.Update(ctx, key, apply, expiration)
+	}
+	unlock := m.locks.lock(key)
+	defer unlock()
+	return updateStorage(ctx, m.binding.ProofStorage, key, apply, expiration)
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the public key.
+func (jwk JWK) Thumbprint() (string, error) {
+	var members any
+	switch jwk.KeyType {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	default:
+		return "", ErrBindingMismatch
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return jwtEncoding.EncodeToString(sum[:]), nil
+}
+
+// requestURI returns the url of the request without query and fragment, as used in the htu claim.
+func requestURI(request *http.Request) string {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := request.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + request.Host + request.URL.Path
+}
+
+func remoteIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,168 @@
+package tokenutil
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_Binding(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(WithTokenBinding(BindingConfig{IP: true, IPv4Prefix: 24, UserAgent: true}))
+	request := func(ip, userAgent string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.RemoteAddr = ip + ":1234"
+		request.Header.Set("User-Agent", userAgent)
+		return request
+	}
+	binding, err := manager.Bind(request("10.0.0.1", "console"))
+	if err != nil || binding.Network != "10.0.0.0/24" || binding.UserAgent == "" {
+		t.Fatalf("unexpected binding: %+v %v", binding, err)
+	}
+	pair, err := manager.GenTokenPair(WithBinding(ctx, binding), "1", "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func(request *http.Request) error {
+		request.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+		_, err := manager.ValidateHTTPRequest(request)
+		return err
+	}
+	if err = validate(request("10.0.0.2", "console")); err != nil {
+		t.Errorf("request of the bound network rejected: %v", err)
+	}
+	if err = validate(request("10.0.1.1", "console")); err != ErrBindingMismatch {
+		t.Errorf("request of another network accepted: %v", err)
+	}
+	if err = validate(request("10.0.0.1", "curl")); err != ErrBindingMismatch {
+		t.Errorf("request of another user agent accepted: %v", err)
+	}
+	binding, _ = manager.Bind(request("10.0.1.1", "console"))
+	if _, err = manager.Refresh(WithBinding(ctx, binding), pair.RefreshToken); err != ErrBindingMismatch {
+		t.Errorf("refreshed from another network: %v", err)
+	}
+	if _, err = manager.Refresh(ctx, pair.RefreshToken); err != ErrBindingMismatch {
+		t.Errorf("refreshed without binding: %v", err)
+	}
+	client, _ := manager.BindClient("10.0.0.9", "console")
+	if _, _, err = manager.ValidateBoundSession(ctx, pair.AccessToken, client); err != nil {
+		t.Errorf("client of the bound network rejected: %v", err)
+	}
+	client, _ = manager.BindClient("10.0.0.9", "grpc-go")
+	if _, _, err = manager.ValidateBoundSession(ctx, pair.AccessToken, client); err != ErrBindingMismatch {
+		t.Errorf("client of another user agent accepted: %v", err)
+	}
+	if _, _, err = manager.ValidateBoundSession(ctx, pair.AccessToken, nil); err != ErrBindingMismatch {
+		t.Errorf("client without binding accepted: %v", err)
+	}
+	if _, err = manager.ValidateToken(ctx, pair.AccessToken); err != nil {
+		t.Errorf("rejected request revoked the session: %v", err)
+	}
+
+	manager.binding.Policy = BindingReauthenticate
+	if err = validate(request("10.0.1.1", "console")); err != ErrReauthenticate {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = manager.ValidateToken(ctx, pair.AccessToken); err != ErrTokenInvalid {
+		t.Errorf("session not revoked: %v", err)
+	}
+}
+
+func TestManager_ProofOfPossession(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(WithJWT(mustKey(t, HS256)), WithTokenBinding(BindingConfig{ProofOfPossession: true, Required: true}))
+	key := mustKey(t, ES256)
+	jwk, _ := NewJWK(key)
+	proof := func(key *SigningKey, method, uri, accessToken string) string {
+		header, _ := json.Marshal(map[string]any{"typ": "dpop+jwt", "alg": key.Algorithm, "jwk": jwk})
+		payload := map[string]any{"jti": randString(16), "htm": method, "htu": uri, "iat": time.Now().Unix()}
+		if accessToken != "" {
+			sum := sha256.Sum256([]byte(accessToken))
+			payload["ath"] = jwtEncoding.EncodeToString(sum[:])
+		}
+		body, _ := json.Marshal(payload)
+		input := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(body)
+		signature, err := key.sign([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return input + "." + jwtEncoding.EncodeToString(signature)
+	}
+
+	login := httptest.NewRequest(http.MethodPost, "http://example.com/login", nil)
+	if _, err := manager.Bind(login); err != ErrBindingMismatch {
+		t.Errorf("bound without proof: %v", err)
+	}
+	login.Header.Set("DPoP", proof(key, http.MethodPost, "http://example.com/login", ""))
+	binding, err := manager.Bind(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint, _ := jwk.Thumbprint(); binding.KeyThumbprint != thumbprint {
+		t.Errorf("unexpected thumbprint: %s", binding.KeyThumbprint)
+	}
+	if _, err = manager.Bind(login); err != ErrBindingMismatch {
+		t.Errorf("replayed proof accepted: %v", err)
+	}
+	pair, err := manager.GenTokenPair(WithBinding(ctx, binding), "1", "admin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := manager.Introspect(ctx, pair.AccessToken); err != nil || result.Claims.Get("cnf") != "" {
+		t.Errorf("unexpected introspection: %+v %v", result, err)
+	}
+
+	api := func(dpop string) error {
+		request := httptest.NewRequest(http.MethodGet, "http://example.com/api?x=1", nil)
+		request.Header.Set("Authorization", "DPoP "+pair.AccessToken)
+		request.Header.Set("DPoP", dpop)
+		_, err := manager.ValidateHTTPRequest(request)
+		return err
+	}
+	if err = api(proof(key, http.MethodGet, "http://example.com/api", pair.AccessToken)); err != nil {
+		t.Errorf("valid proof rejected: %v", err)
+	}
+	if err = api(proof(key, http.MethodPost, "http://example.com/api", pair.AccessToken)); err != ErrBindingMismatch {
+		t.Errorf("proof of another method accepted: %v", err)
+	}
+	if err = api(proof(key, http.MethodGet, "http://example.com/api", "")); err != ErrBindingMismatch {
+		t.Errorf("proof without token hash accepted: %v", err)
+	}
+	if err = api(proof(mustKey(t, ES256), http.MethodGet, "http://example.com/api", pair.AccessToken)); err != ErrBindingMismatch {
+		t.Errorf("proof of another key accepted: %v", err)
+	}
+
+	unbound, _ := manager.GenTokenPair(ctx, "1", "web", nil)
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("Authorization", "Bearer "+unbound.AccessToken)
+	if _, err = manager.ValidateHTTPRequest(request); err != ErrBindingMismatch {
+		t.Errorf("unbound token accepted: %v", err)
+	}
+	if _, err = manager.Refresh(WithBinding(ctx, binding), unbound.RefreshToken); err != ErrBindingMismatch {
+		t.Errorf("unbound session refreshed although binding is required: %v", err)
+	}
+
+	// proofs are recorded apart from the sessions
+	tokens, proofs := manager.storage.(*memoryStorage), manager.binding.ProofStorage.(*memoryStorage)
+	for key := range tokens.data {
+		if strings.HasPrefix(key, "dpop:") {
+			t.Errorf("proof recorded in the session storage: %s", key)
+		}
+	}
+	if len(proofs.data) == 0 {
+		t.Error("proofs not recorded")
+	}
+}
+
+func mustKey(t *testing.T, alg string) *SigningKey {
+	key, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
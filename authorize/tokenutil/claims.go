@@ -5,8 +5,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Claims are the custom claims of a token. Values are strings on the wire, typed values are encoded by SetValue
+// and decoded by Value or the typed accessors, e.g. claims.Int64("org").
 type Claims map[string]string
 
 func (c Claims) Get(key string) string {
@@ -18,6 +23,104 @@ func (c Claims) Set(key, value string) {
 	c[key] = value
 }
 
+// Int64 returns the claim as integer, 0 if it is missing or not an integer.
+func (c Claims) Int64(key string) int64 {
+	v, _ := Value[int64](c, key)
+	return v
+}
+
+// Float64 returns the claim as number, 0 if it is missing or not a number.
+func (c Claims) Float64(key string) float64 {
+	v, _ := Value[float64](c, key)
+	return v
+}
+
+// Bool returns the claim as boolean, false if it is missing or not a boolean.
+func (c Claims) Bool(key string) bool {
+	v, _ := Value[bool](c, key)
+	return v
+}
+
+// Strings returns the claim as list, a json array or a space separated list like the scope claim.
+func (c Claims) Strings(key string) []string {
+	v, _ := Value[[]string](c, key)
+	return v
+}
+
+// Time returns the claim as time, encoded as unix seconds or RFC 3339. The zero time is returned if it is missing.
+func (c Claims) Time(key string) time.Time {
+	v, _ := Value[time.Time](c, key)
+	return v
+}
+
+// Value decodes the claim, integers, floats, booleans, string lists and times are decoded like the accessors of
+// Claims, other types are decoded from json, e.g. nested metadata. false is returned if the claim is missing or
+// can not be decoded.
+func Value[T any](c Claims, key string) (T, bool) {
+	var v T
+	text, ok := c[key]
+	if !ok {
+		return v, false
+	}
+	var err error
+	switch p := any(&v).(type) {
+	case *string:
+		*p = text
+	case *int64:
+		*p, err = strconv.ParseInt(text, 10, 64)
+	case *int:
+		*p, err = strconv.Atoi(text)
+	case *float64:
+		*p, err = strconv.ParseFloat(text, 64)
+	case *bool:
+		*p, err = strconv.ParseBool(text)
+	case *[]string:
+		if strings.HasPrefix(text, "[") {
+			err = json.Unmarshal([]byte(text), p)
+		} else {
+			*p = strings.Fields(text)
+		}
+	case *time.Time:
+		if seconds, e := strconv.ParseInt(text, 10, 64); e == nil {
+			*p = time.Unix(seconds, 0)
+		} else {
+			*p, err = time.Parse(time.RFC3339, text)
+		}
+	default:
+		err = json.Unmarshal([]byte(text), p)
+	}
+	if err != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+// SetValue encodes the value in the format decoded by Value, string lists and other types are stored as json.
+func SetValue[T any](c Claims, key string, value T) error {
+	switch v := any(value).(type) {
+	case string:
+		c[key] = v
+	case int64:
+		c[key] = strconv.FormatInt(v, 10)
+	case int:
+		c[key] = strconv.Itoa(v)
+	case float64:
+		c[key] = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		c[key] = strconv.FormatBool(v)
+	case time.Time:
+		c[key] = strconv.FormatInt(v.Unix(), 10)
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		c[key] = string(b)
+	}
+	return nil
+}
+
 func (c Claims) Sort() []string {
 	keys := make([]string, len(c))
 	i := 0
@@ -0,0 +1,42 @@
+package tokenutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClaims_Value(t *testing.T) {
+	type metadata struct {
+		Team string `json:"team"`
+	}
+	now := time.Unix(time.Now().Unix(), 0)
+	claims := Claims{"scope": "read write"}
+	_ = SetValue(claims, "org", int64(42))
+	_ = SetValue(claims, "ratio", 0.5)
+	_ = SetValue(claims, "admin", true)
+	_ = SetValue(claims, "groups", []string{"a", "b c"})
+	_ = SetValue(claims, "seen", now)
+	_ = SetValue(claims, "meta", metadata{Team: "core"})
+
+	if claims.Int64("org") != 42 || claims.Get("org") != "42" {
+		t.Errorf("unexpected org: %v", claims)
+	}
+	if claims.Float64("ratio") != 0.5 || !claims.Bool("admin") || !claims.Time("seen").Equal(now) {
+		t.Errorf("unexpected claims: %v", claims)
+	}
+	if groups := claims.Strings("groups"); len(groups) != 2 || groups[1] != "b c" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+	if scopes := claims.Strings("scope"); len(scopes) != 2 || scopes[0] != "read" {
+		t.Errorf("unexpected scopes: %v", scopes)
+	}
+	if meta, ok := Value[metadata](claims, "meta"); !ok || meta.Team != "core" {
+		t.Errorf("unexpected metadata: %v %v", meta, ok)
+	}
+	if _, ok := Value[int64](claims, "scope"); ok {
+		t.Error("decoded invalid integer")
+	}
+	if _, ok := Value[string](claims, "missing"); ok || claims.Int64("missing") != 0 {
+		t.Error("decoded missing claim")
+	}
+}
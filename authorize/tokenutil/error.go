@@ -9,4 +9,15 @@ func (e textError) Error() string {
 const (
 	ErrTokenInvalid = textError("token invalid")
 	ErrTokenExpired = textError("token expired")
+	ErrTokenReused  = textError("token reused")
+	ErrKeyNotFound  = textError("signing key not found")
+
+	ErrTenantMismatch = textError("token of another tenant")
+	// ErrConflict is returned if an update did not succeed because of concurrent updates.
+	ErrConflict = textError("concurrent update conflict")
+
+	ErrBindingMismatch = textError("token binding mismatch")
+	ErrReauthenticate  = textError("reauthentication required")
+
+	ErrImpersonationDenied = textError("impersonation denied")
 )
@@ -0,0 +1,133 @@
+package tokenutil
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+)
+
+// ClaimActor is the RFC 8693 act claim of impersonation tokens, it is set by the Manager and can not be issued
+// as custom claim.
+const ClaimActor = "act"
+
+// Actor is the party acting on behalf of the subject of an impersonation token.
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// Actor returns the user impersonating the subject, empty for tokens of the subject itself.
+func (c Claims) Actor() string {
+	actor, _ := Value[Actor](c, ClaimActor)
+	return actor.Subject
+}
+
+type ImpersonationConfig struct {
+	// Allow decides whether the actor may impersonate the target, ctx is the context passed to Impersonate,
+	// e.g. the context of an authenticated request. Impersonation is denied if it is nil.
+	Allow func(ctx context.Context, actorUID, targetUID string) (bool, error)
+	// Claims returns the claims of tokens impersonating the target, default is no claims.
+	Claims func(ctx context.Context, targetUID string) (Claims, error)
+	MaxTTL time.Duration // max lifetime of impersonation tokens, default 1h
+}
+
+func (c *ImpersonationConfig) init() {
+	if c.MaxTTL <= 0 {
+		c.MaxTTL = time.Hour
+	}
+}
+
+// WithImpersonation enable Impersonate.
+func WithImpersonation(config ImpersonationConfig) Option {
+	return func(m *Manager) {
+		config.init()
+		m.impersonation = &config
+	}
+}
+
+// Impersonate issues an access token of the target carrying the actor in the act claim, without refresh token.
+// The token expires after ttl, at most after the MaxTTL of the ImpersonationConfig. Each actor has its own session
+// with the target, see RevokeImpersonation, the sessions of the target are not affected. Impersonation tokens
+// can not impersonate again.
+func (m *Manager) Impersonate(ctx context.Context, actorUID, targetUID string, ttl time.Duration) (*TokenPair, error) {
+	config := m.impersonation
+	if config == nil || config.Allow == nil || actorUID == "" || targetUID == "" || actorUID == targetUID {
+		return nil, ErrImpersonationDenied
+	}
+	if FromContext(ctx).Actor() != "" {
+		return nil, ErrImpersonationDenied
+	}
+	if ok, err := config.Allow(ctx, actorUID, targetUID); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrImpersonationDenied
+	}
+	if ttl <= 0 || ttl > config.MaxTTL {
+		ttl = config.MaxTTL
+	}
+	claims := Claims{}
+	if config.Claims != nil {
+		target, err := config.Claims(ctx, targetUID)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range target {
+			claims[k] = v
+		}
+	}
+	if err := SetValue(claims, ClaimActor, Actor{Subject: actorUID}); err != nil {
+		return nil, err
+	}
+
+	now := m.now().UnixMilli()
+	token := &Token{
+		ClientId:   impersonationClientId(actorUID),
+		TenantId:   TenantFromContext(ctx),
+		SessionId:  randString(16),
+		Secret:     randString(16),
+		Timestamp:  now,
+		IssuedAt:   now,
+		LastSeen:   now,
+		Generation: 1,
+		Claims:     claims,
+		Binding:    bindingFromContext(ctx),
+		Actor:      actorUID,
+		ExpiresAt:  now + int64(ttl/time.Millisecond),
+	}
+	err := m.update(ctx, storageKey(token.TenantId, targetUID), func(tokens Tokens) error {
+		// the session of the actor replaces its previous one and does not count against the sessions of the target
+		tokens.Set(token, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenIssued, UserID: targetUID, ClientID: token.ClientId,
+		Metadata: map[string]string{"grant": "impersonation", "actor": actorUID}})
+	pair, err := m.issue(ctx, targetUID, token)
+	if err != nil {
+		return nil, err
+	}
+	pair.RefreshToken = ""
+	pair.ExpiresIn = int64(ttl / time.Second)
+	return pair, nil
+}
+
+// RevokeImpersonation removes the session of the actor impersonating the target in the tenant of ctx.
+func (m *Manager) RevokeImpersonation(ctx context.Context, actorUID, targetUID string) error {
+	return m.Revoke(ctx, targetUID, impersonationClientId(actorUID))
+}
+
+func impersonationClientId(actorUID string) string {
+	return "impersonate:" + actorUID
+}
+
+// actorClaims sets the act claim of impersonation sessions and removes it from the claims of other sessions.
+func actorClaims(claims Claims, token *Token) Claims {
+	if token.Actor == "" {
+		delete(claims, ClaimActor)
+		return claims
+	}
+	_ = SetValue(claims, ClaimActor, Actor{Subject: token.Actor})
+	return claims
+}
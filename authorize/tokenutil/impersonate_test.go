@@ -0,0 +1,70 @@
+package tokenutil
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManager_Impersonate(t *testing.T) {
+	ctx := context.Background()
+	key := mustKey(t, HS256)
+	allow := func(ctx context.Context, actorUID, targetUID string) (bool, error) {
+		return actorUID == "support", nil
+	}
+	claims := func(ctx context.Context, targetUID string) (Claims, error) {
+		return Claims{"roles": "user", ClaimActor: `{"sub":"forged"}`}, nil
+	}
+	if _, err := NewManager().Impersonate(ctx, "support", "1", time.Minute); err != ErrImpersonationDenied {
+		t.Errorf("impersonated without config: %v", err)
+	}
+	for name, manager := range map[string]*Manager{
+		"legacy": NewManager(WithImpersonation(ImpersonationConfig{Allow: allow, Claims: claims})),
+		"jwt":    NewManager(WithJWT(key), WithImpersonation(ImpersonationConfig{Allow: allow, Claims: claims})),
+	} {
+		own, err := manager.GenTokenPair(ctx, "1", "web", Claims{ClaimActor: `{"sub":"forged"}`})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if _, err = manager.Impersonate(ctx, "staff", "1", time.Minute); err != ErrImpersonationDenied {
+			t.Errorf("%s: impersonated without permission: %v", name, err)
+		}
+		pair, err := manager.Impersonate(ctx, "support", "1", 50*time.Millisecond)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if pair.RefreshToken != "" || pair.ExpiresIn != 0 {
+			t.Errorf("%s: unexpected pair %+v", name, pair)
+		}
+		session, impersonated, err := manager.ValidateSession(ctx, pair.AccessToken)
+		if err != nil || session.UserId != "1" || session.Actor != "support" || impersonated.Actor() != "support" ||
+			impersonated.Get("roles") != "user" {
+			t.Errorf("%s: unexpected session %+v %v %v", name, session, impersonated, err)
+		}
+		if _, err = manager.Impersonate(impersonated.WithContext(ctx), "support", "2", time.Minute); err != ErrImpersonationDenied {
+			t.Errorf("%s: impersonated with impersonation token: %v", name, err)
+		}
+		if claims, err := manager.ValidateToken(ctx, own.AccessToken); err != nil || claims.Actor() != "" {
+			t.Errorf("%s: forged actor: %v %v", name, claims, err)
+		}
+		if result, err := manager.Introspect(ctx, pair.AccessToken); err != nil || result.Registered.Actor != "support" {
+			t.Errorf("%s: unexpected introspection %+v %v", name, result, err)
+		}
+
+		if err = manager.RevokeImpersonation(ctx, "support", "1"); err != nil {
+			t.Fatal(name, err)
+		}
+		if _, err = manager.ValidateToken(ctx, pair.AccessToken); err != ErrTokenInvalid {
+			t.Errorf("%s: revoked impersonation is valid: %v", name, err)
+		}
+		if _, err = manager.ValidateToken(ctx, own.AccessToken); err != nil {
+			t.Errorf("%s: session of the target revoked: %v", name, err)
+		}
+
+		pair, _ = manager.Impersonate(ctx, "support", "1", 10*time.Millisecond)
+		time.Sleep(1100 * time.Millisecond)
+		if _, err = manager.ValidateToken(ctx, pair.AccessToken); err != ErrTokenExpired {
+			t.Errorf("%s: impersonation not expired: %v", name, err)
+		}
+	}
+}
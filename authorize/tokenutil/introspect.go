@@ -0,0 +1,143 @@
+package tokenutil
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionState is the state of the session an access token belongs to.
+type SessionState string
+
+const (
+	SessionActive  SessionState = "active"
+	SessionExpired SessionState = "expired"
+	SessionRevoked SessionState = "revoked" // the session was revoked or replaced by a new login
+)
+
+// RegisteredClaims are the claims every access token carries, independent of its format.
+type RegisteredClaims struct {
+	Issuer    string    `json:"iss,omitempty"`
+	Subject   string    `json:"sub"`
+	Audience  Audience  `json:"aud,omitempty"`
+	ClientID  string    `json:"cid"`
+	SessionID string    `json:"sid,omitempty"`
+	TenantID  string    `json:"tid,omitempty"`
+	Actor     string    `json:"act,omitempty"` // the user impersonating the subject
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+	Scopes    []string  `json:"scope,omitempty"` // the space separated scope claim
+}
+
+// Introspection is the decoded content of an access token and the state of its session.
+type Introspection struct {
+	Active     bool             `json:"active"`
+	State      SessionState     `json:"state"`
+	Head       Claims           `json:"head"` // the JOSE header of a JWT, or the head of a legacy token
+	Registered RegisteredClaims `json:"registered"`
+	Claims     Claims           `json:"claims,omitempty"`
+	Session    *Session         `json:"session,omitempty"` // nil if the session has been revoked
+}
+
+// Introspect verifies the signature of the token and returns its content, unlike ValidateToken it does not fail
+// on expired tokens or revoked sessions and does not record the activity of the session. Legacy tokens of revoked
+// sessions can not be verified and are rejected with ErrTokenInvalid.
+func (m *Manager) Introspect(ctx context.Context, tokenString string) (*Introspection, error) {
+	var result *Introspection
+	var err error
+	if m.keys != nil {
+		result, err = m.introspectJWT(ctx, tokenString)
+	} else {
+		result, err = m.introspectToken(ctx, tokenString)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Registered.Scopes = result.Claims.Strings("scope")
+	result.Active = result.State == SessionActive
+	return result, nil
+}
+
+func (m *Manager) introspectToken(ctx context.Context, tokenString string) (*Introspection, error) {
+	head, claims, token, err := m.parseToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	issuedAt, _ := strconv.ParseInt(head["timestamp"], 10, 64)
+	result := &Introspection{
+		State: SessionActive,
+		Head:  head,
+		Registered: RegisteredClaims{
+			Subject:   head["uid"],
+			ClientID:  head["cid"],
+			SessionID: token.SessionId,
+			TenantID:  head["tid"],
+			Actor:     token.Actor,
+			IssuedAt:  time.UnixMilli(issuedAt),
+			ExpiresAt: m.expiresAt(token),
+		},
+		Claims:  actorClaims(claims, token),
+		Session: token.session(head["uid"]),
+	}
+	if m.now().After(result.Registered.ExpiresAt) {
+		result.State = SessionExpired
+	}
+	return result, nil
+}
+
+func (m *Manager) introspectJWT(ctx context.Context, tokenString string) (*Introspection, error) {
+	payload, claims, err := m.parseJWT(ctx, tokenString)
+	if err != nil && err != ErrTokenExpired {
+		return nil, err
+	}
+	if payload.Subject == "" || payload.ClientID == "" {
+		return nil, ErrTokenInvalid
+	}
+	if err := checkTenant(ctx, payload.TenantID); err != nil {
+		return nil, err
+	}
+	var header jwtHeader
+	if b, e := jwtEncoding.DecodeString(strings.SplitN(tokenString, ".", 2)[0]); e != nil || json.Unmarshal(b, &header) != nil {
+		return nil, ErrTokenInvalid
+	}
+	result := &Introspection{
+		State: SessionActive,
+		Head:  Claims{"alg": header.Algorithm, "typ": header.Type, "kid": header.KeyID},
+		Registered: RegisteredClaims{
+			Issuer:    payload.Issuer,
+			Subject:   payload.Subject,
+			Audience:  payload.Audience,
+			ClientID:  payload.ClientID,
+			SessionID: payload.SessionID,
+			TenantID:  payload.TenantID,
+			IssuedAt:  time.Unix(payload.IssuedAt, 0),
+			ExpiresAt: time.Unix(payload.ExpiresAt, 0),
+		},
+		Claims: claims,
+	}
+	if payload.Actor != nil {
+		result.Registered.Actor = payload.Actor.Subject
+	}
+	if err == ErrTokenExpired {
+		result.State = SessionExpired
+	}
+	tokens, e := m.getTokens(ctx, storageKey(payload.TenantID, payload.Subject))
+	if e != nil {
+		return nil, e
+	}
+	token := tokens.Get(payload.ClientID)
+	if token == nil || token.SessionId != payload.SessionID {
+		result.State = SessionRevoked
+		return result, nil
+	}
+	result.Session = token.session(payload.Subject)
+	if lifetime := result.Session.IssuedAt.Add(m.maxLifetime); m.maxLifetime > 0 && lifetime.Before(result.Registered.ExpiresAt) {
+		result.Registered.ExpiresAt = lifetime
+	}
+	if m.outlived(token) {
+		result.State = SessionExpired
+	}
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package tokenutil
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManager_Introspect(t *testing.T) {
+	key, err := GenerateSigningKey(HS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, manager := range map[string]*Manager{
+		"legacy": NewManager(WithMaxAge(50 * time.Millisecond)),
+		"jwt":    NewManager(WithJWT(key), WithMaxAge(time.Second)),
+	} {
+		ctx := WithTenant(context.Background(), "acme")
+		claims := Claims{"scope": "read write"}
+		_ = SetValue(claims, "org", int64(7))
+		pair, err := manager.GenTokenPair(ctx, "1", "web", claims)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		result, err := manager.Introspect(ctx, pair.AccessToken)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		registered := result.Registered
+		if !result.Active || result.State != SessionActive || result.Session == nil || result.Claims.Int64("org") != 7 {
+			t.Errorf("%s: unexpected introspection %+v", name, result)
+		}
+		if registered.Subject != "1" || registered.ClientID != "web" || registered.TenantID != "acme" ||
+			len(registered.Scopes) != 2 || registered.IssuedAt.IsZero() || !registered.ExpiresAt.After(registered.IssuedAt) {
+			t.Errorf("%s: unexpected registered claims %+v", name, registered)
+		}
+		if len(result.Head) == 0 {
+			t.Errorf("%s: missing head", name)
+		}
+		if _, err = manager.Introspect(WithTenant(context.Background(), "globex"), pair.AccessToken); err != ErrTenantMismatch {
+			t.Errorf("%s: introspected by another tenant: %v", name, err)
+		}
+		if _, err = manager.Introspect(ctx, "invalid"); err != ErrTokenInvalid {
+			t.Errorf("%s: introspected invalid token: %v", name, err)
+		}
+	}
+
+	manager := NewManager(WithMaxAge(50 * time.Millisecond))
+	pair, _ := manager.GenTokenPair(context.Background(), "1", "web", nil)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := manager.ValidateToken(context.Background(), pair.AccessToken); err != ErrTokenExpired {
+		t.Fatalf("token not expired: %v", err)
+	}
+	if result, err := manager.Introspect(context.Background(), pair.AccessToken); err != nil || result.Active || result.State != SessionExpired {
+		t.Errorf("unexpected introspection of expired token: %+v %v", result, err)
+	}
+
+	manager = NewManager(WithJWT(key), WithMaxAge(time.Second))
+	pair, _ = manager.GenTokenPair(context.Background(), "1", "web", nil)
+	_ = manager.Revoke(context.Background(), "1", "web")
+	if result, err := manager.Introspect(context.Background(), pair.AccessToken); err != nil || result.Active ||
+		result.State != SessionRevoked || result.Session != nil {
+		t.Errorf("unexpected introspection of revoked token: %+v %v", result, err)
+	}
+}
@@ -0,0 +1,154 @@
+package tokenutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SigningKey returns the verification key of a public JWK, see NewJWK.
+func (jwk JWK) SigningKey() (*SigningKey, error) {
+	key := &SigningKey{ID: jwk.KeyID, Algorithm: jwk.Algorithm}
+	decode := func(s string) (*big.Int, error) {
+		b, err := jwtEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		key.PublicKey = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if key.Algorithm == "" {
+			key.Algorithm = RS256
+		}
+	case "EC":
+		if jwk.Curve != "P-256" {
+			return nil, fmt.Errorf("tokenutil: unsupported curve %s", jwk.Curve)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key.PublicKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if key.Algorithm == "" {
+			key.Algorithm = ES256
+		}
+	case "OKP":
+		x, err := jwtEncoding.DecodeString(jwk.X)
+		if err != nil || jwk.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("tokenutil: invalid ed25519 key %s", jwk.KeyID)
+		}
+		key.PublicKey = ed25519.PublicKey(x)
+		if key.Algorithm == "" {
+			key.Algorithm = EdDSA
+		}
+	default:
+		return nil, fmt.Errorf("tokenutil: unsupported key type %s", jwk.KeyType)
+	}
+	return key, nil
+}
+
+// RemoteKeySet is a KeyProvider verifying tokens with the keys published at a JWKS url, e.g. by an OIDC provider.
+// It can not sign tokens. Keys are cached and reloaded when an unknown kid is seen, at most once per interval.
+type RemoteKeySet struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]*SigningKey
+	loadedAt time.Time
+}
+
+var _ KeyProvider = (*RemoteKeySet)(nil)
+
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteKeySet{url: url, client: client, interval: time.Minute}
+}
+
+func (r *RemoteKeySet) SigningKey(ctx context.Context) (*SigningKey, error) {
+	return nil, ErrKeyNotFound
+}
+
+func (r *RemoteKeySet) VerificationKey(ctx context.Context, kid string) (*SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key := r.lookup(kid); key != nil {
+		return key, nil
+	}
+	if !r.loadedAt.IsZero() && time.Since(r.loadedAt) < r.interval {
+		return nil, ErrKeyNotFound
+	}
+	if err := r.load(ctx); err != nil {
+		return nil, err
+	}
+	if key := r.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+func (r *RemoteKeySet) lookup(kid string) *SigningKey {
+	if kid == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key
+		}
+	}
+	return r.keys[kid]
+}
+
+func (r *RemoteKeySet) load(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := r.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("tokenutil: fetch %s: %s", r.url, response.Status)
+	}
+	var jwks JWKS
+	if err = json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]*SigningKey)
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped
+		if key, err := jwk.SigningKey(); err == nil {
+			keys[key.ID] = key
+		}
+	}
+	r.keys = keys
+	r.loadedAt = time.Now()
+	return nil
+}
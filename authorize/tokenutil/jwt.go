@@ -0,0 +1,247 @@
+package tokenutil
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// registeredClaims are the JWT claims reserved by RFC 7519, the client, session and tenant id, the key
+// confirmation and the actor, they are never returned as custom Claims.
+var registeredClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true, "cid": true, "sid": true, "tid": true, "cnf": true, "act": true,
+}
+
+// Audience is the aud claim, encoded as a string when it has a single value.
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtPayload struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	ClientID  string   `json:"cid,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	TenantID  string   `json:"tid,omitempty"`
+	// Confirmation is the RFC 9449 cnf claim of tokens bound to a DPoP key
+	Confirmation *jwtConfirmation `json:"cnf,omitempty"`
+	Actor        *Actor           `json:"act,omitempty"`
+}
+
+type jwtConfirmation struct {
+	KeyThumbprint string `json:"jkt"`
+}
+
+var jwtEncoding = base64.RawURLEncoding
+
+func (m *Manager) signJWT(ctx context.Context, userId string, token *Token) (string, error) {
+	key, err := m.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	issuedAt := time.UnixMilli(token.Timestamp)
+	payload := jwtPayload{
+		Issuer:    m.issuer,
+		Subject:   userId,
+		Audience:  m.audience,
+		ExpiresAt: issuedAt.Add(m.maxAge).Unix(),
+		NotBefore: issuedAt.Unix(),
+		IssuedAt:  issuedAt.Unix(),
+		ID:        randString(16),
+		ClientID:  token.ClientId,
+		SessionID: token.SessionId,
+		TenantID:  token.TenantId,
+	}
+	if token.ExpiresAt > 0 && token.ExpiresAt/1000 < payload.ExpiresAt {
+		payload.ExpiresAt = token.ExpiresAt / 1000
+	}
+	if token.Actor != "" {
+		payload.Actor = &Actor{Subject: token.Actor}
+	}
+	if token.Binding != nil && token.Binding.KeyThumbprint != "" {
+		payload.Confirmation = &jwtConfirmation{KeyThumbprint: token.Binding.KeyThumbprint}
+	}
+	fields := map[string]any{}
+	for k, v := range token.Claims {
+		if !registeredClaims[k] {
+			fields[k] = v
+		}
+	}
+	b, _ := json.Marshal(payload)
+	_ = json.Unmarshal(b, &fields)
+
+	header, _ := json.Marshal(jwtHeader{Algorithm: key.Algorithm, Type: "JWT", KeyID: key.ID})
+	body, _ := json.Marshal(fields)
+	input := jwtEncoding.EncodeToString(header) + "." + jwtEncoding.EncodeToString(body)
+	signature, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + jwtEncoding.EncodeToString(signature), nil
+}
+
+// parseJWT verifies the signature and the registered claims of a JWT, the session is not checked.
+func (m *Manager) parseJWT(ctx context.Context, tokenString string) (*jwtPayload, Claims, error) {
+	v := &JWTVerifier{Keys: m.keys, Issuer: m.issuer, Audience: m.audience, Leeway: m.leeway}
+	payload, fields, err := v.verify(ctx, tokenString)
+	if payload == nil {
+		return nil, nil, err
+	}
+	claims := Claims{}
+	for k, v := range fields {
+		if registeredClaims[k] {
+			continue
+		}
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			claims[k] = s
+		} else {
+			claims[k] = string(v)
+		}
+	}
+	return payload, claims, err
+}
+
+// JWTVerifier verifies JWTs of other issuers, e.g. OIDC id tokens of an upstream identity provider.
+type JWTVerifier struct {
+	Keys     KeyProvider
+	Issuer   string   // required iss claim, not checked if empty
+	Audience []string // the aud claim must contain one of them, not checked if empty
+	Leeway   time.Duration
+}
+
+// Verify verifies the signature, exp, nbf, iss and aud and returns all claims of the token.
+func (v *JWTVerifier) Verify(ctx context.Context, tokenString string) (map[string]any, error) {
+	_, fields, err := v.verify(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]any, len(fields))
+	for k, raw := range fields {
+		var value any
+		_ = json.Unmarshal(raw, &value)
+		claims[k] = value
+	}
+	return claims, nil
+}
+
+// verify returns the payload with ErrTokenExpired or ErrTokenInvalid if only the registered claims are invalid.
+func (v *JWTVerifier) verify(ctx context.Context, tokenString string) (*jwtPayload, map[string]json.RawMessage, error) {
+	texts := strings.Split(tokenString, ".")
+	if len(texts) != 3 {
+		return nil, nil, ErrTokenInvalid
+	}
+	var header jwtHeader
+	if b, err := jwtEncoding.DecodeString(texts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, nil, ErrTokenInvalid
+	}
+	key, err := v.Keys.VerificationKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, nil, ErrTokenInvalid
+	}
+	signature, err := jwtEncoding.DecodeString(texts[2])
+	if err != nil || header.Algorithm != key.Algorithm || !key.verify([]byte(texts[0]+"."+texts[1]), signature) {
+		return nil, nil, ErrTokenInvalid
+	}
+
+	body, err := jwtEncoding.DecodeString(texts[1])
+	if err != nil {
+		return nil, nil, ErrTokenInvalid
+	}
+	var payload jwtPayload
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &payload) != nil || json.Unmarshal(body, &fields) != nil {
+		return nil, nil, ErrTokenInvalid
+	}
+
+	now := time.Now()
+	leeway := int64(v.Leeway / time.Second)
+	if payload.ExpiresAt > 0 && now.Unix() > payload.ExpiresAt+leeway {
+		return &payload, fields, ErrTokenExpired
+	}
+	if payload.NotBefore > 0 && now.Unix()+leeway < payload.NotBefore {
+		return &payload, fields, ErrTokenInvalid
+	}
+	if v.Issuer != "" && payload.Issuer != v.Issuer {
+		return &payload, fields, ErrTokenInvalid
+	}
+	if len(v.Audience) > 0 {
+		var matched bool
+		for _, aud := range v.Audience {
+			if payload.Audience.Contains(aud) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &payload, fields, ErrTokenInvalid
+		}
+	}
+	return &payload, fields, nil
+}
+
+func (m *Manager) validateJWT(ctx context.Context, tokenString string) (string, *Token, Claims, error) {
+	payload, claims, err := m.parseJWT(ctx, tokenString)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if payload.Subject == "" || payload.ClientID == "" {
+		return "", nil, nil, ErrTokenInvalid
+	}
+	if err = checkTenant(ctx, payload.TenantID); err != nil {
+		return "", nil, nil, err
+	}
+	key := storageKey(payload.TenantID, payload.Subject)
+	tokens, err := m.getTokens(ctx, key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	// the session must still exist, a token of a revoked session is rejected even if the session is recreated
+	token := tokens.Get(payload.ClientID)
+	if token == nil || token.SessionId != payload.SessionID {
+		return "", nil, nil, ErrTokenInvalid
+	}
+	if m.outlived(token) {
+		return "", nil, nil, ErrTokenExpired
+	}
+	if err = m.touch(ctx, key, token); err != nil {
+		return "", nil, nil, err
+	}
+	return payload.Subject, token, actorClaims(claims, token), nil
+}
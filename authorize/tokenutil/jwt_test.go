@@ -0,0 +1,77 @@
+package tokenutil
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_JWT(t *testing.T) {
+	ctx := context.Background()
+	for _, alg := range []string{HS256, RS256, ES256, EdDSA} {
+		key, err := GenerateSigningKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager := NewManager(WithJWT(key), WithIssuer("contrib"), WithAudience("api"))
+		token, err := manager.GenToken(ctx, "1", "1", Claims{"foo": "bar"})
+		if err != nil {
+			t.Fatal(alg, err)
+		}
+		texts := strings.Split(token, ".")
+		var header map[string]string
+		b, _ := jwtEncoding.DecodeString(texts[0])
+		json.Unmarshal(b, &header)
+		if header["alg"] != alg || header["kid"] != key.ID {
+			t.Errorf("%s: unexpected header %v", alg, header)
+		}
+		claims, err := manager.ValidateToken(ctx, token)
+		if err != nil {
+			t.Errorf("%s: %v", alg, err)
+			continue
+		}
+		if claims["foo"] != "bar" || claims["sub"] != "" {
+			t.Errorf("%s: unexpected claims %v", alg, claims)
+		}
+
+		texts[1] = jwtEncoding.EncodeToString([]byte(`{"sub":"2","cid":"1","foo":"bar"}`))
+		if _, err := manager.ValidateToken(ctx, strings.Join(texts, ".")); err != ErrTokenInvalid {
+			t.Errorf("%s: tampered token: %v", alg, err)
+		}
+	}
+}
+
+func TestManager_JWTValidation(t *testing.T) {
+	ctx := context.Background()
+	oldKey, _ := GenerateSigningKey(ES256)
+	newKey, _ := GenerateSigningKey(EdDSA)
+	storage := NewMemoryStorage()
+
+	issuer := NewManager(WithStorage(storage), WithJWT(oldKey), WithMaxAge(time.Second), WithAudience("api"))
+	token, _ := issuer.GenToken(ctx, "1", "1", nil)
+
+	// tokens signed by the old key are still accepted by kid after the new key becomes active
+	validator := NewManager(WithStorage(storage), WithJWT(newKey, oldKey), WithAudience("web", "api"))
+	if _, err := validator.ValidateToken(ctx, token); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewManager(WithStorage(storage), WithJWT(newKey)).ValidateToken(ctx, token); err != ErrTokenInvalid {
+		t.Errorf("unknown kid: %v", err)
+	}
+	if _, err := NewManager(WithStorage(storage), WithJWT(oldKey), WithAudience("web")).ValidateToken(ctx, token); err != ErrTokenInvalid {
+		t.Errorf("audience mismatch: %v", err)
+	}
+	if _, err := NewManager(WithStorage(storage), WithJWT(oldKey), WithIssuer("contrib")).ValidateToken(ctx, token); err != ErrTokenInvalid {
+		t.Errorf("issuer mismatch: %v", err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	if _, err := issuer.ValidateToken(ctx, token); err != ErrTokenExpired {
+		t.Errorf("expired token: %v", err)
+	}
+	if _, err := NewManager(WithStorage(storage), WithJWT(oldKey), WithMaxAge(time.Second), WithLeeway(time.Minute)).ValidateToken(ctx, token); err != nil {
+		t.Errorf("leeway: %v", err)
+	}
+}
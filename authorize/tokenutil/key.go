@@ -0,0 +1,178 @@
+package tokenutil
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// SigningKey is a JWT signing key identified by its kid.
+// Key is a []byte secret for HS256, *rsa.PrivateKey for RS256, *ecdsa.PrivateKey (P-256) for ES256
+// or ed25519.PrivateKey for EdDSA, a key with a nil Key and a PublicKey can only verify tokens.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       any
+	PublicKey crypto.PublicKey
+}
+
+// NewSigningKey creates a signing key, the public key is derived from the private key.
+func NewSigningKey(kid, alg string, key any) (*SigningKey, error) {
+	k := &SigningKey{ID: kid, Algorithm: alg, Key: key}
+	switch v := key.(type) {
+	case []byte:
+		if alg != HS256 {
+			return nil, fmt.Errorf("tokenutil: %s key used for %s", "hmac", alg)
+		}
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return nil, fmt.Errorf("tokenutil: %s key used for %s", "rsa", alg)
+		}
+		k.PublicKey = &v.PublicKey
+	case *ecdsa.PrivateKey:
+		if alg != ES256 || v.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("tokenutil: %s key used for %s", "ecdsa", alg)
+		}
+		k.PublicKey = &v.PublicKey
+	case ed25519.PrivateKey:
+		if alg != EdDSA {
+			return nil, fmt.Errorf("tokenutil: %s key used for %s", "ed25519", alg)
+		}
+		k.PublicKey = v.Public()
+	default:
+		return nil, fmt.Errorf("tokenutil: unsupported key type %T", key)
+	}
+	return k, nil
+}
+
+// GenerateSigningKey generates a random key for the algorithm with a random kid.
+func GenerateSigningKey(alg string) (*SigningKey, error) {
+	var key any
+	var err error
+	switch alg {
+	case HS256:
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		key = b
+	case RS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("tokenutil: unsupported algorithm %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(randString(16), alg, key)
+}
+
+func (k *SigningKey) sign(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+	switch key := k.Key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, input), nil
+	}
+	return nil, fmt.Errorf("tokenutil: key %s can not sign", k.ID)
+}
+
+func (k *SigningKey) verify(input, signature []byte) bool {
+	digest := sha256.Sum256(input)
+	switch k.Algorithm {
+	case HS256:
+		key, ok := k.Key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		key, ok := k.PublicKey.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ES256:
+		key, ok := k.PublicKey.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	case EdDSA:
+		key, ok := k.PublicKey.(ed25519.PublicKey)
+		return ok && ed25519.Verify(key, input, signature)
+	}
+	return false
+}
+
+// KeyProvider provides the key to sign new tokens and looks up keys by kid to validate tokens.
+type KeyProvider interface {
+	SigningKey(ctx context.Context) (*SigningKey, error)
+	VerificationKey(ctx context.Context, kid string) (*SigningKey, error)
+}
+
+type staticKeys struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// StaticKeys signs with the first key and validates with any of the keys.
+func StaticKeys(keys ...*SigningKey) KeyProvider {
+	s := &staticKeys{keys: make(map[string]*SigningKey)}
+	for _, key := range keys {
+		if s.active == nil {
+			s.active = key
+		}
+		s.keys[key.ID] = key
+	}
+	return s
+}
+
+func (s *staticKeys) SigningKey(ctx context.Context) (*SigningKey, error) {
+	if s.active == nil {
+		return nil, ErrKeyNotFound
+	}
+	return s.active, nil
+}
+
+func (s *staticKeys) VerificationKey(ctx context.Context, kid string) (*SigningKey, error) {
+	if kid == "" && len(s.keys) == 1 {
+		return s.active, nil
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
@@ -0,0 +1,383 @@
+package tokenutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type KeySetOption func(ks *KeySet)
+
+func applyKeySetOptions(ks *KeySet, options []KeySetOption) {
+	for _, option := range options {
+		option(ks)
+	}
+}
+
+// WithKeyAlgorithm set the algorithm of generated keys, default is ES256.
+func WithKeyAlgorithm(alg string) KeySetOption {
+	return func(ks *KeySet) {
+		ks.algorithm = alg
+	}
+}
+
+// WithRotation set how long a key signs tokens before a new key is generated, default is 30 days.
+func WithRotation(rotation time.Duration) KeySetOption {
+	return func(ks *KeySet) {
+		ks.rotation = rotation
+	}
+}
+
+// WithRetention set how long a retired key is kept to validate tokens, it should not be shorter than the
+// max age of access tokens, default is 7 days.
+func WithRetention(retention time.Duration) KeySetOption {
+	return func(ks *KeySet) {
+		ks.retention = retention
+	}
+}
+
+// WithKeySetStorageKey set the storage key of the key set, default is "tokenutil:keyset".
+func WithKeySetStorageKey(key string) KeySetOption {
+	return func(ks *KeySet) {
+		ks.storageKey = key
+	}
+}
+
+// WithReloadInterval set how often keys are reloaded from storage to pick up rotations of other instances,
+// default is 1 minute.
+func WithReloadInterval(interval time.Duration) KeySetOption {
+	return func(ks *KeySet) {
+		ks.reloadInterval = interval
+	}
+}
+
+type keyRecord struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Key       []byte `json:"key"` // hmac secret or PKCS #8 private key
+	CreatedAt int64  `json:"createdAt"`
+	RetiredAt int64  `json:"retiredAt,omitempty"`
+}
+
+type keyEntry struct {
+	*SigningKey
+	createdAt time.Time
+	retiredAt time.Time
+}
+
+// forcedReloadInterval limits the reloads of tokens signed by unknown keys.
+const forcedReloadInterval = time.Second
+
+// KeySet is a KeyProvider holding one active and several retired signing keys persisted in Storage.
+// The active key is rotated once it is older than the rotation interval, retired keys keep validating
+// tokens until the retention period is over. Instances sharing the storage rotate atomically if it is an
+// AtomicStorage, else only the rotations within the process are serialized.
+type KeySet struct {
+	storage        Storage
+	storageKey     string
+	algorithm      string
+	rotation       time.Duration
+	retention      time.Duration
+	reloadInterval time.Duration
+
+	mu       sync.RWMutex
+	keys     []*keyEntry // the last one is active
+	loadedAt time.Time
+}
+
+var _ KeyProvider = (*KeySet)(nil)
+
+func NewKeySet(storage Storage, options ...KeySetOption) *KeySet {
+	ks := &KeySet{
+		storage:        storage,
+		storageKey:     "tokenutil:keyset",
+		algorithm:      ES256,
+		rotation:       30 * 24 * time.Hour,
+		retention:      7 * 24 * time.Hour,
+		reloadInterval: time.Minute,
+	}
+	applyKeySetOptions(ks, options)
+	return ks
+}
+
+// SigningKey returns the active key, a new key is generated if there is none or it is due for rotation.
+func (ks *KeySet) SigningKey(ctx context.Context) (*SigningKey, error) {
+	if err := ks.reload(ctx, false); err != nil {
+		return nil, err
+	}
+	ks.mu.RLock()
+	active := ks.active()
+	ks.mu.RUnlock()
+	if active != nil && time.Since(active.createdAt) < ks.rotation {
+		return active.SigningKey, nil
+	}
+	if err := ks.rotate(ctx, false); err != nil {
+		return nil, err
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.active().SigningKey, nil
+}
+
+// VerificationKey returns the active or a retired key which is still within its retention period.
+func (ks *KeySet) VerificationKey(ctx context.Context, kid string) (*SigningKey, error) {
+	if err := ks.reload(ctx, false); err != nil {
+		return nil, err
+	}
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	// the key may have been rotated by another instance, see forcedReloadInterval
+	if err := ks.reload(ctx, true); err != nil {
+		return nil, err
+	}
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Rotate generates a new active key and retires the current one.
+func (ks *KeySet) Rotate(ctx context.Context) error {
+	return ks.rotate(ctx, true)
+}
+
+func (ks *KeySet) rotate(ctx context.Context, force bool) error {
+	key, err := GenerateSigningKey(ks.algorithm)
+	if err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	var keys []*keyEntry
+	update := func(data []byte) ([]byte, error) {
+		var err error
+		if keys, err = decodeKeys(data); err != nil {
+			return nil, err
+		}
+		// another caller or instance rotated meanwhile
+		if active := lastKey(keys); !force && active != nil && time.Since(active.createdAt) < ks.rotation {
+			return data, nil
+		}
+		now := time.Now()
+		var rotated []*keyEntry
+		for _, entry := range keys {
+			if entry.retiredAt.IsZero() {
+				entry.retiredAt = now
+			}
+			if now.Sub(entry.retiredAt) < ks.retention {
+				rotated = append(rotated, entry)
+			}
+		}
+		keys = append(rotated, &keyEntry{SigningKey: key, createdAt: now})
+		return encodeKeys(keys)
+	}
+	if storage, ok := ks.storage.(AtomicStorage); ok {
+		err = storage.Update(ctx, ks.storageKey, update, 0)
+	} else {
+		err = updateStorage(ctx, ks.storage, ks.storageKey, update, 0)
+	}
+	if err != nil {
+		return err
+	}
+	ks.keys, ks.loadedAt = keys, time.Now()
+	return nil
+}
+
+// Keys returns the active and the retired keys which are still valid.
+func (ks *KeySet) Keys(ctx context.Context) ([]*SigningKey, error) {
+	if err := ks.reload(ctx, false); err != nil {
+		return nil, err
+	}
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var keys []*SigningKey
+	for _, entry := range ks.keys {
+		if ks.valid(entry) {
+			keys = append(keys, entry.SigningKey)
+		}
+	}
+	return keys, nil
+}
+
+// JWKSHandler serves the public keys as a JSON Web Key Set, hmac keys are never published.
+func (ks *KeySet) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		keys, err := ks.Keys(request.Context())
+		if err != nil {
+			http.Error(writer, "system error", http.StatusInternalServerError)
+			return
+		}
+		jwks := JWKS{Keys: []JWK{}}
+		for _, key := range keys {
+			if jwk, ok := NewJWK(key); ok {
+				jwks.Keys = append(jwks.Keys, jwk)
+			}
+		}
+		writer.Header().Set("Content-Type", "application/jwk-set+json")
+		writer.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(writer).Encode(jwks)
+	})
+}
+
+func (ks *KeySet) active() *keyEntry {
+	return lastKey(ks.keys)
+}
+
+func lastKey(keys []*keyEntry) *keyEntry {
+	if len(keys) == 0 {
+		return nil
+	}
+	return keys[len(keys)-1]
+}
+
+func (ks *KeySet) valid(entry *keyEntry) bool {
+	return entry.retiredAt.IsZero() || time.Since(entry.retiredAt) < ks.retention
+}
+
+func (ks *KeySet) lookup(kid string) *SigningKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, entry := range ks.keys {
+		if (entry.ID == kid || kid == "" && len(ks.keys) == 1) && ks.valid(entry) {
+			return entry.SigningKey
+		}
+	}
+	return nil
+}
+
+// reload loads the keys if they are older than the reload interval, forced reloads are limited by
+// forcedReloadInterval.
+func (ks *KeySet) reload(ctx context.Context, force bool) error {
+	interval := ks.reloadInterval
+	if force && interval > forcedReloadInterval {
+		interval = forcedReloadInterval
+	}
+	fresh := func() bool {
+		return !ks.loadedAt.IsZero() && time.Since(ks.loadedAt) < interval
+	}
+	ks.mu.RLock()
+	skip := fresh()
+	ks.mu.RUnlock()
+	if skip {
+		return nil
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	// concurrent callers reload once
+	if fresh() {
+		return nil
+	}
+	return ks.load(ctx)
+}
+
+func (ks *KeySet) load(ctx context.Context) error {
+	data, err := ks.storage.Get(ctx, ks.storageKey)
+	if err != nil {
+		return err
+	}
+	keys, err := decodeKeys(data)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	ks.loadedAt = time.Now()
+	return nil
+}
+
+func decodeKeys(data []byte) ([]*keyEntry, error) {
+	var records []keyRecord
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	}
+	keys := make([]*keyEntry, 0, len(records))
+	for _, record := range records {
+		var key any = record.Key
+		if record.Algorithm != HS256 {
+			var err error
+			if key, err = x509.ParsePKCS8PrivateKey(record.Key); err != nil {
+				return nil, err
+			}
+		}
+		signingKey, err := NewSigningKey(record.ID, record.Algorithm, key)
+		if err != nil {
+			return nil, err
+		}
+		entry := &keyEntry{SigningKey: signingKey, createdAt: time.UnixMilli(record.CreatedAt)}
+		if record.RetiredAt > 0 {
+			entry.retiredAt = time.UnixMilli(record.RetiredAt)
+		}
+		keys = append(keys, entry)
+	}
+	return keys, nil
+}
+
+func encodeKeys(keys []*keyEntry) ([]byte, error) {
+	records := make([]keyRecord, 0, len(keys))
+	for _, entry := range keys {
+		record := keyRecord{ID: entry.ID, Algorithm: entry.Algorithm, CreatedAt: entry.createdAt.UnixMilli()}
+		if !entry.retiredAt.IsZero() {
+			record.RetiredAt = entry.retiredAt.UnixMilli()
+		}
+		if secret, ok := entry.Key.([]byte); ok {
+			record.Key = secret
+		} else {
+			der, err := x509.MarshalPKCS8PrivateKey(entry.Key)
+			if err != nil {
+				return nil, err
+			}
+			record.Key = der
+		}
+		records = append(records, record)
+	}
+	return json.Marshal(records)
+}
+
+// JWK is a public JSON Web Key as defined by RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK returns the public JWK of the key, ok is false for hmac keys.
+func NewJWK(key *SigningKey) (jwk JWK, ok bool) {
+	jwk = JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+	switch pub := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = jwtEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = jwtEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = "P-256"
+		jwk.X = jwtEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+		jwk.Y = jwtEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = jwtEncoding.EncodeToString(pub)
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
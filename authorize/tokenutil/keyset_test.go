@@ -0,0 +1,141 @@
+package tokenutil
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/kv"
+)
+
+func TestKeySet(t *testing.T) {
+	ctx := context.Background()
+	storage := NewMemoryStorage()
+	ks := NewKeySet(storage, WithKeyAlgorithm(RS256), WithRetention(time.Hour))
+	manager := NewManager(WithStorage(storage), WithKeyProvider(ks))
+
+	token, err := manager.GenToken(ctx, "1", "1", Claims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ks.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.ValidateToken(ctx, token); err != nil {
+		t.Errorf("token signed by retired key: %v", err)
+	}
+
+	// another instance sharing the storage loads the persisted keys
+	other := NewManager(WithStorage(storage), WithKeyProvider(NewKeySet(storage)))
+	if _, err = other.ValidateToken(ctx, token); err != nil {
+		t.Errorf("token validated by another instance: %v", err)
+	}
+
+	keys, _ := ks.Keys(ctx)
+	if len(keys) != 2 {
+		t.Fatalf("unexpected key count: %d", len(keys))
+	}
+	active, _ := ks.SigningKey(ctx)
+	if active.ID != keys[1].ID {
+		t.Errorf("the newest key is not active")
+	}
+
+	response := httptest.NewRecorder()
+	ks.JWKSHandler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	var jwks JWKS
+	if err = json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyType != "RSA" || jwks.Keys[0].N == "" {
+		t.Errorf("unexpected jwks: %+v", jwks)
+	}
+}
+
+func TestKeySet_Retention(t *testing.T) {
+	ctx := context.Background()
+	ks := NewKeySet(NewMemoryStorage(), WithRotation(time.Millisecond), WithRetention(time.Millisecond))
+	first, err := ks.SigningKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	second, _ := ks.SigningKey(ctx)
+	if first.ID == second.ID {
+		t.Errorf("key is not rotated")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err = ks.VerificationKey(ctx, first.ID); err != ErrKeyNotFound {
+		t.Errorf("expired key is still valid: %v", err)
+	}
+}
+
+// slowStorage widens the window between reading and writing the key set.
+type slowStorage struct {
+	*kv.MemoryStorage
+}
+
+func (s slowStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.MemoryStorage.Get(ctx, key)
+	time.Sleep(time.Millisecond)
+	return val, err
+}
+
+func TestKeySet_ConcurrentRotate(t *testing.T) {
+	ctx := context.Background()
+	memory, _ := kv.NewMemory(kv.MemoryConfig{})
+	defer memory.Close()
+	storage := NewKVStorage(slowStorage{memory})
+	instances := make([]*KeySet, 4)
+	for i := range instances {
+		instances[i] = NewKeySet(storage, WithKeyAlgorithm(HS256))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(ks *KeySet) {
+			defer wg.Done()
+			if err := ks.Rotate(ctx); err != nil {
+				t.Error(err)
+			}
+		}(instances[i%len(instances)])
+	}
+	wg.Wait()
+	if keys, _ := NewKeySet(storage).Keys(ctx); len(keys) != 8 {
+		t.Errorf("rotated keys lost: %d", len(keys))
+	}
+}
+
+type countingStorage struct {
+	Storage
+	mu   sync.Mutex
+	gets int
+}
+
+func (s *countingStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.Storage.Get(ctx, key)
+}
+
+func TestKeySet_UnknownKey(t *testing.T) {
+	ctx := context.Background()
+	storage := &countingStorage{Storage: NewMemoryStorage()}
+	ks := NewKeySet(storage)
+	if _, err := ks.SigningKey(ctx); err != nil {
+		t.Fatal(err)
+	}
+	gets := storage.gets
+	for i := 0; i < 10; i++ {
+		if _, err := ks.VerificationKey(ctx, "unknown"); err != ErrKeyNotFound {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if storage.gets-gets > 1 {
+		t.Errorf("unknown keys reloaded %d times", storage.gets-gets)
+	}
+}
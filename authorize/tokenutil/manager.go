@@ -3,11 +3,15 @@ package tokenutil
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
 )
 
 type Option func(m *Manager)
@@ -32,20 +36,108 @@ func WithMaxTokenPerUser(max int) Option {
 	}
 }
 
+// WithMaxAge set the max age of access tokens, default is 1 hour. Sessions are kept alive by refreshing, see
+// WithRefreshMaxAge.
 func WithMaxAge(max time.Duration) Option {
 	return func(m *Manager) {
 		m.maxAge = max
 	}
 }
 
+// WithRefreshMaxAge set the max age of refresh tokens counting from the last rotation, default is 30 days.
+func WithRefreshMaxAge(max time.Duration) Option {
+	return func(m *Manager) {
+		m.refreshMaxAge = max
+	}
+}
+
+// WithJWT issue access tokens as JWT signed by the given keys instead of the default md5 signed format,
+// the first key signs new tokens and all keys are accepted by kid.
+func WithJWT(keys ...*SigningKey) Option {
+	return WithKeyProvider(StaticKeys(keys...))
+}
+
+// WithKeyProvider issue access tokens as JWT signed by keys of the provider.
+func WithKeyProvider(provider KeyProvider) Option {
+	return func(m *Manager) {
+		m.keys = provider
+	}
+}
+
+// WithIssuer set the iss claim of JWT access tokens, validated tokens must carry the same issuer.
+func WithIssuer(issuer string) Option {
+	return func(m *Manager) {
+		m.issuer = issuer
+	}
+}
+
+// WithAudience set the aud claim of JWT access tokens, validated tokens must carry one of the audiences.
+func WithAudience(audience ...string) Option {
+	return func(m *Manager) {
+		m.audience = audience
+	}
+}
+
+// WithLeeway set the tolerated clock skew when checking exp and nbf of JWT access tokens.
+func WithLeeway(leeway time.Duration) Option {
+	return func(m *Manager) {
+		m.leeway = leeway
+	}
+}
+
+// WithSlidingExpiration extend the max age of access tokens on every use, the last activity is saved at most once
+// per throttle interval. JWT access tokens keep their exp claim, only the activity is tracked for them.
+func WithSlidingExpiration(throttle time.Duration) Option {
+	return func(m *Manager) {
+		m.sliding = true
+		m.slidingThrottle = throttle
+	}
+}
+
+// WithMaxLifetime set the absolute lifetime of a session counting from the last login of the client, neither sliding
+// expiration nor refreshing extends a session beyond it, a new login starts a new lifetime. Default is 0 which means
+// no limit.
+func WithMaxLifetime(max time.Duration) Option {
+	return func(m *Manager) {
+		m.maxLifetime = max
+	}
+}
+
+// WithEventBus publish token issued, validated and revoked events to the bus.
+func WithEventBus(bus *eventutil.Bus) Option {
+	return func(m *Manager) {
+		m.events = bus
+	}
+}
+
 type Manager struct {
 	storage         Storage
 	maxTokenPerUser int
 	maxAge          time.Duration
+	refreshMaxAge   time.Duration
+	keys            KeyProvider
+	issuer          string
+	audience        Audience
+	leeway          time.Duration
+	sliding         bool
+	slidingThrottle time.Duration
+	maxLifetime     time.Duration
+	events          *eventutil.Bus
+	binding         *BindingConfig
+	impersonation   *ImpersonationConfig
+	locks           keyLocker
+	now             func() time.Time
+}
+
+// TokenPair is a short-lived access token and the long-lived refresh token used to rotate it.
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"` // seconds until the access token expires
 }
 
 func NewManager(options ...Option) *Manager {
-	m := &Manager{maxTokenPerUser: 10, maxAge: time.Hour * 24 * 7}
+	m := &Manager{maxTokenPerUser: 10, maxAge: time.Hour, refreshMaxAge: time.Hour * 24 * 30, now: time.Now}
 	applyOptions(m, options)
 	if m.storage == nil {
 		m.storage = NewMemoryStorage()
@@ -53,89 +145,498 @@ func NewManager(options ...Option) *Manager {
 	return m
 }
 
+// RefreshMaxAge returns the max age of refresh tokens.
+func (m *Manager) RefreshMaxAge() time.Duration {
+	return m.refreshMaxAge
+}
+
 func (m *Manager) GenToken(ctx context.Context, userId string, clientId string, claims Claims) (string, error) {
-	tokens, err := m.getTokens(userId)
+	pair, err := m.GenTokenPair(ctx, userId, clientId, claims)
 	if err != nil {
 		return "", err
 	}
+	return pair.AccessToken, nil
+}
+
+// GenTokenPair generates an access token and a refresh token for the client, previous tokens of the client
+// are replaced. The login restarts the session of the client, its IssuedAt and max lifetime count from now.
+func (m *Manager) GenTokenPair(ctx context.Context, userId string, clientId string, claims Claims) (*TokenPair, error) {
+	tenant := TenantFromContext(ctx)
 	var token *Token
-	if token = tokens.Get(clientId); token == nil {
-		token = &Token{ClientId: clientId, Secret: randString(16)}
+	err := m.update(ctx, storageKey(tenant, userId), func(tokens Tokens) error {
+		// an impersonation session is replaced by a new session of the user
+		if token = tokens.Get(clientId); token == nil || token.Actor != "" {
+			token = &Token{ClientId: clientId, TenantId: tenant, Secret: randString(16)}
+		}
+		if token.SessionId == "" {
+			token.SessionId = randString(16)
+		}
+		token.Timestamp = m.now().UnixMilli()
+		token.IssuedAt = token.Timestamp
+		token.LastSeen = token.Timestamp
+		token.Generation++
+		token.Claims = claims
+		token.Binding = bindingFromContext(ctx)
+		tokens.Set(token, m.maxTokenPerUser)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	token.Timestamp = time.Now().UnixMilli()
-	tokens.Set(token, m.maxTokenPerUser)
-	if err = m.storage.Set(ctx, userId, tokens.JSON(), 7*24*time.Hour); err != nil {
-		return "", err
+	m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenIssued, UserID: userId, ClientID: clientId, Metadata: map[string]string{"grant": "login"}})
+	return m.issue(ctx, userId, token)
+}
+
+// Refresh exchanges a refresh token for a new token pair, the refresh token is rotated and can not be used again.
+// Legacy access tokens issued before are rejected, JWT access tokens stay valid until their exp.
+// Presenting an already rotated refresh token revokes the whole client session and returns ErrTokenReused.
+// The binding of the session is enforced against the binding of the request carried by ctx, see WithBinding, a
+// bound session can not be refreshed without it.
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	head, err := parseRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	var token *Token
+	binding := bindingFromContext(ctx)
+	err = m.update(ctx, head.key(), func(tokens Tokens) error {
+		if token, err = m.checkRefreshToken(tokens, head); err != nil {
+			return err
+		}
+		if m.enforcesBinding(token) && (token.Binding == nil || !token.Binding.matches(binding)) {
+			return ErrBindingMismatch
+		}
+		token.Timestamp = m.now().UnixMilli()
+		token.LastSeen = token.Timestamp
+		token.Generation++
+		return nil
+	})
+	if err == ErrTokenReused {
+		return nil, m.revokeReused(ctx, head)
 	}
+	if err == ErrBindingMismatch {
+		return nil, m.checkBinding(ctx, head.uid, token, binding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenIssued, UserID: head.uid, ClientID: token.ClientId, Metadata: map[string]string{"grant": "refresh"}})
+	return m.issue(ctx, head.uid, token)
+}
 
-	head := Claims{}
-	head["uid"] = userId
-	head["cid"] = clientId
-	head["nonce"] = randString(8)
-	head["timestamp"] = strconv.FormatInt(token.Timestamp, 10)
+// ValidateRefreshToken validates the refresh token without rotating it and returns the session it belongs to.
+// It has no side effects, an already rotated refresh token is reported as ErrTokenReused but only Refresh revokes
+// its session.
+func (m *Manager) ValidateRefreshToken(ctx context.Context, refreshToken string) (*Session, error) {
+	head, err := parseRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := m.getTokens(ctx, head.key())
+	if err != nil {
+		return nil, err
+	}
+	token, err := m.checkRefreshToken(tokens, head)
+	if err != nil {
+		return nil, err
+	}
+	return token.session(head.uid), nil
+}
 
-	text := head.Encode() + claims.Encode() + token.Secret
-	signature := toMd5([]byte(text))
-	tokenString := fmt.Sprintf("%s.%s.%s", head.String(), claims.String(), signature)
+// refreshHead is the signed head of a refresh token.
+type refreshHead struct {
+	uid, cid, tid string
+	generation    int64
+	text          string // the encoded head the signature is computed of
+	signature     string
+}
 
-	return tokenString, nil
+func (h *refreshHead) key() string {
+	return storageKey(h.tid, h.uid)
 }
 
+func parseRefreshToken(ctx context.Context, refreshToken string) (*refreshHead, error) {
+	var head = Claims{}
+	var texts = strings.Split(refreshToken, ".")
+	if len(texts) != 2 {
+		return nil, ErrTokenInvalid
+	}
+	if err := head.Decode(texts[0]); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	generation, err := strconv.ParseInt(head["gen"], 10, 64)
+	if head["uid"] == "" || head["cid"] == "" || err != nil {
+		return nil, ErrTokenInvalid
+	}
+	if err = checkTenant(ctx, head["tid"]); err != nil {
+		return nil, err
+	}
+	return &refreshHead{
+		uid:        head["uid"],
+		cid:        head["cid"],
+		tid:        head["tid"],
+		generation: generation,
+		text:       head.Encode(),
+		signature:  texts[1],
+	}, nil
+}
+
+// checkRefreshToken returns the token the refresh token belongs to, ErrTokenReused is returned for a refresh token
+// of an earlier generation.
+func (m *Manager) checkRefreshToken(tokens Tokens, head *refreshHead) (*Token, error) {
+	token := tokens.Get(head.cid)
+	if token == nil {
+		return nil, ErrTokenInvalid
+	}
+	if toMd5([]byte(head.text+token.Secret)) != head.signature {
+		return nil, ErrTokenInvalid
+	}
+	if head.generation < token.Generation {
+		return nil, ErrTokenReused
+	}
+	if head.generation != token.Generation {
+		return nil, ErrTokenInvalid
+	}
+	if m.now().UnixMilli() > token.Timestamp+int64(m.refreshMaxAge/time.Millisecond) || m.outlived(token) {
+		return nil, ErrTokenExpired
+	}
+	return token, nil
+}
+
+// revokeReused removes the session of a reused refresh token and returns ErrTokenReused.
+func (m *Manager) revokeReused(ctx context.Context, head *refreshHead) error {
+	err := m.update(ctx, head.key(), func(tokens Tokens) error {
+		if _, err := m.checkRefreshToken(tokens, head); err != ErrTokenReused {
+			return errUnchanged
+		}
+		tokens.Remove(head.cid)
+		return nil
+	})
+	if err == errUnchanged {
+		// revoked concurrently
+		return ErrTokenReused
+	}
+	if err != nil {
+		return err
+	}
+	m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenRevoked, UserID: head.uid, ClientID: head.cid, Reason: "reuse"})
+	return ErrTokenReused
+}
+
+func (m *Manager) issue(ctx context.Context, userId string, token *Token) (*TokenPair, error) {
+	var accessToken string
+	if m.keys != nil {
+		var err error
+		if accessToken, err = m.signJWT(ctx, userId, token); err != nil {
+			return nil, err
+		}
+	} else {
+		head := Claims{}
+		head["uid"] = userId
+		head["cid"] = token.ClientId
+		if token.TenantId != "" {
+			head["tid"] = token.TenantId
+		}
+		head["gen"] = strconv.FormatInt(token.Generation, 10)
+		head["nonce"] = randString(8)
+		head["timestamp"] = strconv.FormatInt(token.Timestamp, 10)
+
+		text := head.Encode() + token.Claims.Encode() + token.Secret
+		signature := toMd5([]byte(text))
+		accessToken = fmt.Sprintf("%s.%s.%s", head.String(), token.Claims.String(), signature)
+	}
+
+	refreshHead := Claims{}
+	refreshHead["typ"] = "refresh"
+	refreshHead["uid"] = userId
+	refreshHead["cid"] = token.ClientId
+	if token.TenantId != "" {
+		refreshHead["tid"] = token.TenantId
+	}
+	refreshHead["gen"] = strconv.FormatInt(token.Generation, 10)
+	refreshHead["nonce"] = randString(8)
+	refreshToken := fmt.Sprintf("%s.%s", refreshHead.String(), toMd5([]byte(refreshHead.Encode()+token.Secret)))
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(m.maxAge / time.Second),
+	}, nil
+}
+
+// ValidateToken validates the token and returns its claims, tokens of another tenant than the one of ctx are
+// rejected with ErrTenantMismatch.
 func (m *Manager) ValidateToken(ctx context.Context, tokenString string) (Claims, error) {
+	_, _, claims, err := m.validate(ctx, tokenString)
+	return claims, err
+}
+
+// ValidateSession validates the token like ValidateToken and returns the session it belongs to. The binding of the
+// session is not enforced, see ValidateHTTPSession and ValidateBoundSession.
+func (m *Manager) ValidateSession(ctx context.Context, tokenString string) (*Session, Claims, error) {
+	uid, token, claims, err := m.validate(ctx, tokenString)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token.session(uid), claims, nil
+}
+
+func (m *Manager) validate(ctx context.Context, tokenString string) (string, *Token, Claims, error) {
+	uid, token, claims, err := m.validateToken(ctx, tokenString)
+	if err == nil {
+		event := &eventutil.Event{Type: eventutil.TokenValidated, UserID: uid, ClientID: token.ClientId}
+		if token.Actor != "" {
+			event.Metadata = map[string]string{"actor": token.Actor}
+		}
+		m.events.Publish(ctx, event)
+	}
+	return uid, token, claims, err
+}
+
+func (m *Manager) validateToken(ctx context.Context, tokenString string) (string, *Token, Claims, error) {
+	if m.keys != nil {
+		return m.validateJWT(ctx, tokenString)
+	}
+	head, claims, token, err := m.parseToken(ctx, tokenString)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if m.now().After(m.expiresAt(token)) {
+		return "", nil, nil, ErrTokenExpired
+	}
+	if err = m.touch(ctx, storageKey(head["tid"], head["uid"]), token); err != nil {
+		return "", nil, nil, err
+	}
+	return head["uid"], token, actorClaims(claims, token), nil
+}
+
+// parseToken verifies the signature of a legacy access token and returns its head, claims and the stored token.
+func (m *Manager) parseToken(ctx context.Context, tokenString string) (Claims, Claims, *Token, error) {
 	var head, claims = Claims{}, Claims{}
 	var texts = strings.Split(tokenString, ".")
 	if len(texts) != 3 {
-		return nil, ErrTokenInvalid
+		return nil, nil, nil, ErrTokenInvalid
 	}
 	if err := head.Decode(texts[0]); err != nil {
-		return nil, err
+		return nil, nil, nil, ErrTokenInvalid
 	}
 	if err := claims.Decode(texts[1]); err != nil {
-		return nil, err
+		return nil, nil, nil, ErrTokenInvalid
 	}
 	uid := head["uid"]
 	cid := head["cid"]
 	if uid == "" || cid == "" {
-		return nil, ErrTokenInvalid
+		return nil, nil, nil, ErrTokenInvalid
+	}
+	if err := checkTenant(ctx, head["tid"]); err != nil {
+		return nil, nil, nil, err
 	}
-	tokens, err := m.getTokens(uid)
+	tokens, err := m.getTokens(ctx, storageKey(head["tid"], uid))
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	token := tokens.Get(cid)
 	if token == nil {
-		return nil, ErrTokenInvalid
-	}
-	if time.Now().UnixMilli() > token.Timestamp+int64(m.maxAge/time.Millisecond) {
-		return nil, ErrTokenExpired
+		return nil, nil, nil, ErrTokenInvalid
 	}
 	var signature = toMd5([]byte(head.Encode() + claims.Encode() + token.Secret))
 	if signature != texts[2] {
-		return nil, ErrTokenInvalid
+		return nil, nil, nil, ErrTokenInvalid
+	}
+	// tokens of an earlier generation were replaced by refreshing, tokens without generation were issued before
+	// it was recorded and are accepted until the session is refreshed
+	if gen := head["gen"]; gen != "" && gen != strconv.FormatInt(token.Generation, 10) || gen == "" && token.Generation > 1 {
+		return nil, nil, nil, ErrTokenInvalid
+	}
+	return head, claims, token, nil
+}
+
+// expiresAt returns the expiry of the legacy access tokens of the session.
+func (m *Manager) expiresAt(token *Token) time.Time {
+	var lastUse = token.Timestamp
+	if m.sliding && token.LastSeen > lastUse {
+		lastUse = token.LastSeen
+	}
+	expiresAt := lastUse + int64(m.maxAge/time.Millisecond)
+	if m.maxLifetime > 0 {
+		issuedAt := token.IssuedAt
+		if issuedAt == 0 {
+			issuedAt = token.Timestamp
+		}
+		if lifetime := issuedAt + int64(m.maxLifetime/time.Millisecond); lifetime < expiresAt {
+			expiresAt = lifetime
+		}
+	}
+	if token.ExpiresAt > 0 && token.ExpiresAt < expiresAt {
+		expiresAt = token.ExpiresAt
+	}
+	return time.UnixMilli(expiresAt)
+}
+
+// outlived reports whether the session is older than the max lifetime or has expired, see Token.ExpiresAt.
+func (m *Manager) outlived(token *Token) bool {
+	if token.ExpiresAt > 0 && m.now().UnixMilli() > token.ExpiresAt {
+		return true
+	}
+	if m.maxLifetime <= 0 {
+		return false
+	}
+	issuedAt := token.IssuedAt
+	if issuedAt == 0 {
+		issuedAt = token.Timestamp
+	}
+	return m.now().UnixMilli() > issuedAt+int64(m.maxLifetime/time.Millisecond)
+}
+
+// touch records the activity of the session if sliding expiration is enabled and the throttle interval is over.
+func (m *Manager) touch(ctx context.Context, key string, token *Token) error {
+	now := m.now().UnixMilli()
+	throttle := int64(m.slidingThrottle / time.Millisecond)
+	if !m.sliding || now-token.LastSeen < throttle {
+		return nil
 	}
-	return claims, nil
+	err := m.update(ctx, key, func(tokens Tokens) error {
+		current := tokens.Get(token.ClientId)
+		if current == nil || current.SessionId != token.SessionId || now-current.LastSeen < throttle {
+			return errUnchanged
+		}
+		current.LastSeen = now
+		return nil
+	})
+	if err == errUnchanged {
+		return nil
+	}
+	return err
 }
 
+// ValidateHTTPRequest validates the token of the Authorization header and enforces its binding, see
+// WithTokenBinding.
 func (m *Manager) ValidateHTTPRequest(request *http.Request) (Claims, error) {
-	var tokenString = request.Header.Get("Authorization")
+	var tokenString = TokenFromHTTPRequest(request)
 	if len(tokenString) == 0 {
 		return nil, ErrTokenInvalid
 	}
-	if strings.ToLower(tokenString[:7]) == "bearer " {
+	_, claims, err := m.ValidateHTTPSession(request, tokenString)
+	return claims, err
+}
+
+// TokenFromHTTPRequest returns the token of the Authorization header, the Bearer or DPoP scheme is optional.
+func TokenFromHTTPRequest(request *http.Request) string {
+	var tokenString = request.Header.Get("Authorization")
+	if len(tokenString) > 7 && strings.ToLower(tokenString[:7]) == "bearer " {
 		tokenString = tokenString[7:]
+	} else if len(tokenString) > 5 && strings.ToLower(tokenString[:5]) == "dpop " {
+		tokenString = tokenString[5:]
 	}
-	return m.ValidateToken(request.Context(), tokenString)
+	return tokenString
 }
 
-func (m *Manager) getTokens(uid string) (Tokens, error) {
-	data, err := m.storage.Get(context.Background(), uid)
+// Revoke removes the session of the client, its access and refresh tokens are rejected afterwards.
+// The user is looked up in the tenant of ctx, see WithTenant.
+func (m *Manager) Revoke(ctx context.Context, userId string, clientId string) error {
+	err := m.update(ctx, storageKey(TenantFromContext(ctx), userId), func(tokens Tokens) error {
+		if tokens.Get(clientId) == nil {
+			return errUnchanged
+		}
+		tokens.Remove(clientId)
+		return nil
+	})
+	if err == errUnchanged {
+		return nil
+	}
+	if err == nil {
+		m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenRevoked, UserID: userId, ClientID: clientId, Reason: "revoke"})
+	}
+	return err
+}
+
+// RevokeAll removes all sessions of the user. The sessions are removed by an update like any other change, a
+// concurrent login or refresh does not write them back.
+func (m *Manager) RevokeAll(ctx context.Context, userId string) error {
+	err := m.update(ctx, storageKey(TenantFromContext(ctx), userId), func(tokens Tokens) error {
+		for clientId := range tokens {
+			tokens.Remove(clientId)
+		}
+		return nil
+	})
 	if err != nil {
-		return Tokens{}, err
+		return err
 	}
-	if len(data) == 0 {
-		return Tokens{}, nil
+	m.events.Publish(ctx, &eventutil.Event{Type: eventutil.TokenRevoked, UserID: userId, Reason: "revoke_all"})
+	return nil
+}
+
+// Sessions returns the sessions of the user which can still be refreshed, ordered by issue time.
+func (m *Manager) Sessions(ctx context.Context, userId string) ([]*Session, error) {
+	tokens, err := m.getTokens(ctx, storageKey(TenantFromContext(ctx), userId))
+	if err != nil {
+		return nil, err
 	}
+	var sessions []*Session
+	var now = m.now().UnixMilli()
+	for _, token := range tokens {
+		if now > token.Timestamp+int64(m.refreshMaxAge/time.Millisecond) && now > token.Timestamp+int64(m.maxAge/time.Millisecond) {
+			continue
+		}
+		sessions = append(sessions, token.session(userId))
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].IssuedAt.Equal(sessions[j].IssuedAt) {
+			return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+		}
+		return sessions[i].ClientId < sessions[j].ClientId
+	})
+	return sessions, nil
+}
+
+// errUnchanged aborts an update without writing, it is returned by update.
+var errUnchanged = errors.New("tokenutil: unchanged")
+
+// update applies fn to the tokens stored at key atomically, fn may be called again if the tokens were changed
+// concurrently. The key is deleted when no token is left.
+func (m *Manager) update(ctx context.Context, key string, fn func(tokens Tokens) error) error {
+	apply := func(data []byte) ([]byte, error) {
+		tokens, err := decodeTokens(data)
+		if err != nil {
+			return nil, err
+		}
+		if err = fn(tokens); err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			return nil, nil
+		}
+		return tokens.JSON(), nil
+	}
+	if storage, ok := m.storage.(AtomicStorage); ok {
+		return storage.Update(ctx, key, apply, m.expiration())
+	}
+	unlock := m.locks.lock(key)
+	defer unlock()
+	return updateStorage(ctx, m.storage, key, apply, m.expiration())
+}
+
+// expiration returns the lifetime of stored tokens, they are kept as long as an access or refresh token is valid.
+func (m *Manager) expiration() time.Duration {
+	if m.refreshMaxAge > m.maxAge {
+		return m.refreshMaxAge
+	}
+	return m.maxAge
+}
+
+func (m *Manager) getTokens(ctx context.Context, key string) (Tokens, error) {
+	data, err := m.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTokens(data)
+}
+
+func decodeTokens(data []byte) (Tokens, error) {
 	var tokens = Tokens{}
-	err = json.Unmarshal(data, &tokens)
+	if len(data) == 0 {
+		return tokens, nil
+	}
+	err := json.Unmarshal(data, &tokens)
 	return tokens, err
 }
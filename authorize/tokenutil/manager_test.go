@@ -3,6 +3,7 @@ package tokenutil
 import (
 	"context"
 	"testing"
+	"time"
 )
 
 func TestNewManager(t *testing.T) {
@@ -27,4 +28,126 @@ func TestNewManager(t *testing.T) {
 	if claims["foo"] != "bar" {
 		t.Errorf("claims is not equal")
 	}
+	for _, token := range []string{"a!.b.c", "e30.a!.c", "a!.b"} {
+		if _, err = manager.ValidateToken(context.Background(), token); err != ErrTokenInvalid {
+			t.Errorf("malformed token %s: %v", token, err)
+		}
+	}
+	if _, err = manager.Refresh(context.Background(), "a!.b"); err != ErrTokenInvalid {
+		t.Errorf("malformed refresh token: %v", err)
+	}
+}
+
+func TestManager_Refresh(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager()
+	pair, err := manager.GenTokenPair(ctx, "1", "1", Claims{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := manager.Refresh(ctx, pair.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := manager.ValidateToken(ctx, rotated.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["foo"] != "bar" {
+		t.Errorf("claims is not equal")
+	}
+	if _, err = manager.ValidateToken(ctx, pair.AccessToken); err != ErrTokenInvalid {
+		t.Errorf("access token of the rotated pair is valid: %v", err)
+	}
+	if pair.ExpiresIn != int64(time.Hour/time.Second) {
+		t.Errorf("unexpected default max age: %d", pair.ExpiresIn)
+	}
+
+	// reusing the rotated refresh token revokes the session
+	if _, err = manager.Refresh(ctx, pair.RefreshToken); err != ErrTokenReused {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = manager.Refresh(ctx, rotated.RefreshToken); err != ErrTokenInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err = manager.ValidateToken(ctx, rotated.AccessToken); err != ErrTokenInvalid {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestManager_Revoke(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager()
+	web, _ := manager.GenToken(ctx, "1", "web", nil)
+	app, _ := manager.GenToken(ctx, "1", "app", nil)
+	manager.GenToken(ctx, "1", "cli", nil)
+
+	sessions, err := manager.Sessions(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 || sessions[0].UserId != "1" || sessions[0].IssuedAt.IsZero() {
+		t.Errorf("unexpected sessions: %v", sessions)
+	}
+
+	if err = manager.Revoke(ctx, "1", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.ValidateToken(ctx, web); err != ErrTokenInvalid {
+		t.Errorf("revoked token: %v", err)
+	}
+	if _, err = manager.ValidateToken(ctx, app); err != nil {
+		t.Errorf("token of another client: %v", err)
+	}
+
+	if err = manager.RevokeAll(ctx, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.ValidateToken(ctx, app); err != ErrTokenInvalid {
+		t.Errorf("revoked token: %v", err)
+	}
+	if sessions, _ = manager.Sessions(ctx, "1"); len(sessions) != 0 {
+		t.Errorf("unexpected sessions: %v", sessions)
+	}
+}
+
+func TestManager_SlidingExpiration(t *testing.T) {
+	ctx := context.Background()
+	manager := NewManager(WithMaxAge(200*time.Millisecond), WithSlidingExpiration(0), WithMaxLifetime(time.Second))
+	now := time.Now()
+	manager.now = func() time.Time { return now }
+	token, _ := manager.GenToken(ctx, "1", "1", nil)
+	for i := 0; i < 4; i++ {
+		now = now.Add(100 * time.Millisecond)
+		if _, err := manager.ValidateToken(ctx, token); err != nil {
+			t.Fatalf("active token expired: %v", err)
+		}
+	}
+	sessions, _ := manager.Sessions(ctx, "1")
+	if len(sessions) != 1 || sessions[0].LastSeen.Sub(sessions[0].IssuedAt) != 400*time.Millisecond {
+		t.Errorf("last activity is not recorded: %v", sessions)
+	}
+
+	now = now.Add(300 * time.Millisecond)
+	if _, err := manager.ValidateToken(ctx, token); err != ErrTokenExpired {
+		t.Errorf("idle token: %v", err)
+	}
+
+	token, _ = manager.GenToken(ctx, "1", "1", nil)
+	for i := 0; i < 7; i++ {
+		now = now.Add(150 * time.Millisecond)
+		manager.ValidateToken(ctx, token)
+	}
+	if _, err := manager.ValidateToken(ctx, token); err != ErrTokenExpired {
+		t.Errorf("token beyond max lifetime: %v", err)
+	}
+
+	// a new login restarts the lifetime of the session
+	token, _ = manager.GenToken(ctx, "1", "1", nil)
+	if _, err := manager.ValidateToken(ctx, token); err != nil {
+		t.Errorf("token of a new login: %v", err)
+	}
+	if sessions, _ = manager.Sessions(ctx, "1"); len(sessions) != 1 || !sessions[0].IssuedAt.Equal(now.Truncate(time.Millisecond)) {
+		t.Errorf("issue time is not reset: %v", sessions)
+	}
 }
@@ -0,0 +1,94 @@
+package tokenutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-chocolate/contrib/kv"
+)
+
+// maxSwapAttempts bounds the retries of a compare and swap update under contention.
+const maxSwapAttempts = 32
+
+type kvStorage struct {
+	storage kv.Storage
+	cas     kv.CompareAndSwapper
+	locks   keyLocker
+}
+
+// NewKVStorage adapts a kv.Storage, e.g. the memory or redis driver, keys expire with the refresh tokens.
+// Updates are atomic across processes if the storage is a kv.CompareAndSwapper, else they are serialized within
+// the process.
+func NewKVStorage(storage kv.Storage) Storage {
+	s := &kvStorage{storage: storage}
+	s.cas, _ = storage.(kv.CompareAndSwapper)
+	return s
+}
+
+var (
+	_ Storage       = (*kvStorage)(nil)
+	_ AtomicStorage = (*kvStorage)(nil)
+)
+
+func (s *kvStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.storage.Get(ctx, key)
+	if errors.Is(err, kv.ErrNotFound) {
+		return nil, nil
+	}
+	return val, err
+}
+
+func (s *kvStorage) Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error {
+	return s.storage.Set(ctx, key, val, expiration...)
+}
+
+func (s *kvStorage) Del(ctx context.Context, keys ...string) error {
+	return s.storage.Del(ctx, keys...)
+}
+
+func (s *kvStorage) Update(ctx context.Context, key string, update func(val []byte) ([]byte, error), expiration time.Duration) error {
+	if s.cas == nil {
+		unlock := s.locks.lock(key)
+		defer unlock()
+		return updateStorage(ctx, s, key, update, expiration)
+	}
+	for i := 0; i < maxSwapAttempts; i++ {
+		old, err := s.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		val, err := update(old)
+		if err != nil {
+			return err
+		}
+		if val == nil && old == nil {
+			return nil
+		}
+		ok, err := s.cas.CompareAndSwap(ctx, key, old, val, expiration)
+		if err != nil || ok {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrConflict, key)
+}
+
+// updateStorage applies update by a read and a write, the caller has to prevent concurrent updates.
+func updateStorage(ctx context.Context, storage Storage, key string, update func(val []byte) ([]byte, error), expiration time.Duration) error {
+	old, err := storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	val, err := update(old)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		return storage.Del(ctx, key)
+	}
+	return storage.Set(ctx, key, val, expiration)
+}
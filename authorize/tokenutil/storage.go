@@ -2,37 +2,134 @@ package tokenutil
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
+// Storage keeps the tokens of the users, Get returns nil without error for missing keys.
 type Storage interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error
 	Del(ctx context.Context, keys ...string) error
 }
 
+// AtomicStorage is an optional Storage extension updating a key atomically, the Manager uses it for all changes
+// of the tokens of a user. Other storages are updated under a lock of the Manager, which only serializes the
+// updates of one process.
+type AtomicStorage interface {
+	// Update replaces the value of key by the result of update, a nil result deletes the key. Update may be
+	// called more than once if the value changed concurrently, an error of update aborts the update.
+	Update(ctx context.Context, key string, update func(val []byte) ([]byte, error), expiration time.Duration) error
+}
+
+type memoryItem struct {
+	data      []byte
+	expiresAt time.Time
+}
+
 type memoryStorage struct {
-	data map[string][]byte
+	mu   sync.Mutex
+	data map[string]*memoryItem
 }
 
-var _ Storage = (*memoryStorage)(nil)
+var (
+	_ Storage       = (*memoryStorage)(nil)
+	_ AtomicStorage = (*memoryStorage)(nil)
+)
 
+// NewMemoryStorage returns a Storage keeping the tokens in the process, expired keys are removed when accessed.
 func NewMemoryStorage() Storage {
-	return &memoryStorage{data: make(map[string][]byte)}
+	return &memoryStorage{data: make(map[string]*memoryItem)}
 }
 
 func (s *memoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
-	return s.data[key], nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(key), nil
 }
 
 func (s *memoryStorage) Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error {
-	s.data[key] = val
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, val, expiration...)
 	return nil
 }
 
 func (s *memoryStorage) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, key := range keys {
 		delete(s.data, key)
 	}
 	return nil
 }
+
+func (s *memoryStorage) Update(ctx context.Context, key string, update func(val []byte) ([]byte, error), expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	val, err := update(s.get(key))
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		delete(s.data, key)
+	} else {
+		s.set(key, val, expiration)
+	}
+	return nil
+}
+
+func (s *memoryStorage) get(key string) []byte {
+	item, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !item.expiresAt.IsZero() && !time.Now().Before(item.expiresAt) {
+		delete(s.data, key)
+		return nil
+	}
+	return item.data
+}
+
+func (s *memoryStorage) set(key string, val []byte, expiration ...time.Duration) {
+	item := &memoryItem{data: val}
+	if len(expiration) > 0 && expiration[0] > 0 {
+		item.expiresAt = time.Now().Add(expiration[0])
+	}
+	s.data[key] = item
+}
+
+// keyLocker serializes the updates of a key within the process.
+type keyLocker struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *keyLocker) lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,163 @@
+package tokenutil
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/kv"
+)
+
+// plainStorage hides the AtomicStorage implementation of the memory storage.
+type plainStorage struct {
+	Storage
+}
+
+func testStorages() map[string]func() Storage {
+	return map[string]func() Storage{
+		"memory": NewMemoryStorage,
+		"kv":     func() Storage { return NewKVStorage(kv.MustNew(kv.Config{Driver: kv.MEMORY})) },
+		"plain":  func() Storage { return plainStorage{NewMemoryStorage()} },
+	}
+}
+
+func TestManager_ConcurrentGenTokenPair(t *testing.T) {
+	for name, storage := range testStorages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager := NewManager(WithStorage(storage()), WithMaxTokenPerUser(100))
+			var wg sync.WaitGroup
+			pairs := make([]*TokenPair, 20)
+			for i := range pairs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					pair, err := manager.GenTokenPair(ctx, "1", strconv.Itoa(i), nil)
+					if err != nil {
+						t.Error(err)
+					}
+					pairs[i] = pair
+				}(i)
+			}
+			wg.Wait()
+			for i, pair := range pairs {
+				if pair == nil {
+					continue
+				}
+				if _, err := manager.ValidateToken(ctx, pair.AccessToken); err != nil {
+					t.Errorf("token of client %d lost: %v", i, err)
+				}
+			}
+			if sessions, _ := manager.Sessions(ctx, "1"); len(sessions) != len(pairs) {
+				t.Errorf("unexpected sessions: %d", len(sessions))
+			}
+		})
+	}
+}
+
+func TestManager_ConcurrentRefresh(t *testing.T) {
+	for name, storage := range testStorages() {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager := NewManager(WithStorage(storage()))
+			pair, err := manager.GenTokenPair(ctx, "1", "1", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var succeeded int
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := manager.Refresh(ctx, pair.RefreshToken); err == nil {
+						mu.Lock()
+						succeeded++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			if succeeded != 1 {
+				t.Errorf("refresh token used %d times", succeeded)
+			}
+		})
+	}
+}
+
+func TestKVStorage_Expiration(t *testing.T) {
+	ctx := context.Background()
+	storage := NewKVStorage(kv.MustNew(kv.Config{Driver: kv.MEMORY}))
+	if val, err := storage.Get(ctx, "missing"); val != nil || err != nil {
+		t.Errorf("unexpected missing key: %s %v", val, err)
+	}
+	manager := NewManager(WithStorage(storage), WithMaxAge(50*time.Millisecond), WithRefreshMaxAge(50*time.Millisecond))
+	if _, err := manager.GenTokenPair(ctx, "1", "1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := storage.Get(ctx, storageKey("", "1")); val == nil {
+		t.Fatal("tokens not stored")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if val, _ := storage.Get(ctx, storageKey("", "1")); val != nil {
+		t.Errorf("tokens not expired: %s", val)
+	}
+}
+
+// pausingStorage blocks the first read after it is armed until release is closed.
+type pausingStorage struct {
+	plainStorage
+	armed   bool
+	once    sync.Once
+	paused  chan struct{}
+	release chan struct{}
+}
+
+func (s *pausingStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	val, err := s.plainStorage.Get(ctx, key)
+	if s.armed {
+		s.once.Do(func() {
+			close(s.paused)
+			<-s.release
+		})
+	}
+	return val, err
+}
+
+func TestManager_RevokeAllDuringRefresh(t *testing.T) {
+	ctx := context.Background()
+	storage := &pausingStorage{plainStorage: plainStorage{NewMemoryStorage()}, paused: make(chan struct{}), release: make(chan struct{})}
+	manager := NewManager(WithStorage(storage))
+	pair, err := manager.GenTokenPair(ctx, "1", "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.armed = true
+	refreshed := make(chan error)
+	go func() {
+		_, err := manager.Refresh(ctx, pair.RefreshToken)
+		refreshed <- err
+	}()
+	<-storage.paused
+	revoked := make(chan error, 1)
+	go func() { revoked <- manager.RevokeAll(ctx, "1") }()
+	// the revocation waits for the refresh holding the tokens
+	select {
+	case <-revoked:
+		t.Fatal("revoked during refresh")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(storage.release)
+	if err = <-refreshed; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-revoked; err != nil {
+		t.Fatal(err)
+	}
+	if sessions, _ := manager.Sessions(ctx, "1"); len(sessions) != 0 {
+		t.Errorf("sessions written back after revocation: %v", sessions)
+	}
+}
@@ -0,0 +1,40 @@
+package tokenutil
+
+import (
+	"context"
+	"net/url"
+)
+
+type tenantContextKey struct{}
+
+var _tenantContextKey = &tenantContextKey{}
+
+// WithTenant scopes the Manager calls made with ctx to the tenant. Tokens are stored per tenant and user, issued
+// tokens carry the tenant as tid and are only valid for a ctx of the same tenant, a ctx without tenant accepts
+// tokens of all tenants.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, _tenantContextKey, tenant)
+}
+
+// TenantFromContext returns the tenant of ctx, or an empty string if there is none.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(_tenantContextKey).(string)
+	return tenant
+}
+
+// storageKey returns the key of the tokens of the user. Both parts are escaped and users without tenant have
+// their own namespace, so no user id can produce the key of a user of a tenant.
+func storageKey(tenant, userId string) string {
+	if tenant == "" {
+		return "user/" + url.PathEscape(userId)
+	}
+	return "tenant/" + url.PathEscape(tenant) + "/" + url.PathEscape(userId)
+}
+
+// checkTenant reports ErrTenantMismatch if ctx is scoped to another tenant than the token.
+func checkTenant(ctx context.Context, tenant string) error {
+	if expected := TenantFromContext(ctx); expected != "" && expected != tenant {
+		return ErrTenantMismatch
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package tokenutil
+
+import (
+	"context"
+	"testing"
+)
+
+func TestManager_Tenant(t *testing.T) {
+	key, err := GenerateSigningKey(HS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, manager := range map[string]*Manager{"legacy": NewManager(), "jwt": NewManager(WithJWT(key))} {
+		acme := WithTenant(context.Background(), "acme")
+		globex := WithTenant(context.Background(), "globex")
+		acmePair, err := manager.GenTokenPair(acme, "1", "web", Claims{"org": "acme"})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		globexPair, err := manager.GenTokenPair(globex, "1", "web", Claims{"org": "globex"})
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		if claims, err := manager.ValidateToken(acme, acmePair.AccessToken); err != nil || claims.Get("org") != "acme" {
+			t.Errorf("%s: unexpected claims %v %v", name, claims, err)
+		}
+		if _, err = manager.ValidateToken(globex, acmePair.AccessToken); err != ErrTenantMismatch {
+			t.Errorf("%s: token accepted by another tenant: %v", name, err)
+		}
+		session, claims, err := manager.ValidateSession(context.Background(), globexPair.AccessToken)
+		if err != nil || session.TenantId != "globex" || claims.Get("org") != "globex" {
+			t.Errorf("%s: unexpected session %+v %v %v", name, session, claims, err)
+		}
+		if _, err = manager.Refresh(acme, globexPair.RefreshToken); err != ErrTenantMismatch {
+			t.Errorf("%s: refreshed in another tenant: %v", name, err)
+		}
+
+		if err = manager.RevokeAll(acme, "1"); err != nil {
+			t.Fatal(name, err)
+		}
+		if _, err = manager.ValidateToken(acme, acmePair.AccessToken); err != ErrTokenInvalid {
+			t.Errorf("%s: revoked token is valid: %v", name, err)
+		}
+		if _, err = manager.ValidateToken(globex, globexPair.AccessToken); err != nil {
+			t.Errorf("%s: revoked the user of another tenant: %v", name, err)
+		}
+		if sessions, _ := manager.Sessions(globex, "1"); len(sessions) != 1 {
+			t.Errorf("%s: unexpected sessions %v", name, sessions)
+		}
+	}
+}
+
+func TestStorageKey(t *testing.T) {
+	keys := map[string][2]string{}
+	for _, c := range [][2]string{{"", "acme/1"}, {"acme", "1"}, {"", "tenant/acme/1"}, {"acme/1", ""}, {"acme", "1/"}, {"", "1"}} {
+		key := storageKey(c[0], c[1])
+		if other, ok := keys[key]; ok {
+			t.Errorf("%q and %q share the key %s", c, other, key)
+		}
+		keys[key] = c
+	}
+
+	manager := NewManager()
+	acme := WithTenant(context.Background(), "acme")
+	pair, err := manager.GenTokenPair(acme, "1", "web", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.RevokeAll(context.Background(), "acme/1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = manager.ValidateToken(acme, pair.AccessToken); err != nil {
+		t.Errorf("revoked the user of a tenant by a global user id: %v", err)
+	}
+}
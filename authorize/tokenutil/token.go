@@ -1,13 +1,71 @@
 package tokenutil
 
 import (
+	"context"
 	"encoding/json"
+	"time"
 )
 
 type Token struct {
-	ClientId  string `json:"clientId"`
-	Secret    string `json:"secret"`
-	Timestamp int64  `json:"timestamp"`
+	ClientId   string   `json:"clientId"`
+	TenantId   string   `json:"tenantId,omitempty"`
+	SessionId  string   `json:"sessionId,omitempty"`
+	Secret     string   `json:"secret"`
+	Timestamp  int64    `json:"timestamp"`
+	IssuedAt   int64    `json:"issuedAt,omitempty"` // the time of the last login, reset by every login but not by refreshing
+	LastSeen   int64    `json:"lastSeen,omitempty"`
+	Generation int64    `json:"generation"` // incremented on every refresh token rotation
+	Claims     Claims   `json:"claims,omitempty"`
+	Binding    *Binding `json:"binding,omitempty"`   // the request attributes the session is bound to
+	Actor      string   `json:"actor,omitempty"`     // the user impersonating the owner of the session
+	ExpiresAt  int64    `json:"expiresAt,omitempty"` // fixed expiry of the session, e.g. of impersonation sessions
+}
+
+func (t *Token) session(userId string) *Session {
+	session := &Session{
+		UserId:   userId,
+		ClientId: t.ClientId,
+		TenantId: t.TenantId,
+		IssuedAt: time.UnixMilli(t.IssuedAt),
+		LastSeen: time.UnixMilli(t.LastSeen),
+		Actor:    t.Actor,
+	}
+	// tokens stored before sessions were tracked
+	if t.IssuedAt == 0 {
+		session.IssuedAt = time.UnixMilli(t.Timestamp)
+	}
+	if t.LastSeen == 0 {
+		session.LastSeen = time.UnixMilli(t.Timestamp)
+	}
+	return session
+}
+
+// Session is a client the user is signed in with.
+type Session struct {
+	UserId   string    `json:"userId"`
+	ClientId string    `json:"clientId"`
+	TenantId string    `json:"tenantId,omitempty"`
+	IssuedAt time.Time `json:"issuedAt"`
+	LastSeen time.Time `json:"lastSeen"`
+	Actor    string    `json:"actor,omitempty"` // the user impersonating the owner of the session, see Manager.Impersonate
+}
+
+type sessionContextKey struct{}
+
+var _sessionContextKey = &sessionContextKey{}
+
+// WithContext attaches the session to ctx, ctx is scoped to the tenant of the session if it has one.
+func (s *Session) WithContext(ctx context.Context) context.Context {
+	if s.TenantId != "" {
+		ctx = WithTenant(ctx, s.TenantId)
+	}
+	return context.WithValue(ctx, _sessionContextKey, s)
+}
+
+// SessionFromContext returns the session of the validated token, or nil if there is none.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(_sessionContextKey).(*Session)
+	return s
 }
 
 type Tokens map[string]*Token
@@ -2,20 +2,18 @@ package tokenutil
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
-	"math/rand"
-)
-
-const (
-	seed = "abcdef0123456789"
 )
 
+// randString returns n random hex characters, it is used for secrets, session ids and key ids.
 func randString(n int) string {
-	var r = make([]byte, n)
-	for i := 0; i < n; i++ {
-		r[i] = seed[rand.Intn(len(seed))]
+	b := make([]byte, (n+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		// a predictable secret is worse than failing
+		panic(err)
 	}
-	return string(r)
+	return hex.EncodeToString(b)[:n]
 }
 
 func toMd5(data []byte) string {
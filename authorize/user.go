@@ -2,6 +2,9 @@ package authorize
 
 import (
 	"context"
+	"sync"
+
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
 )
 
 type User interface {
@@ -16,12 +19,29 @@ type UserRepository interface {
 	GetByUsername(ctx context.Context, username string) (User, error)
 }
 
+// UserIDRepository is an optional UserRepository extension loading users of the tenant of ctx by id, it is
+// required by AllowImpersonation to verify the target. ErrUserNotFound is returned if there is no such user.
+type UserIDRepository interface {
+	GetByUserID(ctx context.Context, userId string) (User, error)
+}
+
+// PasswordUpdater is an optional UserRepository extension used to save rehashed passwords after login.
+type PasswordUpdater interface {
+	UpdatePassword(ctx context.Context, user User, encoded string) error
+}
+
 type SimpleUser struct {
-	ID       string
-	Username string
-	Secret   string
-	Password string
-	Claims   map[string]string
+	ID            string
+	Tenant        string
+	Username      string
+	Secret        string
+	Password      string
+	Claims        map[string]string
+	Roles         []string
+	Permissions   []string
+	MFASecret     string
+	RecoveryCodes []string
+	ExternalIDs   map[string]string // OpenID Connect issuer to the subject linked to the user
 }
 
 func (u *SimpleUser) GetID() string                { return u.ID }
@@ -29,19 +49,98 @@ func (u *SimpleUser) GetSecret() string            { return u.Secret }
 func (u *SimpleUser) GetUsername() string          { return u.Username }
 func (u *SimpleUser) GetPassword() string          { return u.Password }
 func (u *SimpleUser) GetClaims() map[string]string { return u.Claims }
+func (u *SimpleUser) GetRoles() []string           { return u.Roles }
+func (u *SimpleUser) GetPermissions() []string     { return u.Permissions }
+func (u *SimpleUser) GetMFASecret() string         { return u.MFASecret }
+func (u *SimpleUser) GetRecoveryCodes() []string   { return u.RecoveryCodes }
 
+// SimpleUserRepository is an in-memory UserRepository safe for concurrent use, users are returned as copies.
+// Users are kept per tenant, updates apply to the user of the tenant of ctx.
 type SimpleUserRepository struct {
-	users map[string]*SimpleUser
+	mu    sync.RWMutex
+	users map[simpleUserKey]*SimpleUser
 }
 
+type simpleUserKey struct {
+	tenant   string
+	username string
+}
+
+var (
+	_ TenantUserRepository       = (*SimpleUserRepository)(nil)
+	_ UserIDRepository           = (*SimpleUserRepository)(nil)
+	_ ExternalIdentityRepository = (*SimpleUserRepository)(nil)
+)
+
 func NewSimpleUserRepository() *SimpleUserRepository {
-	return &SimpleUserRepository{users: make(map[string]*SimpleUser)}
+	return &SimpleUserRepository{users: make(map[simpleUserKey]*SimpleUser)}
 }
 
 func (rep *SimpleUserRepository) GetByUsername(ctx context.Context, username string) (User, error) {
-	return rep.users[username], nil
+	return rep.GetByTenantUsername(ctx, "", username)
+}
+
+func (rep *SimpleUserRepository) GetByTenantUsername(ctx context.Context, tenant, username string) (User, error) {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	u, ok := rep.users[simpleUserKey{tenant, username}]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	v := *u
+	return &v, nil
+}
+
+func (rep *SimpleUserRepository) GetByUserID(ctx context.Context, userId string) (User, error) {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	tenant := tokenutil.TenantFromContext(ctx)
+	for key, u := range rep.users {
+		if key.tenant == tenant && u.ID == userId {
+			v := *u
+			return &v, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (rep *SimpleUserRepository) GetByExternalID(ctx context.Context, issuer, subject string) (User, error) {
+	rep.mu.RLock()
+	defer rep.mu.RUnlock()
+	tenant := tokenutil.TenantFromContext(ctx)
+	for key, u := range rep.users {
+		if key.tenant == tenant && subject != "" && u.ExternalIDs[issuer] == subject {
+			v := *u
+			return &v, nil
+		}
+	}
+	return nil, ErrUserNotFound
 }
 
 func (rep *SimpleUserRepository) Add(u *SimpleUser) {
-	rep.users[u.Username] = u
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.users[simpleUserKey{u.Tenant, u.Username}] = u
+}
+
+func (rep *SimpleUserRepository) UpdatePassword(ctx context.Context, user User, encoded string) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	u, ok := rep.users[simpleUserKey{tokenutil.TenantFromContext(ctx), user.GetUsername()}]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Password = encoded
+	return nil
+}
+
+func (rep *SimpleUserRepository) UpdateRecoveryCodes(ctx context.Context, user User, codes []string) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	u, ok := rep.users[simpleUserKey{tokenutil.TenantFromContext(ctx), user.GetUsername()}]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.RecoveryCodes = codes
+	return nil
 }
@@ -0,0 +1,92 @@
+package authorizeutil
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+	"github.com/go-chocolate/contrib/database/gormutil"
+)
+
+// AuditEvent is a row of the audit table, see eventutil.Event.
+type AuditEvent struct {
+	ID        int64             `gorm:"primaryKey;autoIncrement"`
+	Type      string            `gorm:"size:32;index"`
+	Time      time.Time         `gorm:"index"`
+	UserID    string            `gorm:"size:64;index"`
+	Username  string            `gorm:"size:128;index"`
+	ClientID  string            `gorm:"size:128"`
+	IP        string            `gorm:"size:64;index"`
+	UserAgent string            `gorm:"size:512"`
+	Reason    string            `gorm:"size:64"`
+	Metadata  map[string]string `gorm:"serializer:json"`
+}
+
+func (AuditEvent) TableName() string {
+	return "auth_audit_events"
+}
+
+type AuditOption func(s *AuditSink)
+
+func applyAuditOptions(s *AuditSink, options []AuditOption) {
+	for _, option := range options {
+		option(s)
+	}
+}
+
+// WithErrorHandler set the handler of failed inserts, events are dropped silently by default.
+func WithErrorHandler(handler func(ctx context.Context, event *eventutil.Event, err error)) AuditOption {
+	return func(s *AuditSink) {
+		s.onError = handler
+	}
+}
+
+// AuditSink is an eventutil.Subscriber writing events to the audit table. Subscribe it for the types
+// worth keeping, eventutil.TokenValidated is published on every authenticated request.
+type AuditSink struct {
+	rep     *gormutil.Repository[AuditEvent]
+	onError func(ctx context.Context, event *eventutil.Event, err error)
+}
+
+var _ eventutil.Subscriber = (*AuditSink)(nil)
+
+// NewAuditSink returns a sink writing to db, a nil db is taken from the context, see gormutil.WithContext.
+func NewAuditSink(db *gorm.DB, options ...AuditOption) *AuditSink {
+	s := &AuditSink{rep: gormutil.NewRepository[AuditEvent](db)}
+	applyAuditOptions(s, options)
+	return s
+}
+
+// Migrate creates or updates the audit table.
+func (s *AuditSink) Migrate(ctx context.Context) error {
+	return s.rep.GetDB(ctx).WithContext(ctx).AutoMigrate(&AuditEvent{})
+}
+
+func (s *AuditSink) Handle(ctx context.Context, event *eventutil.Event) {
+	row := &AuditEvent{
+		Type:      string(event.Type),
+		Time:      event.Time,
+		UserID:    event.UserID,
+		Username:  event.Username,
+		ClientID:  event.ClientID,
+		IP:        event.IP,
+		UserAgent: event.UserAgent,
+		Reason:    event.Reason,
+		Metadata:  event.Metadata,
+	}
+	if _, err := s.rep.Insert(ctx, row); err != nil && s.onError != nil {
+		s.onError(ctx, event, err)
+	}
+}
+
+// LoginHistory returns the login events of the user, newest first.
+func (s *AuditSink) LoginHistory(ctx context.Context, userID string, offset, limit int) ([]*AuditEvent, int64, error) {
+	where := clause.And(
+		clause.Eq{Column: "user_id", Value: userID},
+		clause.IN{Column: "type", Values: []any{string(eventutil.LoginSucceeded), string(eventutil.LoginFailed)}},
+	)
+	return s.rep.List(ctx, where, offset, limit, "time DESC", "id DESC")
+}
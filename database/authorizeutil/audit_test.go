@@ -0,0 +1,46 @@
+package authorizeutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-chocolate/contrib/authorize/eventutil"
+	"github.com/go-chocolate/contrib/database/gormutil"
+)
+
+func TestAuditSink(t *testing.T) {
+	ctx := context.Background()
+	db, err := gormutil.Open(gormutil.Config{Driver: gormutil.SQLITE, Option: gormutil.Option{"Database": ":memory:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := NewAuditSink(db, WithErrorHandler(func(ctx context.Context, event *eventutil.Event, err error) {
+		t.Error(err)
+	}))
+	if err = sink.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := eventutil.NewBus()
+	bus.Subscribe(sink, eventutil.LoginSucceeded, eventutil.LoginFailed, eventutil.TokenRevoked)
+	ctx = eventutil.Metadata{IP: "127.0.0.1", UserAgent: "test"}.WithContext(ctx)
+	now := time.Now()
+	bus.Publish(ctx, &eventutil.Event{Type: eventutil.LoginFailed, Time: now, UserID: "1", Username: "test", Reason: "invalid_password"})
+	bus.Publish(ctx, &eventutil.Event{Type: eventutil.LoginSucceeded, Time: now.Add(time.Second), UserID: "1", Username: "test"})
+	bus.Publish(ctx, &eventutil.Event{Type: eventutil.TokenIssued, UserID: "1"})
+	bus.Publish(ctx, &eventutil.Event{Type: eventutil.TokenRevoked, UserID: "1", Metadata: map[string]string{"grant": "login"}})
+	bus.Publish(ctx, &eventutil.Event{Type: eventutil.LoginSucceeded, UserID: "2"})
+
+	history, count, err := sink.LoginHistory(ctx, "1", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || len(history) != 2 {
+		t.Fatalf("unexpected history: %d %d", count, len(history))
+	}
+	if history[0].Type != string(eventutil.LoginSucceeded) || history[1].Reason != "invalid_password" ||
+		history[1].IP != "127.0.0.1" || history[1].UserAgent != "test" {
+		t.Errorf("unexpected history: %+v %+v", history[0], history[1])
+	}
+}
@@ -0,0 +1,248 @@
+package authorizeutil
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/go-chocolate/contrib/authorize"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+type UserServiceConfig struct {
+	Policy   authorize.PasswordPolicy
+	Hasher   authorize.PasswordHasher // default argon2id
+	ResetTTL time.Duration            // lifetime of password reset tokens, default 1h
+	Prefix   string                   // storage key prefix of reset tokens, default "password_reset:"
+	Tokens   *tokenutil.Manager       // if set, tokens of disabled users and after password changes are revoked
+}
+
+func (c *UserServiceConfig) init() {
+	if c.Hasher == nil {
+		c.Hasher = authorize.NewArgon2idHasher()
+	}
+	if c.ResetTTL <= 0 {
+		c.ResetTTL = time.Hour
+	}
+	if c.Prefix == "" {
+		c.Prefix = "password_reset:"
+	}
+}
+
+// UserService manages the accounts of a UserRepository. Reset tokens are kept in the storage, e.g. a kv.Storage.
+// Usernames are those of the tenant of ctx, see tokenutil.WithTenant.
+type UserService struct {
+	rep     *UserRepository
+	storage kv.Storage
+	config  UserServiceConfig
+	mu      sync.Mutex // serializes reset tokens of storages without compare and swap
+}
+
+func NewUserService(rep *UserRepository, storage kv.Storage, config UserServiceConfig) *UserService {
+	config.init()
+	return &UserService{rep: rep, storage: storage, config: config}
+}
+
+// Register creates an enabled user, the password has to pass the policy.
+func (s *UserService) Register(ctx context.Context, username, password string, claims map[string]string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, authorize.ErrInvalidUsername
+	}
+	if err := s.config.Policy.Validate(username, password); err != nil {
+		return nil, err
+	}
+	tenant := tokenutil.TenantFromContext(ctx)
+	if _, err := s.rep.findByUsername(ctx, tenant, username); err == nil {
+		return nil, ErrUsernameTaken
+	} else if !errors.Is(err, authorize.ErrUserNotFound) {
+		return nil, err
+	}
+	encoded, err := s.config.Hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &User{ID: uuid.NewString(), TenantID: tenant, Username: username, Password: encoded, Claims: claims}
+	if err = s.rep.Create(ctx, user); err != nil {
+		// a concurrent registration may have taken the username after the check
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrUsernameTaken
+		} else if _, findErr := s.rep.findByUsername(ctx, tenant, username); findErr == nil {
+			return nil, ErrUsernameTaken
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// ChangePassword replaces the password after verifying the current one.
+func (s *UserService) ChangePassword(ctx context.Context, id, password, newPassword string) error {
+	user, err := s.rep.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ok, err := authorize.VerifyPassword(password, user.Secret, user.Password); err != nil || !ok {
+		return authorize.ErrInvalidPassword
+	}
+	return s.setPassword(ctx, user, newPassword)
+}
+
+// CreateResetToken returns a single use token to set a new password without the current one, it is meant to be
+// sent to the user out of band, e.g. by mail. The token is invalidated by any password change.
+func (s *UserService) CreateResetToken(ctx context.Context, username string) (string, error) {
+	user, err := s.rep.findByUsername(ctx, tokenutil.TenantFromContext(ctx), username)
+	if err != nil {
+		return "", err
+	}
+	if user.Disabled {
+		return "", ErrUserDisabled
+	}
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	data, _ := json.Marshal(resetToken{UserID: user.ID, Fingerprint: fingerprint(user.Password)})
+	if err = s.storage.Set(ctx, s.resetKey(token), data, s.config.ResetTTL); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword sets the password of the user the token was created for, ErrInvalidResetToken is returned for
+// unknown, expired or used tokens. The token is consumed before the password is changed, it is used only once even
+// by concurrent calls.
+func (s *UserService) ResetPassword(ctx context.Context, token, newPassword string) error {
+	key := s.resetKey(token)
+	cas, atomic := s.storage.(kv.CompareAndSwapper)
+	if !atomic {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	data, err := s.storage.Get(ctx, key)
+	var v resetToken
+	if err != nil || len(data) == 0 || json.Unmarshal(data, &v) != nil {
+		return ErrInvalidResetToken
+	}
+	user, err := s.rep.GetByID(ctx, v.UserID)
+	if errors.Is(err, authorize.ErrUserNotFound) {
+		return ErrInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	if user.Disabled || subtle.ConstantTimeCompare([]byte(v.Fingerprint), []byte(fingerprint(user.Password))) != 1 {
+		return ErrInvalidResetToken
+	}
+	// a rejected password does not use up the token
+	if err = s.config.Policy.Validate(user.Username, newPassword); err != nil {
+		return err
+	}
+	if atomic {
+		var swapped bool
+		if swapped, err = cas.CompareAndSwap(ctx, key, data, nil); err != nil {
+			return err
+		} else if !swapped {
+			return ErrInvalidResetToken
+		}
+	} else if err = s.storage.Del(ctx, key); err != nil {
+		return err
+	}
+	return s.setPassword(ctx, user, newPassword)
+}
+
+func (s *UserService) Enable(ctx context.Context, id string) error {
+	return s.rep.update(ctx, id, map[string]any{"disabled": false})
+}
+
+// Disable blocks logins of the user, existing tokens are revoked if a token manager is configured.
+func (s *UserService) Disable(ctx context.Context, id string) error {
+	user, err := s.rep.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = s.rep.update(ctx, id, map[string]any{"disabled": true}); err != nil {
+		return err
+	}
+	return s.revoke(ctx, user)
+}
+
+// SetClaims replaces the claims of the user, they are embedded into tokens issued afterwards.
+func (s *UserService) SetClaims(ctx context.Context, id string, claims map[string]string) error {
+	if claims == nil {
+		claims = map[string]string{}
+	}
+	return s.rep.update(ctx, id, &User{Claims: claims})
+}
+
+func (s *UserService) SetClaim(ctx context.Context, id, key, value string) error {
+	return s.updateClaims(ctx, id, func(claims map[string]string) {
+		claims[key] = value
+	})
+}
+
+func (s *UserService) DeleteClaim(ctx context.Context, id, key string) error {
+	return s.updateClaims(ctx, id, func(claims map[string]string) {
+		delete(claims, key)
+	})
+}
+
+func (s *UserService) updateClaims(ctx context.Context, id string, update func(claims map[string]string)) error {
+	user, err := s.rep.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	claims := map[string]string{}
+	for k, v := range user.Claims {
+		claims[k] = v
+	}
+	update(claims)
+	return s.SetClaims(ctx, id, claims)
+}
+
+func (s *UserService) setPassword(ctx context.Context, user *User, password string) error {
+	if err := s.config.Policy.Validate(user.Username, password); err != nil {
+		return err
+	}
+	encoded, err := s.config.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err = s.rep.UpdatePassword(ctx, user, encoded); err != nil {
+		return err
+	}
+	return s.revoke(ctx, user)
+}
+
+func (s *UserService) revoke(ctx context.Context, user *User) error {
+	if s.config.Tokens == nil {
+		return nil
+	}
+	return s.config.Tokens.RevokeAll(tokenutil.WithTenant(ctx, user.TenantID), user.ID)
+}
+
+// resetKey stores tokens by their hash, so a leaked storage does not reveal usable tokens.
+func (s *UserService) resetKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return s.config.Prefix + hex.EncodeToString(sum[:])
+}
+
+type resetToken struct {
+	UserID      string `json:"uid"`
+	Fingerprint string `json:"fp"`
+}
+
+func fingerprint(encoded string) string {
+	sum := sha256.Sum256([]byte(encoded))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,152 @@
+package authorizeutil
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/go-chocolate/contrib/authorize"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/database/gormutil"
+)
+
+var (
+	ErrUserDisabled      = errors.New("user disabled")
+	ErrUsernameTaken     = errors.New("username taken")
+	ErrInvalidResetToken = errors.New("invalid password reset token")
+)
+
+// User is a row of the user table, it implements authorize.User and the optional role, permission and MFA
+// extensions.
+type User struct {
+	ID            string            `gorm:"primaryKey;size:64"`
+	TenantID      string            `gorm:"size:64;uniqueIndex:idx_auth_users_username"`
+	Username      string            `gorm:"size:128;uniqueIndex:idx_auth_users_username"`
+	Secret        string            `gorm:"size:128"`
+	Password      string            `gorm:"size:256"`
+	Claims        map[string]string `gorm:"serializer:json"`
+	Roles         []string          `gorm:"serializer:json"`
+	Permissions   []string          `gorm:"serializer:json"`
+	MFASecret     string            `gorm:"size:64"`
+	RecoveryCodes []string          `gorm:"serializer:json"`
+	Disabled      bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (User) TableName() string {
+	return "auth_users"
+}
+
+func (u *User) GetID() string                { return u.ID }
+func (u *User) GetSecret() string            { return u.Secret }
+func (u *User) GetUsername() string          { return u.Username }
+func (u *User) GetPassword() string          { return u.Password }
+func (u *User) GetClaims() map[string]string { return u.Claims }
+func (u *User) GetRoles() []string           { return u.Roles }
+func (u *User) GetPermissions() []string     { return u.Permissions }
+func (u *User) GetMFASecret() string         { return u.MFASecret }
+func (u *User) GetRecoveryCodes() []string   { return u.RecoveryCodes }
+
+var (
+	_ authorize.User           = (*User)(nil)
+	_ authorize.RoleUser       = (*User)(nil)
+	_ authorize.PermissionUser = (*User)(nil)
+	_ authorize.MFAUser        = (*User)(nil)
+)
+
+// UserRepository is an authorize.UserRepository on the user table, usernames are unique per tenant.
+type UserRepository struct {
+	rep *gormutil.Repository[User]
+}
+
+var (
+	_ authorize.UserRepository       = (*UserRepository)(nil)
+	_ authorize.TenantUserRepository = (*UserRepository)(nil)
+	_ authorize.UserIDRepository     = (*UserRepository)(nil)
+	_ authorize.PasswordUpdater      = (*UserRepository)(nil)
+	_ authorize.RecoveryCodeUpdater  = (*UserRepository)(nil)
+)
+
+// NewUserRepository returns a repository on db, a nil db is taken from the context, see gormutil.WithContext.
+func NewUserRepository(db *gorm.DB) *UserRepository {
+	return &UserRepository{rep: gormutil.NewRepository[User](db)}
+}
+
+// Migrate creates or updates the user table.
+func (r *UserRepository) Migrate(ctx context.Context) error {
+	return r.rep.GetDB(ctx).WithContext(ctx).AutoMigrate(&User{})
+}
+
+// GetByUsername returns the user without tenant, ErrUserDisabled is returned for disabled users, so they can not
+// log in.
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (authorize.User, error) {
+	return r.GetByTenantUsername(ctx, "", username)
+}
+
+func (r *UserRepository) GetByTenantUsername(ctx context.Context, tenant, username string) (authorize.User, error) {
+	user, err := r.findByUsername(ctx, tenant, username)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return user, nil
+}
+
+// GetByID returns the user including disabled ones, authorize.ErrUserNotFound if there is none.
+func (r *UserRepository) GetByID(ctx context.Context, id string) (*User, error) {
+	return r.find(ctx, clause.Eq{Column: "id", Value: id})
+}
+
+// GetByUserID returns the user of the tenant of ctx, ErrUserDisabled is returned for disabled users.
+func (r *UserRepository) GetByUserID(ctx context.Context, userId string) (authorize.User, error) {
+	user, err := r.find(ctx, clause.And(clause.Eq{Column: "tenant_id", Value: tokenutil.TenantFromContext(ctx)}, clause.Eq{Column: "id", Value: userId}))
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrUserDisabled
+	}
+	return user, nil
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *User) error {
+	_, err := r.rep.Insert(ctx, user)
+	return err
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, user authorize.User, encoded string) error {
+	return r.update(ctx, user.GetID(), map[string]any{"password": encoded})
+}
+
+func (r *UserRepository) UpdateRecoveryCodes(ctx context.Context, user authorize.User, codes []string) error {
+	return r.update(ctx, user.GetID(), &User{RecoveryCodes: codes})
+}
+
+func (r *UserRepository) findByUsername(ctx context.Context, tenant, username string) (*User, error) {
+	return r.find(ctx, clause.And(clause.Eq{Column: "tenant_id", Value: tenant}, clause.Eq{Column: "username", Value: username}))
+}
+
+func (r *UserRepository) find(ctx context.Context, where any) (*User, error) {
+	user, err := r.rep.FindOne(ctx, where)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, authorize.ErrUserNotFound
+	}
+	return user, err
+}
+
+func (r *UserRepository) update(ctx context.Context, id string, update any) error {
+	n, err := r.rep.Update(ctx, clause.Eq{Column: "id", Value: id}, update)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return authorize.ErrUserNotFound
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package authorizeutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"github.com/go-chocolate/contrib/authorize"
+	"github.com/go-chocolate/contrib/authorize/tokenutil"
+	"github.com/go-chocolate/contrib/database/gormutil"
+	"github.com/go-chocolate/contrib/kv"
+)
+
+func TestUserService(t *testing.T) {
+	ctx := context.Background()
+	db, err := gormutil.Open(gormutil.Config{Driver: gormutil.SQLITE, Option: gormutil.Option{"Database": ":memory:"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := NewUserRepository(db)
+	if err = rep.Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m := tokenutil.NewManager()
+	service := NewUserService(rep, tokenutil.NewMemoryStorage(), UserServiceConfig{
+		Policy: authorize.PasswordPolicy{RequireDigit: true},
+		Tokens: m,
+	})
+	auth := authorize.New(rep, m)
+	login := func(password string) error {
+		_, err := auth.Authorize(ctx, &loginRequest{username: "alice", password: password})
+		return err
+	}
+
+	if _, err = service.Register(ctx, "alice", "weak", nil); !errors.Is(err, authorize.ErrWeakPassword) {
+		t.Errorf("weak password accepted: %v", err)
+	}
+	user, err := service.Register(ctx, "alice", "s3cret-pass", map[string]string{"org": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = service.Register(ctx, "alice", "s3cret-pass", nil); err != ErrUsernameTaken {
+		t.Errorf("duplicate username accepted: %v", err)
+	}
+	if _, err = rep.GetByUsername(ctx, "bob"); err != authorize.ErrUserNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err = login("s3cret-pass"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = service.ChangePassword(ctx, user.ID, "wrong", "n3w-password"); err != authorize.ErrInvalidPassword {
+		t.Errorf("changed password without the current one: %v", err)
+	}
+	if err = service.ChangePassword(ctx, user.ID, "s3cret-pass", "n3w-password"); err != nil {
+		t.Fatal(err)
+	}
+	if login("s3cret-pass") == nil || login("n3w-password") != nil {
+		t.Errorf("password not changed")
+	}
+
+	token, err := service.CreateResetToken(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := service.CreateResetToken(ctx, "alice")
+	if err = service.ResetPassword(ctx, token, "r3set-password"); err != nil {
+		t.Fatal(err)
+	}
+	if login("r3set-password") != nil {
+		t.Errorf("password not reset")
+	}
+	for _, token := range []string{token, stale, "unknown"} {
+		if err = service.ResetPassword(ctx, token, "an0ther-password"); err != ErrInvalidResetToken {
+			t.Errorf("reset token reused: %v", err)
+		}
+	}
+
+	if err = service.SetClaim(ctx, user.ID, "tier", "gold"); err != nil {
+		t.Fatal(err)
+	}
+	if err = service.DeleteClaim(ctx, user.ID, "org"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err = rep.GetByID(ctx, user.ID); err != nil || len(user.Claims) != 1 || user.Claims["tier"] != "gold" {
+		t.Errorf("unexpected claims: %v %v", user.Claims, err)
+	}
+	for _, codes := range [][]string{{"a", "b"}, {}} {
+		if err = rep.UpdateRecoveryCodes(ctx, user, codes); err != nil {
+			t.Fatal(err)
+		}
+		if user, _ = rep.GetByID(ctx, user.ID); len(user.RecoveryCodes) != len(codes) {
+			t.Errorf("unexpected recovery codes: %v", user.RecoveryCodes)
+		}
+	}
+
+	pair, err := auth.AuthorizeTokenPair(ctx, &loginRequest{username: "alice", password: "r3set-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = service.Disable(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.ValidateToken(ctx, pair.AccessToken); err == nil {
+		t.Errorf("token of disabled user is valid")
+	}
+	if err = login("r3set-password"); err != authorize.ErrInvalidUsername {
+		t.Errorf("disabled user logged in: %v", err)
+	}
+	if _, err = service.CreateResetToken(ctx, "alice"); err != ErrUserDisabled {
+		t.Errorf("reset token for disabled user: %v", err)
+	}
+	if err = service.Enable(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = login("r3set-password"); err != nil {
+		t.Errorf("enabled user rejected: %v", err)
+	}
+	if err = service.Enable(ctx, "unknown"); err != authorize.ErrUserNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	acme := tokenutil.WithTenant(ctx, "acme")
+	if found, err := rep.GetByUserID(ctx, user.ID); err != nil || found.GetUsername() != "alice" {
+		t.Errorf("unexpected user: %v %v", found, err)
+	}
+	if _, err = rep.GetByUserID(acme, user.ID); err != authorize.ErrUserNotFound {
+		t.Errorf("user found in another tenant: %v", err)
+	}
+	if _, err = service.Register(acme, "alice", "t3nant-pass", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = auth.Authorize(acme, &loginRequest{username: "alice", password: "r3set-password"}); err != authorize.ErrInvalidPassword {
+		t.Errorf("logged in with the password of another tenant: %v", err)
+	}
+	if _, err = auth.Authorize(acme, &loginRequest{username: "alice", password: "t3nant-pass"}); err != nil {
+		t.Errorf("tenant login failed: %v", err)
+	}
+}
+
+// newFileDB opens a sqlite database shared by all connections of the pool, unlike an in-memory one.
+func newFileDB(t *testing.T) (*gorm.DB, *UserRepository) {
+	db, err := gormutil.Open(gormutil.Config{Driver: gormutil.SQLITE, Option: gormutil.Option{"Database": filepath.Join(t.TempDir(), "users.db")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerDB, _ := db.DB()
+	t.Cleanup(func() { innerDB.Close() })
+	rep := NewUserRepository(db)
+	if err = rep.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return db, rep
+}
+
+func TestUserService_ResetPasswordOnce(t *testing.T) {
+	storages := map[string]func() kv.Storage{
+		"locked": func() kv.Storage { return tokenutil.NewMemoryStorage() },
+		"cas":    func() kv.Storage { return kv.MustNew(kv.Config{Driver: kv.MEMORY}) },
+	}
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, rep := newFileDB(t)
+			service := NewUserService(rep, storage(), UserServiceConfig{Policy: authorize.PasswordPolicy{RequireDigit: true}})
+			if _, err := service.Register(ctx, "alice", "s3cret-pass", nil); err != nil {
+				t.Fatal(err)
+			}
+			token, err := service.CreateResetToken(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = service.ResetPassword(ctx, token, "weak"); !errors.Is(err, authorize.ErrWeakPassword) {
+				t.Errorf("weak password accepted: %v", err)
+			}
+
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var reset int
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					err := service.ResetPassword(ctx, token, "r3set-password-"+strconv.Itoa(i))
+					if err != nil && err != ErrInvalidResetToken {
+						t.Error(err)
+					}
+					mu.Lock()
+					if err == nil {
+						reset++
+					}
+					mu.Unlock()
+				}(i)
+			}
+			wg.Wait()
+			if reset != 1 {
+				t.Errorf("reset token used %d times", reset)
+			}
+		})
+	}
+}
+
+func TestUserService_RegisterRace(t *testing.T) {
+	ctx := context.Background()
+	db, rep := newFileDB(t)
+	service := NewUserService(rep, tokenutil.NewMemoryStorage(), UserServiceConfig{})
+	// another registration takes the username between the check and the insert
+	raced := false
+	err := db.Callback().Create().Before("gorm:create").Register("test:race", func(tx *gorm.DB) {
+		if raced {
+			return
+		}
+		raced = true
+		if err := db.Create(&User{ID: "other", Username: "alice", Password: "x"}).Error; err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = service.Register(ctx, "alice", "s3cret-pass", nil); err != ErrUsernameTaken {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestUserService_DisableAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	_, rep := newFileDB(t)
+	service := NewUserService(rep, tokenutil.NewMemoryStorage(), UserServiceConfig{})
+	user, err := service.Register(ctx, "alice", "s3cret-pass", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := authorize.New(rep, tokenutil.NewManager(), authorize.WithAPIKeys(authorize.NewAPIKeys(authorize.NewSimpleAPIKeyRepository(), authorize.APIKeyConfig{})))
+	plain, _, err := auth.CreateAPIKey(ctx, user, "ci", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := func() error {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("X-API-Key", plain)
+		_, err := auth.ValidateHTTPRequest(request)
+		return err
+	}
+	if err = validate(); err != nil {
+		t.Fatalf("key rejected: %v", err)
+	}
+	if err = service.Disable(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = validate(); err != authorize.ErrInvalidAPIKey {
+		t.Errorf("key of a disabled user accepted: %v", err)
+	}
+}
+
+type loginRequest struct {
+	username, password string
+}
+
+func (r *loginRequest) GetUsername() string { return r.username }
+func (r *loginRequest) GetPassword() string { return r.password }
+func (r *loginRequest) GetClientID() string { return "web" }
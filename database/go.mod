@@ -5,23 +5,34 @@ go 1.20
 require (
 	github.com/glebarez/sqlite v1.10.0
 	github.com/go-chocolate/configuration/common v0.0.0-20231226080250-a7086d866e72
+	github.com/go-chocolate/contrib/authorize v0.0.0-20261018123140-342e68f36e1c
+	github.com/go-chocolate/contrib/kv v0.0.0-20261018123140-342e68f36e1c
+	github.com/google/uuid v1.3.1
 	github.com/sirupsen/logrus v1.9.3
 	gorm.io/driver/mysql v1.5.2
 	gorm.io/gorm v1.25.5
 )
 
 require (
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/glebarez/go-sqlite v1.21.2 // indirect
 	github.com/go-sql-driver/mysql v1.7.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/redis/go-redis/v9 v9.3.1 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
 	modernc.org/libc v1.22.5 // indirect
 	modernc.org/mathutil v1.5.0 // indirect
 	modernc.org/memory v1.5.0 // indirect
 	modernc.org/sqlite v1.23.1 // indirect
 )
+
+// in-tree development builds against the sibling modules, consumers resolve the versions required above
+replace github.com/go-chocolate/contrib/authorize => ../authorize
+
+replace github.com/go-chocolate/contrib/kv => ../kv
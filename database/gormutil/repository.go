@@ -53,19 +53,19 @@ func (r *Repository[T]) list(cmd *gorm.DB, offset, limit int, order ...any) ([]*
 		cmd = cmd.Order(v)
 	}
 	var dst []*T
-	err := cmd.Offset(offset).Limit(limit).Find(dst).Error
+	err := cmd.Offset(offset).Limit(limit).Find(&dst).Error
 	return dst, count, err
 }
 
 func (r *Repository[T]) FindOne(ctx context.Context, where any) (dst *T, err error) {
 	dst = new(T)
-	err = r.where(r.db, where).Take(dst).Error
+	err = r.where(r.GetDB(ctx), where).Take(dst).Error
 	return
 }
 
 func (r *Repository[T]) List(ctx context.Context, where any, offset, limit int, order ...any) ([]*T, int64, error) {
 	var cmd = r.where(r.GetDB(ctx), where)
-	return r.list(cmd, offset, limit, order)
+	return r.list(cmd, offset, limit, order...)
 }
 
 func (r *Repository[T]) Count(ctx context.Context, where any) (int64, error) {
@@ -0,0 +1,87 @@
+package gormutil
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type repositoryExample struct {
+	ID   int64
+	Name string
+}
+
+func newExampleDB(t *testing.T) *gorm.DB {
+	db, err := Open(MemoryOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	innerDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection opens its own in-memory database
+	innerDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { innerDB.Close() })
+	if err = db.AutoMigrate(&repositoryExample{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c", "a"} {
+		if err = db.Create(&repositoryExample{Name: name}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestRepository_List(t *testing.T) {
+	ctx := context.Background()
+	rep := NewRepository[repositoryExample](newExampleDB(t))
+
+	list, count, err := rep.List(ctx, nil, 0, 2, "name desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || len(list) != 2 || list[0].Name != "c" || list[1].Name != "b" {
+		t.Errorf("unexpected list: %d %+v", count, list)
+	}
+
+	list, _, err = rep.List(ctx, nil, 0, 3, "name", "id desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[0].Name != "a" || list[2].Name != "c" {
+		t.Errorf("unexpected list: %+v", list)
+	}
+
+	list, count, err = rep.List(ctx, map[string]any{"name": "a"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(list) != 1 || list[0].ID != 3 {
+		t.Errorf("unexpected list: %d %+v", count, list)
+	}
+}
+
+func TestRepository_FindOne(t *testing.T) {
+	db := newExampleDB(t)
+	ctx := WithContext(context.Background(), db)
+	rep := NewRepository[repositoryExample](nil)
+
+	v, err := rep.FindOne(ctx, int64(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "c" {
+		t.Errorf("unexpected record: %+v", v)
+	}
+	if _, err = rep.FindOne(ctx, int64(4)); err != gorm.ErrRecordNotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	rep.SetDB(db)
+	if v, err = rep.FindOne(context.Background(), map[string]any{"name": "a"}); err != nil || v.ID != 3 {
+		t.Errorf("unexpected record: %+v %v", v, err)
+	}
+}
@@ -0,0 +1,110 @@
+package kv
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+const (
+	EvictLRU = "lru" // evict the least recently used item
+	EvictLFU = "lfu" // evict the least frequently used item, the least recently used of them on ties
+)
+
+// evictionPolicy orders the items of a size bounded memory storage, the storage lock is held by the caller.
+type evictionPolicy interface {
+	add(item *memoryItem)
+	touch(item *memoryItem)
+	remove(item *memoryItem)
+	// victim returns the item to evict next, nil if there is none.
+	victim() *memoryItem
+}
+
+func newEvictionPolicy(name string) evictionPolicy {
+	if name == EvictLFU {
+		return &lfuPolicy{}
+	}
+	return &lruPolicy{items: list.New()}
+}
+
+type lruPolicy struct {
+	items *list.List // most recently used first
+}
+
+func (p *lruPolicy) add(item *memoryItem) {
+	item.element = p.items.PushFront(item)
+}
+
+func (p *lruPolicy) touch(item *memoryItem) {
+	p.items.MoveToFront(item.element)
+}
+
+func (p *lruPolicy) remove(item *memoryItem) {
+	p.items.Remove(item.element)
+}
+
+func (p *lruPolicy) victim() *memoryItem {
+	if e := p.items.Back(); e != nil {
+		return e.Value.(*memoryItem)
+	}
+	return nil
+}
+
+// lfuPolicy is a min heap of the items by use count and last use.
+type lfuPolicy struct {
+	items []*memoryItem
+	clock uint64
+}
+
+func (p *lfuPolicy) Len() int { return len(p.items) }
+
+func (p *lfuPolicy) Less(i, j int) bool {
+	if p.items[i].uses != p.items[j].uses {
+		return p.items[i].uses < p.items[j].uses
+	}
+	return p.items[i].lastUse < p.items[j].lastUse
+}
+
+func (p *lfuPolicy) Swap(i, j int) {
+	p.items[i], p.items[j] = p.items[j], p.items[i]
+	p.items[i].index = i
+	p.items[j].index = j
+}
+
+func (p *lfuPolicy) Push(x any) {
+	item := x.(*memoryItem)
+	item.index = len(p.items)
+	p.items = append(p.items, item)
+}
+
+func (p *lfuPolicy) Pop() any {
+	item := p.items[len(p.items)-1]
+	p.items[len(p.items)-1] = nil
+	p.items = p.items[:len(p.items)-1]
+	return item
+}
+
+func (p *lfuPolicy) add(item *memoryItem) {
+	// items replaced by put are added again and keep their uses
+	p.clock++
+	item.uses++
+	item.lastUse = p.clock
+	heap.Push(p, item)
+}
+
+func (p *lfuPolicy) touch(item *memoryItem) {
+	p.clock++
+	item.uses++
+	item.lastUse = p.clock
+	heap.Fix(p, item.index)
+}
+
+func (p *lfuPolicy) remove(item *memoryItem) {
+	heap.Remove(p, item.index)
+}
+
+func (p *lfuPolicy) victim() *memoryItem {
+	if len(p.items) == 0 {
+		return nil
+	}
+	return p.items[0]
+}
@@ -1,53 +1,424 @@
 package kv
 
 import (
+	"bytes"
+	"container/list"
 	"context"
 	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// ErrTooLarge is returned by the memory storage for values exceeding MaxBytes on their own, the current value of
+// the key is kept.
+var ErrTooLarge = errors.New("kv: value exceeds the size limit")
+
+// MemoryConfig configures the memory storage, the memory driver reads the options CleanupInterval, MaxEntries,
+// MaxBytes and Eviction. Storages of the driver cannot be closed, the driver runs no janitor unless
+// CleanupInterval is set.
+type MemoryConfig struct {
+	CleanupInterval time.Duration // interval of removing expired items in background, default 1m, negative disables it
+	MaxEntries      int           // maximum number of items, 0 for no limit
+	MaxBytes        int64         // maximum total size of keys and values, 0 for no limit
+	Eviction        string        // EvictLRU or EvictLFU, default EvictLRU
+}
+
+func (c *MemoryConfig) init() {
+	if c.CleanupInterval == 0 {
+		c.CleanupInterval = time.Minute
+	}
+	if c.Eviction == "" {
+		c.Eviction = EvictLRU
+	}
+}
+
+// Stats are the usage counters of a memory storage.
+type Stats struct {
+	Hits        uint64 // keys found by Get and MGet
+	Misses      uint64 // keys not found by Get and MGet
+	Evictions   uint64 // items removed to stay within the limits
+	Expirations uint64 // expired items removed
+	Entries     int    // current number of items, including expired items not removed yet
+	Bytes       int64  // current total size of keys and values
+}
+
 type memoryItem struct {
+	key       string
 	timestamp time.Time
 	data      []byte
+
+	// eviction state, see evictionPolicy
+	element *list.Element
+	index   int
+	uses    uint64
+	lastUse uint64
+}
+
+func (item *memoryItem) expired(now time.Time) bool {
+	return !item.timestamp.IsZero() && !item.timestamp.After(now)
 }
 
-type memoryStorage struct {
-	sync.Mutex
+func (item *memoryItem) size() int64 {
+	return int64(len(item.key) + len(item.data))
+}
+
+// MemoryStorage is a process local AdvancedStorage. Expired items are removed on access and by a background
+// janitor, the least recently or least frequently used items are evicted if the storage exceeds its limits.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	config  MemoryConfig
 	storage map[string]*memoryItem
+	policy  evictionPolicy // nil without limits
+	stats   Stats
+	done    chan struct{}
+	once    sync.Once
+}
+
+var _ AdvancedStorage = (*MemoryStorage)(nil)
+
+// NewMemory returns a memory storage, Close must be called to stop its janitor.
+func NewMemory(config MemoryConfig) (*MemoryStorage, error) {
+	config.init()
+	if config.Eviction != EvictLRU && config.Eviction != EvictLFU {
+		return nil, fmt.Errorf("kv: unknown eviction policy: %s", config.Eviction)
+	}
+	s := &MemoryStorage{config: config, storage: make(map[string]*memoryItem), done: make(chan struct{})}
+	if config.MaxEntries > 0 || config.MaxBytes > 0 {
+		s.policy = newEvictionPolicy(config.Eviction)
+	}
+	if config.CleanupInterval > 0 {
+		go s.janitor(config.CleanupInterval)
+	}
+	return s, nil
+}
+
+// Close stops the janitor, the storage remains usable and removes expired items on access only.
+func (s *MemoryStorage) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// Stats returns the usage counters of the storage.
+func (s *MemoryStorage) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Entries = len(s.storage)
+	return stats
+}
+
+func (s *MemoryStorage) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.removeExpired()
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
 }
 
-func (s *memoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
-	s.Lock()
-	defer s.Unlock()
-	if item, ok := s.storage[key]; ok {
-		if item.timestamp.IsZero() || item.timestamp.After(time.Now()) {
-			return item.data, nil
-		} else {
-			delete(s.storage, key)
+// removeExpired removes all expired items. The caller must hold the lock.
+func (s *MemoryStorage) removeExpired() {
+	now := time.Now()
+	for _, item := range s.storage {
+		if item.expired(now) {
+			s.remove(item)
+			s.stats.Expirations++
 		}
 	}
-	return nil, errors.New("record not found in memory cache")
 }
 
-func (s *memoryStorage) Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error {
-	s.Lock()
-	defer s.Unlock()
-	if len(expiration) > 0 {
-		s.storage[key] = &memoryItem{time.Now().Add(expiration[0]), val}
+// get returns the item of key, expired items are removed. The caller must hold the lock.
+func (s *MemoryStorage) get(key string) *memoryItem {
+	item, ok := s.storage[key]
+	if !ok {
+		return nil
+	}
+	if item.expired(time.Now()) {
+		s.remove(item)
+		s.stats.Expirations++
+		return nil
+	}
+	return item
+}
+
+// lookup is get counting hits and misses, the item counts as used. The caller must hold the lock.
+func (s *MemoryStorage) lookup(key string) *memoryItem {
+	item := s.get(key)
+	if item == nil {
+		s.stats.Misses++
+		return nil
+	}
+	s.stats.Hits++
+	if s.policy != nil {
+		s.policy.touch(item)
+	}
+	return item
+}
+
+// put sets the item of key and evicts other items to stay within the limits. Items exceeding MaxBytes on their
+// own are rejected with ErrTooLarge. The caller must hold the lock.
+func (s *MemoryStorage) put(key string, data []byte, timestamp time.Time) error {
+	if s.tooLarge(key, data) {
+		return ErrTooLarge
+	}
+	item := s.storage[key]
+	if item != nil {
+		s.remove(item)
 	} else {
-		s.storage[key] = &memoryItem{time.Time{}, val}
+		item = &memoryItem{key: key}
+	}
+	item.data, item.timestamp = data, timestamp
+	size := item.size()
+	for s.policy != nil && len(s.storage) > 0 && (s.config.MaxEntries > 0 && len(s.storage) >= s.config.MaxEntries ||
+		s.config.MaxBytes > 0 && s.stats.Bytes+size > s.config.MaxBytes) {
+		s.remove(s.policy.victim())
+		s.stats.Evictions++
+	}
+	s.storage[key] = item
+	s.stats.Bytes += size
+	if s.policy != nil {
+		s.policy.add(item)
 	}
 	return nil
 }
 
-func (s *memoryStorage) Del(ctx context.Context, keys ...string) error {
-	s.Lock()
-	defer s.Unlock()
+func (s *MemoryStorage) tooLarge(key string, data []byte) bool {
+	return s.config.MaxBytes > 0 && int64(len(key)+len(data)) > s.config.MaxBytes
+}
+
+// remove removes the item. The caller must hold the lock.
+func (s *MemoryStorage) remove(item *memoryItem) {
+	delete(s.storage, item.key)
+	s.stats.Bytes -= item.size()
+	if s.policy != nil {
+		s.policy.remove(item)
+	}
+}
+
+func (s *MemoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item := s.lookup(key); item != nil {
+		return item.data, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(key, val, expiresAt(expiration))
+}
+
+func (s *MemoryStorage) CompareAndSwap(ctx context.Context, key string, old, val []byte, expiration ...time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.get(key)
+	if (item != nil) != (old != nil) || item != nil && !bytes.Equal(item.data, old) {
+		return false, nil
+	}
+	if val == nil {
+		if item != nil {
+			s.remove(item)
+		}
+	} else if err := s.put(key, val, expiresAt(expiration)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// expiresAt returns the expiry of an item set with the expiration, zero if it does not expire.
+func expiresAt(expiration []time.Duration) time.Time {
+	if len(expiration) > 0 && expiration[0] > 0 {
+		return time.Now().Add(expiration[0])
+	}
+	return time.Time{}
+}
+
+func (s *MemoryStorage) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	for _, key := range keys {
-		delete(s.storage, key)
+		if item, ok := s.storage[key]; ok {
+			s.remove(item)
+		}
 	}
 	return nil
 }
 
-func memoryDriver(c Option) (Storage, error) { return &memoryStorage{}, nil }
+func (s *MemoryStorage) Incr(ctx context.Context, key string) (int64, error) {
+	return s.IncrBy(ctx, key, 1)
+}
+
+func (s *MemoryStorage) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var value int64
+	var timestamp time.Time
+	if item := s.get(key); item != nil {
+		var err error
+		if value, err = strconv.ParseInt(string(item.data), 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+		timestamp = item.timestamp
+	}
+	if n > 0 && value > math.MaxInt64-n || n < 0 && value < math.MinInt64-n {
+		return 0, ErrNotInteger
+	}
+	value += n
+	if err := s.put(key, []byte(strconv.FormatInt(value, 10)), timestamp); err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+func (s *MemoryStorage) SetNX(ctx context.Context, key string, val []byte, expiration ...time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.get(key) != nil {
+		return false, nil
+	}
+	if err := s.put(key, val, expiresAt(expiration)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *MemoryStorage) GetSet(ctx context.Context, key string, val []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var old []byte
+	if item := s.get(key); item != nil {
+		old = item.data
+	}
+	if err := s.put(key, val, time.Time{}); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func (s *MemoryStorage) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.get(key)
+	if item == nil {
+		return false, nil
+	}
+	if expiration <= 0 {
+		s.remove(item)
+	} else {
+		item.timestamp = time.Now().Add(expiration)
+	}
+	return true, nil
+}
+
+func (s *MemoryStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := s.get(key)
+	if item == nil {
+		return 0, ErrNotFound
+	}
+	if item.timestamp.IsZero() {
+		return NoExpiration, nil
+	}
+	return time.Until(item.timestamp), nil
+}
+
+func (s *MemoryStorage) Exists(ctx context.Context, keys ...string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if s.get(key) != nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStorage) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if item := s.lookup(key); item != nil {
+			values[i] = item.data
+		}
+	}
+	return values, nil
+}
+
+func (s *MemoryStorage) MSet(ctx context.Context, values map[string][]byte, expiration ...time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, val := range values {
+		if s.tooLarge(key, val) {
+			return ErrTooLarge
+		}
+	}
+	timestamp := expiresAt(expiration)
+	for key, val := range values {
+		_ = s.put(key, val, timestamp)
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Keys(ctx context.Context, pattern string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired()
+	var keys []string
+	for key := range s.storage {
+		if matchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *MemoryStorage) Scan(ctx context.Context, pattern string, fn func(key string) bool) error {
+	// fn is called without holding the lock, it may use the storage
+	keys, err := s.Keys(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(key) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func memoryDriver(c Option) (Storage, error) {
+	config := MemoryConfig{
+		CleanupInterval: c.Duration("CleanupInterval"),
+		MaxEntries:      int(c.Int64("MaxEntries")),
+		MaxBytes:        c.Int64("MaxBytes"),
+		Eviction:        c.String("Eviction"),
+	}
+	if config.CleanupInterval == 0 {
+		// Storage has no Close, a default janitor would never stop
+		config.CleanupInterval = -1
+	}
+	storage, err := NewMemory(config)
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
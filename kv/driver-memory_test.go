@@ -0,0 +1,154 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newMemory(t *testing.T, config MemoryConfig) *MemoryStorage {
+	s, err := NewMemory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMemory_Janitor(t *testing.T) {
+	ctx := context.Background()
+	s := newMemory(t, MemoryConfig{CleanupInterval: 10 * time.Millisecond})
+	_ = s.Set(ctx, "short", []byte("1"), 20*time.Millisecond)
+	_ = s.Set(ctx, "long", []byte("1"))
+	time.Sleep(100 * time.Millisecond)
+
+	stats := s.Stats()
+	if stats.Entries != 1 || stats.Expirations != 1 || stats.Bytes != int64(len("long")+1) {
+		t.Errorf("expired key not removed: %+v", stats)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Set(ctx, "short", []byte("1"), 20*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if stats = s.Stats(); stats.Entries != 2 {
+		t.Errorf("janitor not stopped: %+v", stats)
+	}
+	if _, err := s.Get(ctx, "short"); err != ErrNotFound {
+		t.Errorf("key not expired: %v", err)
+	}
+}
+
+func TestMemory_LRU(t *testing.T) {
+	ctx := context.Background()
+	s := newMemory(t, MemoryConfig{MaxEntries: 3})
+	for _, key := range []string{"a", "b", "c"} {
+		_ = s.Set(ctx, key, []byte(key))
+	}
+	_, _ = s.Get(ctx, "a")
+	_ = s.Set(ctx, "b", []byte("b"))
+	_ = s.Set(ctx, "d", []byte("d"))
+
+	if keys, _ := s.Keys(ctx, "*"); joinKeys(keys) != "a,b,d" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if stats := s.Stats(); stats.Evictions != 1 || stats.Entries != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemory_LFU(t *testing.T) {
+	ctx := context.Background()
+	s := newMemory(t, MemoryConfig{MaxEntries: 3, Eviction: EvictLFU})
+	for _, key := range []string{"a", "b", "c"} {
+		_ = s.Set(ctx, key, []byte(key))
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = s.Get(ctx, "a")
+		_, _ = s.MGet(ctx, "c")
+	}
+	_, _ = s.Get(ctx, "b")
+	_ = s.Set(ctx, "d", []byte("d"))
+	_ = s.Set(ctx, "e", []byte("e"))
+
+	if keys, _ := s.Keys(ctx, "*"); joinKeys(keys) != "a,c,e" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestMemory_MaxBytes(t *testing.T) {
+	ctx := context.Background()
+	s := newMemory(t, MemoryConfig{MaxBytes: 30})
+	for i := 0; i < 5; i++ {
+		_ = s.Set(ctx, strconv.Itoa(i), []byte("123456789"))
+	}
+	if keys, _ := s.Keys(ctx, "*"); joinKeys(keys) != "2,3,4" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if stats := s.Stats(); stats.Bytes != 30 || stats.Evictions != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	large := make([]byte, 30)
+	if err := s.Set(ctx, "4", large); err != ErrTooLarge {
+		t.Errorf("stored item larger than MaxBytes: %v", err)
+	}
+	if swapped, err := s.CompareAndSwap(ctx, "4", []byte("123456789"), large); swapped || err != ErrTooLarge {
+		t.Errorf("swapped item larger than MaxBytes: %v %v", swapped, err)
+	}
+	if err := s.MSet(ctx, map[string][]byte{"5": []byte("1"), "6": large}); err != ErrTooLarge {
+		t.Errorf("stored items larger than MaxBytes: %v", err)
+	}
+	if val, err := s.Get(ctx, "4"); err != nil || string(val) != "123456789" {
+		t.Errorf("old item not kept: %s %v", val, err)
+	}
+	if stats := s.Stats(); stats.Bytes != 30 || stats.Entries != 3 || stats.Evictions != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemory_Stats(t *testing.T) {
+	ctx := context.Background()
+	s := newMemory(t, MemoryConfig{})
+	_ = s.Set(ctx, "a", []byte("1"))
+	_, _ = s.Get(ctx, "a")
+	_, _ = s.Get(ctx, "missing")
+	_, _ = s.MGet(ctx, "a", "b", "c")
+	_, _ = s.Exists(ctx, "a", "missing")
+
+	expected := Stats{Hits: 2, Misses: 3, Entries: 1, Bytes: 2}
+	if stats := s.Stats(); stats != expected {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestMemoryDriver(t *testing.T) {
+	storage, err := New(Config{Driver: MEMORY, Option: Option{
+		"CleanupInterval": "-1s",
+		"MaxEntries":      int64(10),
+		"MaxBytes":        "1024",
+		"Eviction":        "lfu",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := storage.(*MemoryStorage)
+	defer s.Close()
+	expected := MemoryConfig{CleanupInterval: -time.Second, MaxEntries: 10, MaxBytes: 1024, Eviction: EvictLFU}
+	if s.config != expected {
+		t.Errorf("unexpected config: %+v", s.config)
+	}
+	if storage, err = New(Config{Driver: MEMORY, Option: Option{}}); err != nil {
+		t.Fatal(err)
+	}
+	if s = storage.(*MemoryStorage); s.config.CleanupInterval >= 0 {
+		t.Errorf("driver storage runs a janitor: %+v", s.config)
+	}
+	if _, err = New(Config{Driver: MEMORY, Option: Option{"Eviction": "fifo"}}); err == nil {
+		t.Error("unknown eviction policy accepted")
+	}
+}
@@ -2,7 +2,11 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,6 +17,8 @@ type redisStorage struct {
 	client redis.UniversalClient
 }
 
+var _ AdvancedStorage = (*redisStorage)(nil)
+
 func redisDriver(c Option) (Storage, error) {
 	var options = redis.UniversalOptions{
 		Addrs:            c.Strings("Addrs"),
@@ -60,7 +66,11 @@ func (s *redisStorage) key(key string) string {
 }
 
 func (s *redisStorage) Get(ctx context.Context, key string) ([]byte, error) {
-	return s.client.Get(ctx, s.key(key)).Bytes()
+	b, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return b, err
 }
 
 func (s *redisStorage) Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error {
@@ -72,5 +82,192 @@ func (s *redisStorage) Set(ctx context.Context, key string, val []byte, expirati
 }
 
 func (s *redisStorage) Del(ctx context.Context, keys ...string) error {
-	return s.client.Del(ctx, keys...).Err()
+	return s.client.Del(ctx, s.keys(keys)...).Err()
+}
+
+// compareAndSwapScript replaces KEYS[1] if it holds ARGV[2], or does not exist if ARGV[1] is 1. ARGV[3] of 1 deletes
+// the key, else it is set to ARGV[4] expiring in ARGV[5] milliseconds, never if 0.
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+	if current then return 0 end
+elseif current ~= ARGV[2] then
+	return 0
+end
+if ARGV[3] == '1' then
+	redis.call('DEL', KEYS[1])
+elseif tonumber(ARGV[5]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[4], 'PX', ARGV[5])
+else
+	redis.call('SET', KEYS[1], ARGV[4])
+end
+return 1
+`)
+
+func (s *redisStorage) CompareAndSwap(ctx context.Context, key string, old, val []byte, expiration ...time.Duration) (bool, error) {
+	var exp time.Duration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	flag := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	n, err := compareAndSwapScript.Run(ctx, s.client, []string{s.key(key)},
+		flag(old == nil), old, flag(val == nil), val, exp.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *redisStorage) Incr(ctx context.Context, key string) (int64, error) {
+	return s.IncrBy(ctx, key, 1)
+}
+
+func (s *redisStorage) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	value, err := s.client.IncrBy(ctx, s.key(key), n).Result()
+	if err != nil && (strings.Contains(err.Error(), "not an integer") || strings.Contains(err.Error(), "overflow")) {
+		return 0, ErrNotInteger
+	}
+	return value, err
+}
+
+func (s *redisStorage) SetNX(ctx context.Context, key string, val []byte, expiration ...time.Duration) (bool, error) {
+	var exp time.Duration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	return s.client.SetNX(ctx, s.key(key), val, exp).Result()
+}
+
+func (s *redisStorage) GetSet(ctx context.Context, key string, val []byte) ([]byte, error) {
+	old, err := s.client.GetSet(ctx, s.key(key), val).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return old, err
+}
+
+func (s *redisStorage) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	if expiration <= 0 {
+		n, err := s.client.Del(ctx, s.key(key)).Result()
+		return n > 0, err
+	}
+	return s.client.PExpire(ctx, s.key(key), expiration).Result()
+}
+
+func (s *redisStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s.key(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2:
+		return 0, ErrNotFound
+	case -1:
+		return NoExpiration, nil
+	}
+	return ttl, nil
+}
+
+func (s *redisStorage) Exists(ctx context.Context, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return s.client.Exists(ctx, s.keys(keys)...).Result()
+}
+
+func (s *redisStorage) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return [][]byte{}, nil
+	}
+	results, err := s.client.MGet(ctx, s.keys(keys)...).Result()
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(results))
+	for i, v := range results {
+		if text, ok := v.(string); ok {
+			values[i] = []byte(text)
+		}
+	}
+	return values, nil
+}
+
+// MSet sets the values in a transaction, in a cluster all keys must belong to the same slot.
+func (s *redisStorage) MSet(ctx context.Context, values map[string][]byte, expiration ...time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var exp time.Duration
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, val := range values {
+			pipe.Set(ctx, s.key(key), val, exp)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStorage) Keys(ctx context.Context, pattern string) ([]string, error) {
+	var keys []string
+	err := s.Scan(ctx, pattern, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// errStopScan ends a scan stopped by its callback.
+var errStopScan = errors.New("kv: scan stopped")
+
+// Scan iterates the keys with SCAN, in a cluster the masters are scanned concurrently and fn is serialized.
+func (s *redisStorage) Scan(ctx context.Context, pattern string, fn func(key string) bool) error {
+	var mu sync.Mutex
+	var stopped bool
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		iter := client.Scan(ctx, 0, s.pattern(pattern), 100).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			stopped = stopped || !fn(iter.Val()[len(s.key("")):])
+			ok := !stopped
+			mu.Unlock()
+			if !ok {
+				return errStopScan
+			}
+		}
+		return iter.Err()
+	}
+	var err error
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, s.client)
+	}
+	if err == errStopScan {
+		return nil
+	}
+	return err
+}
+
+func (s *redisStorage) keys(keys []string) []string {
+	var ks = make([]string, 0, len(keys))
+	for _, key := range keys {
+		ks = append(ks, s.key(key))
+	}
+	return ks
+}
+
+// pattern returns the pattern matching keys of the storage prefix.
+func (s *redisStorage) pattern(pattern string) string {
+	if s.prefix == "" {
+		return pattern
+	}
+	return escapePattern(s.key("")) + pattern
 }
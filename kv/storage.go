@@ -2,16 +2,88 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 )
 
+// ErrNotFound is returned by Get for missing or expired keys.
+var ErrNotFound = errors.New("kv: key not found")
+
 type Storage interface {
 	Get(ctx context.Context, key string) ([]byte, error)
 	Set(ctx context.Context, key string, val []byte, expiration ...time.Duration) error
 	Del(ctx context.Context, keys ...string) error
 }
 
+// CompareAndSwapper is implemented by storages able to replace a value atomically, the memory and redis
+// storages implement it.
+type CompareAndSwapper interface {
+	// CompareAndSwap sets key to val if it currently holds old and reports whether it did. A nil old requires
+	// the key to be missing, a nil val deletes the key.
+	CompareAndSwap(ctx context.Context, key string, old, val []byte, expiration ...time.Duration) (bool, error)
+}
+
+// ErrNotInteger is returned by IncrBy if the value is not an integer or the result would overflow.
+var ErrNotInteger = errors.New("kv: value is not an integer or out of range")
+
+// NoExpiration is the TTL of keys without expiry.
+const NoExpiration time.Duration = -1
+
+// Counter is implemented by storages able to increment integer values atomically. Missing keys count from 0,
+// the expiry of existing keys is kept.
+type Counter interface {
+	Incr(ctx context.Context, key string) (int64, error)
+	IncrBy(ctx context.Context, key string, n int64) (int64, error)
+}
+
+// ConditionalSetter is implemented by storages able to set values depending on the current value.
+type ConditionalSetter interface {
+	// SetNX sets key only if it does not exist and reports whether it did.
+	SetNX(ctx context.Context, key string, val []byte, expiration ...time.Duration) (bool, error)
+	// GetSet sets key and returns its previous value, nil if it did not exist. The key does not expire afterwards.
+	GetSet(ctx context.Context, key string, val []byte) ([]byte, error)
+}
+
+// Expirer is implemented by storages able to query and change the expiry of keys.
+type Expirer interface {
+	// Expire sets the expiry of key and reports whether it exists, a non-positive expiration deletes the key.
+	Expire(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	// TTL returns the remaining time to live of key, NoExpiration if it does not expire or ErrNotFound.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Exists returns how many of the keys exist, keys given more than once are counted each time.
+	Exists(ctx context.Context, keys ...string) (int64, error)
+}
+
+// BatchStorage is implemented by storages able to get and set several keys in one round trip.
+type BatchStorage interface {
+	// MGet returns the values of the keys in order, nil for missing keys.
+	MGet(ctx context.Context, keys ...string) ([][]byte, error)
+	// MSet sets all values, the expiration applies to every key.
+	MSet(ctx context.Context, values map[string][]byte, expiration ...time.Duration) error
+}
+
+// Scanner is implemented by storages able to list keys by a redis glob pattern, e.g. "user:*" or "h?llo".
+type Scanner interface {
+	// Keys returns all keys matching pattern, Scan should be preferred for large storages.
+	Keys(ctx context.Context, pattern string) ([]string, error)
+	// Scan calls fn for the keys matching pattern until it returns false. Keys changed during the scan may be
+	// missed or passed more than once.
+	Scan(ctx context.Context, pattern string, fn func(key string) bool) error
+}
+
+// AdvancedStorage is a Storage with all capabilities, the memory and redis storages implement it with the
+// same semantics.
+type AdvancedStorage interface {
+	Storage
+	CompareAndSwapper
+	Counter
+	ConditionalSetter
+	Expirer
+	BatchStorage
+	Scanner
+}
+
 func New(c Config) (Storage, error) {
 	if c.Driver == "" {
 		c.Driver = REDIS
@@ -36,8 +108,17 @@ type prefixStorage struct {
 	storage Storage
 }
 
+// Prefix returns a storage prepending prefix to all keys, it is an AdvancedStorage or supports compare and swap
+// if storage does.
 func Prefix(prefix string, storage Storage) Storage {
-	return &prefixStorage{prefix: prefix, storage: storage}
+	s := &prefixStorage{prefix: prefix, storage: storage}
+	if advanced, ok := storage.(AdvancedStorage); ok {
+		return &advancedPrefixStorage{casPrefixStorage: &casPrefixStorage{prefixStorage: s, cas: advanced}, storage: advanced}
+	}
+	if cas, ok := storage.(CompareAndSwapper); ok {
+		return &casPrefixStorage{prefixStorage: s, cas: cas}
+	}
+	return s
 }
 
 func (s *prefixStorage) Get(ctx context.Context, key string) ([]byte, error) {
@@ -49,9 +130,83 @@ func (s *prefixStorage) Set(ctx context.Context, key string, val []byte, expirat
 }
 
 func (s *prefixStorage) Del(ctx context.Context, keys ...string) error {
+	return s.storage.Del(ctx, s.keys(keys)...)
+}
+
+func (s *prefixStorage) keys(keys []string) []string {
 	var ks = make([]string, 0, len(keys))
 	for i := range keys {
 		ks = append(ks, s.prefix+keys[i])
 	}
-	return s.storage.Del(ctx, ks...)
+	return ks
+}
+
+type casPrefixStorage struct {
+	*prefixStorage
+	cas CompareAndSwapper
+}
+
+func (s *casPrefixStorage) CompareAndSwap(ctx context.Context, key string, old, val []byte, expiration ...time.Duration) (bool, error) {
+	return s.cas.CompareAndSwap(ctx, s.prefix+key, old, val, expiration...)
+}
+
+type advancedPrefixStorage struct {
+	*casPrefixStorage
+	storage AdvancedStorage
+}
+
+var _ AdvancedStorage = (*advancedPrefixStorage)(nil)
+
+func (s *advancedPrefixStorage) Incr(ctx context.Context, key string) (int64, error) {
+	return s.storage.Incr(ctx, s.prefix+key)
+}
+
+func (s *advancedPrefixStorage) IncrBy(ctx context.Context, key string, n int64) (int64, error) {
+	return s.storage.IncrBy(ctx, s.prefix+key, n)
+}
+
+func (s *advancedPrefixStorage) SetNX(ctx context.Context, key string, val []byte, expiration ...time.Duration) (bool, error) {
+	return s.storage.SetNX(ctx, s.prefix+key, val, expiration...)
+}
+
+func (s *advancedPrefixStorage) GetSet(ctx context.Context, key string, val []byte) ([]byte, error) {
+	return s.storage.GetSet(ctx, s.prefix+key, val)
+}
+
+func (s *advancedPrefixStorage) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	return s.storage.Expire(ctx, s.prefix+key, expiration)
+}
+
+func (s *advancedPrefixStorage) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return s.storage.TTL(ctx, s.prefix+key)
+}
+
+func (s *advancedPrefixStorage) Exists(ctx context.Context, keys ...string) (int64, error) {
+	return s.storage.Exists(ctx, s.keys(keys)...)
+}
+
+func (s *advancedPrefixStorage) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	return s.storage.MGet(ctx, s.keys(keys)...)
+}
+
+func (s *advancedPrefixStorage) MSet(ctx context.Context, values map[string][]byte, expiration ...time.Duration) error {
+	prefixed := make(map[string][]byte, len(values))
+	for k, v := range values {
+		prefixed[s.prefix+k] = v
+	}
+	return s.storage.MSet(ctx, prefixed, expiration...)
+}
+
+func (s *advancedPrefixStorage) Keys(ctx context.Context, pattern string) ([]string, error) {
+	keys, err := s.storage.Keys(ctx, escapePattern(s.prefix)+pattern)
+	for i := range keys {
+		keys[i] = keys[i][len(s.prefix):]
+	}
+	return keys, err
+}
+
+func (s *advancedPrefixStorage) Scan(ctx context.Context, pattern string, fn func(key string) bool) error {
+	return s.storage.Scan(ctx, escapePattern(s.prefix)+pattern, func(key string) bool {
+		return fn(key[len(s.prefix):])
+	})
 }
@@ -0,0 +1,315 @@
+package kv
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type storageCase struct {
+	storage AdvancedStorage
+	wait    func(d time.Duration) // lets time pass for the storage
+}
+
+func storageCases(t *testing.T) map[string]func() storageCase {
+	sleep := func(d time.Duration) { time.Sleep(d) }
+	newRedis := func(prefix string) storageCase {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return storageCase{&redisStorage{prefix: prefix, client: client}, server.FastForward}
+	}
+	newMemory := func(option Option) AdvancedStorage {
+		storage, err := memoryDriver(option)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { storage.(*MemoryStorage).Close() })
+		return storage.(AdvancedStorage)
+	}
+	return map[string]func() storageCase{
+		"memory":        func() storageCase { return storageCase{newMemory(nil), sleep} },
+		"memory/prefix": func() storageCase { return storageCase{Prefix("p[1]:", newMemory(nil)).(AdvancedStorage), sleep} },
+		"memory/bounded": func() storageCase {
+			return storageCase{newMemory(Option{"MaxEntries": int64(1000), "MaxBytes": int64(1 << 20), "Eviction": EvictLFU}), sleep}
+		},
+		"redis":        func() storageCase { return newRedis("") },
+		"redis/prefix": func() storageCase { return newRedis("app*") },
+		"redis/wrapped": func() storageCase {
+			c := newRedis("")
+			return storageCase{Prefix("p[1]:", c.storage).(AdvancedStorage), c.wait}
+		},
+	}
+}
+
+// TestConformance verifies that all storages implement AdvancedStorage with the same semantics.
+func TestConformance(t *testing.T) {
+	for name, newCase := range storageCases(t) {
+		t.Run(name, func(t *testing.T) {
+			for test, fn := range map[string]func(t *testing.T, c storageCase){
+				"basic":   testBasic,
+				"cas":     testCompareAndSwap,
+				"counter": testCounter,
+				"setnx":   testConditionalSet,
+				"expiry":  testExpiry,
+				"batch":   testBatch,
+				"scan":    testScan,
+			} {
+				t.Run(test, func(t *testing.T) {
+					fn(t, newCase())
+				})
+			}
+		})
+	}
+}
+
+func testBasic(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	if _, err := s.Get(ctx, "a"); err != ErrNotFound {
+		t.Errorf("missing key: %v", err)
+	}
+	if err := s.Set(ctx, "a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("unexpected value: %s %v", v, err)
+	}
+	if err := s.Del(ctx, "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "a"); err != ErrNotFound {
+		t.Errorf("deleted key: %v", err)
+	}
+}
+
+func testCompareAndSwap(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	if ok, err := s.CompareAndSwap(ctx, "a", nil, []byte("1")); !ok || err != nil {
+		t.Errorf("swap of missing key failed: %v", err)
+	}
+	if ok, _ := s.CompareAndSwap(ctx, "a", nil, []byte("2")); ok {
+		t.Error("swapped existing key as missing")
+	}
+	if ok, _ := s.CompareAndSwap(ctx, "a", []byte("2"), []byte("3")); ok {
+		t.Error("swapped different value")
+	}
+	if ok, _ := s.CompareAndSwap(ctx, "a", []byte("1"), nil); !ok {
+		t.Error("delete by swap failed")
+	}
+	if n, _ := s.Exists(ctx, "a"); n != 0 {
+		t.Error("key not deleted by swap")
+	}
+	if ok, err := s.CompareAndSwap(ctx, "a", nil, nil); !ok || err != nil {
+		t.Errorf("delete of missing key by swap failed: %v", err)
+	}
+}
+
+func testCounter(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	if n, err := s.Incr(ctx, "n"); n != 1 || err != nil {
+		t.Errorf("unexpected counter: %d %v", n, err)
+	}
+	if n, err := s.IncrBy(ctx, "n", -5); n != -4 || err != nil {
+		t.Errorf("unexpected counter: %d %v", n, err)
+	}
+	if v, _ := s.Get(ctx, "n"); string(v) != "-4" {
+		t.Errorf("unexpected value: %s", v)
+	}
+	_ = s.Set(ctx, "text", []byte("abc"))
+	if _, err := s.Incr(ctx, "text"); err != ErrNotInteger {
+		t.Errorf("incremented text: %v", err)
+	}
+	_ = s.Set(ctx, "max", []byte(strconv.FormatInt(1<<63-1, 10)))
+	if _, err := s.Incr(ctx, "max"); err != ErrNotInteger {
+		t.Errorf("overflow: %v", err)
+	}
+
+	_ = s.Set(ctx, "ttl", []byte("1"), time.Minute)
+	if _, err := s.Incr(ctx, "ttl"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, _ := s.TTL(ctx, "ttl"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expiry not kept: %v", ttl)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Incr(ctx, "concurrent"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := s.Get(ctx, "concurrent"); string(v) != "50" {
+		t.Errorf("lost increments: %s", v)
+	}
+}
+
+func testConditionalSet(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	if ok, err := s.SetNX(ctx, "lock", []byte("a"), time.Minute); !ok || err != nil {
+		t.Errorf("setnx failed: %v", err)
+	}
+	if ok, _ := s.SetNX(ctx, "lock", []byte("b")); ok {
+		t.Error("setnx replaced existing key")
+	}
+	if ttl, _ := s.TTL(ctx, "lock"); ttl <= 0 {
+		t.Errorf("unexpected ttl: %v", ttl)
+	}
+	if old, err := s.GetSet(ctx, "lock", []byte("c")); string(old) != "a" || err != nil {
+		t.Errorf("unexpected old value: %s %v", old, err)
+	}
+	if ttl, _ := s.TTL(ctx, "lock"); ttl != NoExpiration {
+		t.Errorf("getset kept expiry: %v", ttl)
+	}
+	if old, err := s.GetSet(ctx, "new", []byte("d")); old != nil || err != nil {
+		t.Errorf("unexpected old value of missing key: %s %v", old, err)
+	}
+	if v, _ := s.Get(ctx, "new"); string(v) != "d" {
+		t.Errorf("getset did not set: %s", v)
+	}
+}
+
+func testExpiry(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	_ = s.Set(ctx, "short", []byte("1"), 50*time.Millisecond)
+	_ = s.Set(ctx, "long", []byte("1"))
+	if ttl, err := s.TTL(ctx, "long"); ttl != NoExpiration || err != nil {
+		t.Errorf("unexpected ttl: %v %v", ttl, err)
+	}
+	if _, err := s.TTL(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("ttl of missing key: %v", err)
+	}
+	if ok, err := s.Expire(ctx, "long", time.Minute); !ok || err != nil {
+		t.Errorf("expire failed: %v", err)
+	}
+	if ttl, _ := s.TTL(ctx, "long"); ttl <= 59*time.Second || ttl > time.Minute {
+		t.Errorf("unexpected ttl: %v", ttl)
+	}
+	if ok, _ := s.Expire(ctx, "missing", time.Minute); ok {
+		t.Error("expired missing key")
+	}
+	if n, _ := s.Exists(ctx, "short", "long", "missing", "long"); n != 3 {
+		t.Errorf("unexpected count: %d", n)
+	}
+
+	c.wait(100 * time.Millisecond)
+	if _, err := s.Get(ctx, "short"); err != ErrNotFound {
+		t.Errorf("key not expired: %v", err)
+	}
+	if n, _ := s.Exists(ctx, "short"); n != 0 {
+		t.Error("expired key exists")
+	}
+	if ok, _ := s.Expire(ctx, "long", 0); !ok {
+		t.Error("expire of existing key failed")
+	}
+	if n, _ := s.Exists(ctx, "long"); n != 0 {
+		t.Error("key not deleted by non-positive expiration")
+	}
+}
+
+func testBatch(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	if err := s.MSet(ctx, map[string][]byte{"a": []byte("1"), "b": []byte("2")}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.MGet(ctx, "a", "missing", "b")
+	if err != nil || len(values) != 3 || string(values[0]) != "1" || values[1] != nil || string(values[2]) != "2" {
+		t.Errorf("unexpected values: %q %v", values, err)
+	}
+	if ttl, _ := s.TTL(ctx, "b"); ttl <= 0 {
+		t.Errorf("mset ignored expiration: %v", ttl)
+	}
+	if values, err = s.MGet(ctx); len(values) != 0 || err != nil {
+		t.Errorf("unexpected values: %q %v", values, err)
+	}
+	if err = s.MSet(ctx, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func testScan(t *testing.T, c storageCase) {
+	ctx := context.Background()
+	s := c.storage
+	for _, key := range []string{"user:1", "user:2", "user:10", "order:1", "hello", "hallo", "hxllo", "a*b"} {
+		_ = s.Set(ctx, key, []byte("1"))
+	}
+	_ = s.Set(ctx, "user:expired", []byte("1"), 50*time.Millisecond)
+	c.wait(100 * time.Millisecond)
+
+	cases := map[string]string{
+		"user:*":    "user:1,user:10,user:2",
+		"user:?":    "user:1,user:2",
+		"h[ae]llo":  "hallo,hello",
+		"h[^e]llo":  "hallo,hxllo",
+		"h[a-f]llo": "hallo,hello",
+		`a\*b`:      "a*b",
+		"missing*":  "",
+	}
+	for pattern, expected := range cases {
+		keys, err := s.Keys(ctx, pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if joined := joinKeys(keys); joined != expected {
+			t.Errorf("%s: unexpected keys %s", pattern, joined)
+		}
+	}
+	var scanned []string
+	err := s.Scan(ctx, "user:*", func(key string) bool {
+		scanned = append(scanned, key)
+		return len(scanned) < 2
+	})
+	if err != nil || len(scanned) != 2 {
+		t.Errorf("scan did not stop: %v %v", scanned, err)
+	}
+}
+
+func joinKeys(keys []string) string {
+	var text string
+	for i, key := range keys {
+		if i > 0 {
+			text += ","
+		}
+		text += key
+	}
+	return text
+}
+
+func TestMatchPattern(t *testing.T) {
+	cases := map[[2]string]bool{
+		{"*", ""}:        true,
+		{"a*", "abc"}:    true,
+		{"a*c", "abbbc"}: true,
+		{"a*c", "abbb"}:  false,
+		{"a**", "a"}:     true,
+		{"?", ""}:        false,
+		{"[abc]", "b"}:   true,
+		{"[^abc]", "b"}:  false,
+		{"[z-a]", "m"}:   true,
+		{`[\]]`, "]"}:    true,
+		{`a\?`, "a?"}:    true,
+		{`a\?`, "ab"}:    false,
+		{escapePattern("p[1]*:") + "*", "p[1]*:x"}: true,
+		{escapePattern("p[1]*:") + "*", "p1x:x"}:   false,
+	}
+	for c, expected := range cases {
+		if matchPattern(c[0], c[1]) != expected {
+			t.Errorf("%q %q: expected %v", c[0], c[1], expected)
+		}
+	}
+}